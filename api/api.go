@@ -0,0 +1,215 @@
+// Package api serves queues and downloads over HTTP: JSON resources with
+// actions under /api/v1, a Server-Sent Events stream of progress, an OpenAPI
+// description of both, an aria2 compatible JSON-RPC endpoint at /jsonrpc and
+// a web dashboard at /.
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mjghr/tech-download-manager/manager"
+	"github.com/mjghr/tech-download-manager/ui/logs"
+)
+
+// API_PREFIX is the path every endpoint lives under.
+const API_PREFIX = "/api/v1"
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// Server handles API requests against a DownloadManager it shares with other
+// front ends.
+type Server struct {
+	dm    *manager.DownloadManager
+	lock  sync.Locker
+	token string
+	mux   *http.ServeMux
+
+	// speeds holds the last progress sample of each active download for the
+	// aria2 interface, guarded by lock.
+	speeds map[string]speedSample
+}
+
+// NewServer returns a server using dm while holding lock. A non-empty token
+// must accompany every request except for the OpenAPI description.
+func NewServer(dm *manager.DownloadManager, lock sync.Locker, token string) *Server {
+	s := &Server{dm: dm, lock: lock, token: token, mux: http.NewServeMux(), speeds: map[string]speedSample{}}
+
+	s.mux.HandleFunc("GET "+API_PREFIX+"/openapi.json", s.openAPI)
+	s.mux.HandleFunc("GET "+API_PREFIX+"/events", s.events)
+
+	s.mux.HandleFunc("GET "+API_PREFIX+"/queues", s.listQueues)
+	s.mux.HandleFunc("POST "+API_PREFIX+"/queues", s.createQueue)
+	s.mux.HandleFunc("GET "+API_PREFIX+"/queues/{id}", s.getQueue)
+	s.mux.HandleFunc("PATCH "+API_PREFIX+"/queues/{id}", s.updateQueue)
+	s.mux.HandleFunc("DELETE "+API_PREFIX+"/queues/{id}", s.deleteQueue)
+	s.mux.HandleFunc("POST "+API_PREFIX+"/queues/{id}/{action}", s.queueAction)
+
+	s.mux.HandleFunc("GET "+API_PREFIX+"/downloads", s.listDownloads)
+	s.mux.HandleFunc("POST "+API_PREFIX+"/downloads", s.createDownload)
+	s.mux.HandleFunc("GET "+API_PREFIX+"/downloads/{id}", s.getDownload)
+	s.mux.HandleFunc("PATCH "+API_PREFIX+"/downloads/{id}", s.updateDownload)
+	s.mux.HandleFunc("DELETE "+API_PREFIX+"/downloads/{id}", s.deleteDownload)
+	s.mux.HandleFunc("GET "+API_PREFIX+"/downloads/{id}/history", s.downloadHistory)
+	s.mux.HandleFunc("POST "+API_PREFIX+"/downloads/{id}/{action}", s.downloadAction)
+
+	web := webUI()
+	s.mux.Handle("GET /{$}", web)
+	s.mux.Handle("GET /static/", web)
+
+	s.mux.HandleFunc("POST "+ARIA2_PATH, s.aria2RPC)
+	s.mux.HandleFunc("OPTIONS "+ARIA2_PATH, s.aria2RPC)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Without a token anyone who can reach the listener is trusted, which must
+	// not include web pages from other sites open in a local browser, nor
+	// pages whose own host name was rebound to a loopback address
+	if s.token == "" && !sameOrigin(r) {
+		writeError(w, http.StatusForbidden, "cross-origin requests need api.token to be set")
+		return
+	}
+	if s.token == "" && !loopbackHost(r) {
+		writeError(w, http.StatusForbidden, "requests to "+r.Host+" need api.token to be set")
+		return
+	}
+	// The aria2 interface carries its own token in the request parameters
+	public := r.URL.Path == API_PREFIX+"/openapi.json" || r.URL.Path == ARIA2_PATH || isWebUIPath(r.URL.Path)
+	if !public && !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="tech-download-manager"`)
+		writeError(w, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// sameOrigin reports whether r is not a browser request from another origin.
+// Browsers send Origin with every cross-origin request, including the simple
+// POSTs they make without a preflight.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// loopbackHost reports whether r is addressed to localhost, 127.0.0.1 or
+// [::1] at the port it arrived on. A page on another site whose name now
+// resolves to the loopback address still sends its own name in Host.
+func loopbackHost(r *http.Request) bool {
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host, port = strings.Trim(r.Host, "[]"), "80"
+	}
+	if host != "localhost" && host != "127.0.0.1" && host != "::1" {
+		return false
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		_, localPort, err := net.SplitHostPort(addr.String())
+		return err == nil && port == localPort
+	}
+	return true
+}
+
+// authorized checks the bearer token. Browsers cannot set headers on an
+// EventSource, so the token may also be passed as the "token" query parameter.
+func (s *Server) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		given = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) == 1
+}
+
+// Serve answers requests on listener until ctx ends.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	server := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logs.Log(fmt.Sprintf("API listening on %s", listener.Addr()))
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) openAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// apiError is an error with the HTTP status it should be reported with.
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e *apiError) Error() string {
+	return e.msg
+}
+
+func errorf(status int, format string, args ...any) error {
+	return &apiError{status: status, msg: fmt.Sprintf(format, args...)}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// respond writes v with status, or err with the status it carries.
+func respond(w http.ResponseWriter, status int, v any, err error) {
+	var apiErr *apiError
+	switch {
+	case err == nil && v == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case err == nil:
+		writeJSON(w, status, v)
+	case errors.As(err, &apiErr):
+		writeError(w, apiErr.status, apiErr.msg)
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// decode parses a JSON request body, rejecting unknown fields.
+func decode(r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return errorf(http.StatusBadRequest, "invalid request body: %v", err)
+	}
+	return nil
+}
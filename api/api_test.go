@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestServeHTTPWithoutToken(t *testing.T) {
+	s := NewServer(nil, &sync.Mutex{}, "")
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8089}
+	tests := []struct {
+		name   string
+		host   string
+		origin string
+		want   int
+	}{
+		{"loopback", "127.0.0.1:8089", "", http.StatusOK},
+		{"localhost", "localhost:8089", "", http.StatusOK},
+		{"ipv6 loopback", "[::1]:8089", "", http.StatusOK},
+		{"same origin", "localhost:8089", "http://localhost:8089", http.StatusOK},
+		{"other origin", "localhost:8089", "http://evil.example", http.StatusForbidden},
+		// A rebound name passes the origin check but not the host check
+		{"rebound host", "evil.example:8089", "http://evil.example:8089", http.StatusForbidden},
+		{"rebound host without origin", "evil.example:8089", "", http.StatusForbidden},
+		{"other port", "127.0.0.1:9000", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, API_PREFIX+"/openapi.json", nil)
+		r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, net.Addr(local)))
+		r.Host = tt.host
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestServeHTTPWithToken(t *testing.T) {
+	s := NewServer(nil, &sync.Mutex{}, "s3cret")
+	r := httptest.NewRequest(http.MethodGet, API_PREFIX+"/openapi.json", nil)
+	r.Host = "evil.example:8089"
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("status %d for a public path with a token set, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,691 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mjghr/tech-download-manager/controller"
+)
+
+// ARIA2_PATH is where aria2 front ends expect the JSON-RPC endpoint.
+const ARIA2_PATH = "/jsonrpc"
+
+// ARIA2_VERSION is the aria2 version reported to clients that check for one.
+const ARIA2_VERSION = "1.37.0"
+
+// aria2 error codes; aria2 reports nearly every failure as 1.
+const (
+	RPC_PARSE_ERROR      = -32700
+	RPC_INVALID_REQUEST  = -32600
+	RPC_METHOD_NOT_FOUND = -32601
+	RPC_INVALID_PARAMS   = -32602
+	ARIA2_ERROR          = 1
+)
+
+type rpcRequest struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+func rpcErrorf(code int, format string, args ...any) *rpcError {
+	return &rpcError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// aria2RPC implements the aria2 JSON-RPC interface over HTTP POST, single
+// calls and batches alike, so aria2 front ends can drive the manager. Like
+// aria2 it authenticates with a "token:SECRET" first parameter rather than a
+// header. Browser front ends on any origin may reach it only when a token is
+// set; without one ServeHTTP turns away pages from other origins.
+func (s *Server) aria2RPC(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: rpcErrorf(RPC_PARSE_ERROR, "%v", err)})
+		return
+	}
+
+	if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "[") {
+		var batch []rpcRequest
+		if err := json.Unmarshal(body, &batch); err != nil {
+			writeJSON(w, http.StatusBadRequest, rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: rpcErrorf(RPC_PARSE_ERROR, "%v", err)})
+			return
+		}
+		responses := make([]rpcResponse, len(batch))
+		for i, req := range batch {
+			responses[i] = s.aria2Respond(req)
+		}
+		writeJSON(w, http.StatusOK, responses)
+		return
+	}
+
+	var req rpcRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: rpcErrorf(RPC_PARSE_ERROR, "%v", err)})
+		return
+	}
+	resp := s.aria2Respond(req)
+	status := http.StatusOK
+	if resp.Error != nil {
+		// aria2 answers failed calls with 400
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, resp)
+}
+
+func (s *Server) aria2Respond(req rpcRequest) rpcResponse {
+	resp := rpcResponse{JSONRPC: "2.0", ID: req.ID}
+	if resp.ID == nil {
+		resp.ID = json.RawMessage("null")
+	}
+	if req.Method == "" {
+		resp.Error = rpcErrorf(RPC_INVALID_REQUEST, "missing method")
+		return resp
+	}
+	result, err := s.aria2Call(req.Method, req.Params)
+	if err != nil {
+		resp.Error = err
+	} else {
+		resp.Result = result
+	}
+	return resp
+}
+
+// aria2Call authenticates and runs one method.
+func (s *Server) aria2Call(method string, params []json.RawMessage) (any, *rpcError) {
+	// system.* methods carry the token inside each multicall entry instead
+	if method == "system.listMethods" {
+		return aria2Methods(), nil
+	}
+	if method == "system.multicall" {
+		return s.aria2Multicall(params)
+	}
+
+	var token string
+	if len(params) > 0 {
+		var first string
+		if json.Unmarshal(params[0], &first) == nil && strings.HasPrefix(first, "token:") {
+			token = strings.TrimPrefix(first, "token:")
+			params = params[1:]
+		}
+	}
+	if s.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		return nil, rpcErrorf(ARIA2_ERROR, "Unauthorized")
+	}
+
+	switch method {
+	case "aria2.getVersion":
+		return map[string]any{"version": ARIA2_VERSION, "enabledFeatures": []string{"HTTPS"}}, nil
+	case "aria2.addUri":
+		return s.aria2AddURI(params)
+	case "aria2.tellStatus":
+		return s.aria2TellStatus(params)
+	case "aria2.tellActive":
+		return s.aria2TellActive(params)
+	case "aria2.tellWaiting":
+		return s.aria2TellList(params, func(status controller.Status) bool {
+			return status == controller.NOT_STARTED || status == controller.PAUSED
+		})
+	case "aria2.tellStopped":
+		return s.aria2TellList(params, finished)
+	case "aria2.pause", "aria2.forcePause":
+		return s.aria2Pause(params)
+	case "aria2.unpause":
+		return s.aria2Unpause(params)
+	case "aria2.pauseAll", "aria2.forcePauseAll":
+		return s.aria2All(controller.ONGOING, s.aria2PauseDownload)
+	case "aria2.unpauseAll":
+		return s.aria2All(controller.PAUSED, s.aria2UnpauseDownload)
+	case "aria2.remove", "aria2.forceRemove":
+		return s.aria2Remove(params)
+	case "aria2.removeDownloadResult":
+		return s.aria2RemoveResult(params)
+	case "aria2.purgeDownloadResult":
+		return s.aria2PurgeResults()
+	case "aria2.getGlobalStat":
+		return s.aria2GlobalStat(), nil
+	case "aria2.changeOption":
+		return s.aria2ChangeOption(params)
+	default:
+		return nil, rpcErrorf(RPC_METHOD_NOT_FOUND, "No such method: %s", method)
+	}
+}
+
+func aria2Methods() []string {
+	return []string{
+		"aria2.addUri", "aria2.remove", "aria2.forceRemove", "aria2.pause", "aria2.forcePause",
+		"aria2.pauseAll", "aria2.forcePauseAll", "aria2.unpause", "aria2.unpauseAll",
+		"aria2.tellStatus", "aria2.tellActive", "aria2.tellWaiting", "aria2.tellStopped",
+		"aria2.changeOption", "aria2.getGlobalStat", "aria2.purgeDownloadResult",
+		"aria2.removeDownloadResult", "aria2.getVersion", "system.multicall", "system.listMethods",
+	}
+}
+
+// aria2Multicall runs [{"methodName", "params"}...], wrapping each result in
+// a one element array and reporting failures as error structs, as aria2 does.
+func (s *Server) aria2Multicall(params []json.RawMessage) (any, *rpcError) {
+	var calls []struct {
+		MethodName string            `json:"methodName"`
+		Params     []json.RawMessage `json:"params"`
+	}
+	if len(params) != 1 || json.Unmarshal(params[0], &calls) != nil {
+		return nil, rpcErrorf(RPC_INVALID_PARAMS, "system.multicall expects an array of calls")
+	}
+	results := make([]any, len(calls))
+	for i, call := range calls {
+		if call.MethodName == "system.multicall" {
+			results[i] = rpcErrorf(ARIA2_ERROR, "Recursive system.multicall forbidden.")
+			continue
+		}
+		result, err := s.aria2Call(call.MethodName, call.Params)
+		if err != nil {
+			results[i] = err
+		} else {
+			results[i] = []any{result}
+		}
+	}
+	return results, nil
+}
+
+// aria2Param decodes params[i] into v, leaving v alone if it is absent.
+func aria2Param(params []json.RawMessage, i int, v any) *rpcError {
+	if i >= len(params) {
+		return nil
+	}
+	if err := json.Unmarshal(params[i], v); err != nil {
+		return rpcErrorf(RPC_INVALID_PARAMS, "invalid parameter %d: %v", i+1, err)
+	}
+	return nil
+}
+
+// gid derives a stable 16 hex digit aria2 GID from a download ID.
+func gid(id string) string {
+	if nanos, err := strconv.ParseUint(strings.TrimPrefix(id, "dc-"), 10, 64); err == nil {
+		return fmt.Sprintf("%016x", nanos)
+	}
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// findGID must be called with the lock held.
+func (s *Server) findGID(params []json.RawMessage) (*controller.QueueController, *controller.DownloadController, *rpcError) {
+	var g string
+	if len(params) == 0 {
+		return nil, nil, rpcErrorf(RPC_INVALID_PARAMS, "GID is required")
+	}
+	if err := aria2Param(params, 0, &g); err != nil {
+		return nil, nil, err
+	}
+	for _, queue := range s.dm.QueueList {
+		for _, dc := range queue.Downloads() {
+			if gid(dc.ID) == g {
+				return queue, dc, nil
+			}
+		}
+	}
+	return nil, nil, rpcErrorf(ARIA2_ERROR, "GID %s is not found", g)
+}
+
+// aria2AddURI adds and starts a download. The "dir" option picks the queue
+// saving there, which is created with the default settings if there is none,
+// otherwise the first queue is used; "out" names the file and
+// "max-download-limit" limits its speed. Other options are ignored. A
+// duplicate is handled as download.on_duplicate says.
+func (s *Server) aria2AddURI(params []json.RawMessage) (any, *rpcError) {
+	var uris []string
+	var options map[string]string
+	if err := aria2Param(params, 0, &uris); err != nil {
+		return nil, err
+	}
+	if err := aria2Param(params, 1, &options); err != nil {
+		return nil, err
+	}
+	if len(uris) == 0 {
+		return nil, rpcErrorf(ARIA2_ERROR, "No URI to download.")
+	}
+	// Mirrors are not supported, the first URI is downloaded
+	u, err := url.Parse(uris[0])
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, rpcErrorf(ARIA2_ERROR, "Unrecognized URI or unsupported protocol: %s", uris[0])
+	}
+	speedLimit := -1
+	if limit, ok := options["max-download-limit"]; ok {
+		if speedLimit, err = parseAria2Size(limit); err != nil {
+			return nil, rpcErrorf(ARIA2_ERROR, "%v", err)
+		}
+	}
+
+	// Probe the URL without holding up other clients
+	dc := s.dm.NewDownloadController(u)
+	if dc.Status == controller.FAILED {
+		return nil, rpcErrorf(ARIA2_ERROR, "could not get file details for %s", u)
+	}
+	if out := options["out"]; out != "" {
+		dc.FileName = filepath.Base(out)
+	}
+	if speedLimit >= 0 {
+		dc.SpeedLimit = speedLimit
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var queue *controller.QueueController
+	created := false
+	if dir := options["dir"]; dir != "" {
+		dir = filepath.Clean(dir)
+		for _, q := range s.dm.QueueList {
+			if filepath.Clean(q.SavePath) == dir {
+				queue = q
+				break
+			}
+		}
+		if queue == nil {
+			queue = controller.NewQueueController(s.dm.UniqueQueueName(filepath.Base(dir)))
+			queue.SavePath = dir
+			if err := s.dm.CreateQueue(queue); err != nil {
+				return nil, rpcErrorf(ARIA2_ERROR, "%v", err)
+			}
+			created = true
+		}
+	} else if len(s.dm.QueueList) > 0 {
+		queue = s.dm.QueueList[0]
+	} else {
+		return nil, rpcErrorf(ARIA2_ERROR, "there are no queues, create one first")
+	}
+
+	added, err := s.dm.AddProbed(queue, dc, controller.DefaultDuplicateAction())
+	// A queue created for a download that was not added is not kept
+	if created && len(queue.Downloads()) == 0 {
+		if removeErr := s.dm.RemoveQueue(queue.QueueID); removeErr != nil && err == nil {
+			err = removeErr
+		}
+	}
+	if err != nil {
+		return nil, rpcErrorf(ARIA2_ERROR, "%v", err)
+	}
+	// A reused download keeps its queue, and may be running or finished
+	if queue, _, err = s.dm.FindDownload(added.ID); err != nil {
+		return nil, rpcErrorf(ARIA2_ERROR, "%v", err)
+	}
+	if added.GetStatus() == controller.NOT_STARTED {
+		if err := queue.StartDownload(added.ID); err != nil {
+			return nil, rpcErrorf(ARIA2_ERROR, "%v", err)
+		}
+	}
+	if err := s.dm.SaveDownload(added); err != nil {
+		return nil, rpcErrorf(ARIA2_ERROR, "%v", err)
+	}
+	return gid(added.ID), nil
+}
+
+// parseAria2Size parses aria2 sizes such as "0", "512K" or "2M".
+func parseAria2Size(value string) (int, error) {
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(value, "K"), strings.HasSuffix(value, "k"):
+		multiplier = 1024
+	case strings.HasSuffix(value, "M"), strings.HasSuffix(value, "m"):
+		multiplier = 1024 * 1024
+	}
+	if multiplier != 1 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * multiplier, nil
+}
+
+// aria2Status maps a status onto aria2's active, waiting, paused, error,
+// complete and removed.
+func aria2Status(status controller.Status) string {
+	switch status {
+	case controller.ONGOING, controller.VERIFYING:
+		return "active"
+	case controller.PAUSED:
+		return "paused"
+	case controller.FAILED:
+		return "error"
+	case controller.COMPLETED:
+		return "complete"
+	case controller.CANCELED:
+		return "removed"
+	default:
+		return "waiting"
+	}
+}
+
+// aria2Struct renders a download as an aria2 status struct, limited to keys
+// if any are given. aria2 sends every number as a string. Must be called with
+// the lock held.
+func (s *Server) aria2Struct(queue *controller.QueueController, dc *controller.DownloadController, keys []string) map[string]any {
+	completed, total := dc.Progress()
+	status := dc.GetStatus()
+
+	speed := s.speed(dc.ID, status, completed)
+
+	dc.Mutex.Lock()
+	fileName, rawURL, speedLimit, chunks := dc.FileName, dc.Url, dc.SpeedLimit, len(dc.Chunks)
+	dc.Mutex.Unlock()
+
+	connections := 0
+	if status == controller.ONGOING {
+		connections = chunks
+	}
+	all := map[string]any{
+		"gid":             gid(dc.ID),
+		"status":          aria2Status(status),
+		"totalLength":     strconv.Itoa(total),
+		"completedLength": strconv.Itoa(completed),
+		"uploadLength":    "0",
+		"downloadSpeed":   strconv.Itoa(speed),
+		"uploadSpeed":     "0",
+		"connections":     strconv.Itoa(connections),
+		"numPieces":       strconv.Itoa(chunks),
+		"dir":             queue.SavePath,
+		"files": []map[string]any{{
+			"index":           "1",
+			"path":            filepath.Join(queue.SavePath, fileName),
+			"length":          strconv.Itoa(total),
+			"completedLength": strconv.Itoa(completed),
+			"selected":        "true",
+			"uris":            []map[string]string{{"uri": rawURL, "status": "used"}},
+		}},
+		"maxDownloadLimit": strconv.Itoa(speedLimit),
+	}
+	if status == controller.FAILED {
+		all["errorCode"] = "1"
+		all["errorMessage"] = "download failed"
+	}
+	if len(keys) == 0 {
+		return all
+	}
+	picked := make(map[string]any, len(keys))
+	for _, key := range keys {
+		if v, ok := all[key]; ok {
+			picked[key] = v
+		}
+	}
+	return picked
+}
+
+func (s *Server) aria2TellStatus(params []json.RawMessage) (any, *rpcError) {
+	var keys []string
+	if err := aria2Param(params, 1, &keys); err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	queue, dc, err := s.findGID(params)
+	if err != nil {
+		return nil, err
+	}
+	return s.aria2Struct(queue, dc, keys), nil
+}
+
+func (s *Server) aria2TellActive(params []json.RawMessage) (any, *rpcError) {
+	var keys []string
+	if err := aria2Param(params, 0, &keys); err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	list := make([]map[string]any, 0)
+	for _, queue := range s.dm.QueueList {
+		for _, dc := range queue.Downloads() {
+			if aria2Status(dc.GetStatus()) == "active" {
+				list = append(list, s.aria2Struct(queue, dc, keys))
+			}
+		}
+	}
+	return list, nil
+}
+
+// aria2TellList serves tellWaiting and tellStopped: offset, num and keys over
+// the downloads matching include. A negative offset counts from the end and
+// walks backwards, as in aria2.
+func (s *Server) aria2TellList(params []json.RawMessage, include func(controller.Status) bool) (any, *rpcError) {
+	var offset, num int
+	var keys []string
+	if len(params) < 2 {
+		return nil, rpcErrorf(RPC_INVALID_PARAMS, "offset and num are required")
+	}
+	for i, v := range []any{&offset, &num, &keys} {
+		if err := aria2Param(params, i, v); err != nil {
+			return nil, err
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	type entry struct {
+		queue *controller.QueueController
+		dc    *controller.DownloadController
+	}
+	var matched []entry
+	for _, queue := range s.dm.QueueList {
+		for _, dc := range queue.Downloads() {
+			if include(dc.GetStatus()) {
+				matched = append(matched, entry{queue, dc})
+			}
+		}
+	}
+
+	list := make([]map[string]any, 0)
+	step := 1
+	if offset < 0 {
+		offset = len(matched) + offset
+		step = -1
+	}
+	for i := offset; i >= 0 && i < len(matched) && len(list) < num; i += step {
+		list = append(list, s.aria2Struct(matched[i].queue, matched[i].dc, keys))
+	}
+	return list, nil
+}
+
+// aria2PauseDownload must be called with the lock held.
+func (s *Server) aria2PauseDownload(queue *controller.QueueController, dc *controller.DownloadController) error {
+	switch dc.GetStatus() {
+	case controller.ONGOING:
+		dc.Pause()
+	case controller.NOT_STARTED:
+		if err := dc.SetStatus(controller.PAUSED); err != nil {
+			return fmt.Errorf("GID#%s cannot be paused now", gid(dc.ID))
+		}
+	default:
+		return fmt.Errorf("GID#%s cannot be paused now", gid(dc.ID))
+	}
+	return s.dm.SaveDownload(dc)
+}
+
+// aria2UnpauseDownload must be called with the lock held.
+func (s *Server) aria2UnpauseDownload(queue *controller.QueueController, dc *controller.DownloadController) error {
+	if dc.GetStatus() != controller.PAUSED {
+		return fmt.Errorf("GID#%s cannot be unpaused now", gid(dc.ID))
+	}
+	if err := queue.ResumeDownload(dc.ID); err != nil {
+		return err
+	}
+	return s.dm.SaveDownload(dc)
+}
+
+func (s *Server) aria2Pause(params []json.RawMessage) (any, *rpcError) {
+	return s.aria2One(params, s.aria2PauseDownload)
+}
+
+func (s *Server) aria2Unpause(params []json.RawMessage) (any, *rpcError) {
+	return s.aria2One(params, s.aria2UnpauseDownload)
+}
+
+func (s *Server) aria2Remove(params []json.RawMessage) (any, *rpcError) {
+	return s.aria2One(params, func(queue *controller.QueueController, dc *controller.DownloadController) error {
+		if finished(dc.GetStatus()) {
+			return fmt.Errorf("Active Download not found for GID#%s", gid(dc.ID))
+		}
+		if err := queue.CancelDownload(dc.ID); err != nil {
+			return err
+		}
+		return s.dm.SaveDownload(dc)
+	})
+}
+
+func (s *Server) aria2RemoveResult(params []json.RawMessage) (any, *rpcError) {
+	if _, err := s.aria2One(params, func(queue *controller.QueueController, dc *controller.DownloadController) error {
+		if !finished(dc.GetStatus()) {
+			return fmt.Errorf("Could not remove download result of GID#%s", gid(dc.ID))
+		}
+		return s.dm.RemoveDownload(dc.ID)
+	}); err != nil {
+		return nil, err
+	}
+	return "OK", nil
+}
+
+// aria2One applies action to the download named by the GID in params[0] and
+// returns the GID.
+func (s *Server) aria2One(params []json.RawMessage, action func(*controller.QueueController, *controller.DownloadController) error) (any, *rpcError) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	queue, dc, rpcErr := s.findGID(params)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if err := action(queue, dc); err != nil {
+		return nil, rpcErrorf(ARIA2_ERROR, "%v", err)
+	}
+	return gid(dc.ID), nil
+}
+
+// aria2All applies action to every download with the given status.
+func (s *Server) aria2All(status controller.Status, action func(*controller.QueueController, *controller.DownloadController) error) (any, *rpcError) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, queue := range s.dm.QueueList {
+		for _, dc := range queue.Downloads() {
+			if dc.GetStatus() != status {
+				continue
+			}
+			if err := action(queue, dc); err != nil {
+				return nil, rpcErrorf(ARIA2_ERROR, "%v", err)
+			}
+		}
+	}
+	return "OK", nil
+}
+
+func (s *Server) aria2PurgeResults() (any, *rpcError) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var ids []string
+	for _, queue := range s.dm.QueueList {
+		for _, dc := range queue.Downloads() {
+			if finished(dc.GetStatus()) {
+				ids = append(ids, dc.ID)
+			}
+		}
+	}
+	for _, id := range ids {
+		if err := s.dm.RemoveDownload(id); err != nil {
+			return nil, rpcErrorf(ARIA2_ERROR, "%v", err)
+		}
+	}
+	return "OK", nil
+}
+
+func (s *Server) aria2GlobalStat() map[string]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var speed, active, waiting, stopped int
+	for _, queue := range s.dm.QueueList {
+		for _, dc := range queue.Downloads() {
+			switch status := dc.GetStatus(); {
+			case status == controller.ONGOING || status == controller.VERIFYING:
+				active++
+				completed, _ := dc.Progress()
+				speed += s.speed(dc.ID, status, completed)
+			case finished(status):
+				stopped++
+			default:
+				waiting++
+			}
+		}
+	}
+	return map[string]string{
+		"downloadSpeed":   strconv.Itoa(speed),
+		"uploadSpeed":     "0",
+		"numActive":       strconv.Itoa(active),
+		"numWaiting":      strconv.Itoa(waiting),
+		"numStopped":      strconv.Itoa(stopped),
+		"numStoppedTotal": strconv.Itoa(stopped),
+	}
+}
+
+// aria2ChangeOption supports max-download-limit; other options are ignored.
+func (s *Server) aria2ChangeOption(params []json.RawMessage) (any, *rpcError) {
+	var options map[string]string
+	if err := aria2Param(params, 1, &options); err != nil {
+		return nil, err
+	}
+	speedLimit := -1
+	if limit, ok := options["max-download-limit"]; ok {
+		var err error
+		if speedLimit, err = parseAria2Size(limit); err != nil {
+			return nil, rpcErrorf(ARIA2_ERROR, "%v", err)
+		}
+	}
+	if _, err := s.aria2One(params, func(queue *controller.QueueController, dc *controller.DownloadController) error {
+		if speedLimit < 0 {
+			return nil
+		}
+		dc.Mutex.Lock()
+		dc.SpeedLimit = speedLimit
+		dc.Mutex.Unlock()
+		return s.dm.SaveDownload(dc)
+	}); err != nil {
+		return nil, err
+	}
+	return "OK", nil
+}
@@ -0,0 +1,372 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mjghr/tech-download-manager/controller"
+)
+
+// downloadView is the download resource.
+type downloadView struct {
+	ID             string  `json:"id"`
+	QueueID        string  `json:"queueId"`
+	URL            string  `json:"url"`
+	FileName       string  `json:"fileName"`
+	Status         string  `json:"status"`
+	PauseReason    string  `json:"pauseReason,omitempty"`
+	TotalSize      int     `json:"totalSize"`
+	CompletedBytes int     `json:"completedBytes"`
+	Progress       float64 `json:"progress"`
+	Speed          int     `json:"speed"`
+	SpeedLimit     int     `json:"speedLimit"`
+	Priority       int     `json:"priority"`
+}
+
+// downloadView must be called with the lock held.
+func (s *Server) downloadView(queue *controller.QueueController, dc *controller.DownloadController) downloadView {
+	completed, total := dc.Progress()
+	var progress float64
+	if total > 0 {
+		progress = float64(completed) / float64(total) * 100
+	}
+	status := dc.GetStatus()
+	speed := s.speed(dc.ID, status, completed)
+
+	dc.Mutex.Lock()
+	defer dc.Mutex.Unlock()
+	return downloadView{
+		ID:             dc.ID,
+		QueueID:        queue.QueueID,
+		URL:            dc.Url,
+		FileName:       dc.FileName,
+		Status:         status.String(),
+		PauseReason:    dc.PauseReason,
+		TotalSize:      total,
+		CompletedBytes: completed,
+		Progress:       progress,
+		Speed:          speed,
+		SpeedLimit:     dc.SpeedLimit,
+		Priority:       dc.Priority,
+	}
+}
+
+// speedSample is the last progress seen for a download, used to report its
+// download speed.
+type speedSample struct {
+	bytes int
+	at    time.Time
+	speed int
+}
+
+// speed returns the transfer rate of an ongoing download in bytes per second,
+// averaged over the time since it was last sampled, at least a second ago.
+// Must be called with the lock held.
+func (s *Server) speed(id string, status controller.Status, completed int) int {
+	if status != controller.ONGOING {
+		delete(s.speeds, id)
+		return 0
+	}
+	now := time.Now()
+	last, ok := s.speeds[id]
+	if !ok {
+		s.speeds[id] = speedSample{bytes: completed, at: now}
+		return 0
+	}
+	if elapsed := now.Sub(last.at); elapsed >= time.Second {
+		speed := max(0, int(float64(completed-last.bytes)/elapsed.Seconds()))
+		s.speeds[id] = speedSample{bytes: completed, at: now, speed: speed}
+		return speed
+	}
+	return last.speed
+}
+
+type downloadInput struct {
+	URL         string `json:"url"`
+	QueueID     string `json:"queueId"`
+	SpeedLimit  *int   `json:"speedLimit"`
+	OnDuplicate string `json:"onDuplicate"`
+}
+
+// findDownload must be called with the lock held.
+func (s *Server) findDownload(id string) (*controller.QueueController, *controller.DownloadController, error) {
+	queue, dc, err := s.dm.FindDownload(id)
+	if err != nil {
+		return nil, nil, errorf(http.StatusNotFound, "%v", err)
+	}
+	return queue, dc, nil
+}
+
+// listDownloads lists every download, or those of ?queue=ID.
+func (s *Server) listDownloads(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	queues := s.dm.QueueList
+	if id := r.URL.Query().Get("queue"); id != "" {
+		queue, err := s.findQueue(id)
+		if err != nil {
+			respond(w, 0, nil, err)
+			return
+		}
+		queues = []*controller.QueueController{queue}
+	}
+
+	views := make([]downloadView, 0)
+	for _, queue := range queues {
+		for _, dc := range queue.Downloads() {
+			views = append(views, s.downloadView(queue, dc))
+		}
+	}
+	respond(w, http.StatusOK, views, nil)
+}
+
+func (s *Server) getDownload(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	queue, dc, err := s.findDownload(r.PathValue("id"))
+	if err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+	respond(w, http.StatusOK, s.downloadView(queue, dc), nil)
+}
+
+// transitionView is a status change in a download's history.
+type transitionView struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// downloadHistory lists a download's status changes, oldest first.
+func (s *Server) downloadHistory(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, dc, err := s.findDownload(r.PathValue("id"))
+	if err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+	history := dc.StatusHistory()
+	views := make([]transitionView, len(history))
+	for i, t := range history {
+		views[i] = transitionView{From: t.From.String(), To: t.To.String(), At: t.At, Reason: t.Reason}
+	}
+	respond(w, http.StatusOK, views, nil)
+}
+
+// createDownload probes the URL and appends the download to a queue, the
+// first one if none is given. It is not started. A duplicate is reused as
+// onDuplicate says, or refused with 409.
+func (s *Server) createDownload(w http.ResponseWriter, r *http.Request) {
+	var in downloadInput
+	if err := decode(r, &in); err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+	u, err := url.Parse(in.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		respond(w, 0, nil, errorf(http.StatusBadRequest, "invalid URL %q", in.URL))
+		return
+	}
+	if in.SpeedLimit != nil && *in.SpeedLimit < 0 {
+		respond(w, 0, nil, errorf(http.StatusBadRequest, "speedLimit must not be negative"))
+		return
+	}
+	onDuplicate, err := controller.ParseDuplicateAction(in.OnDuplicate)
+	if err != nil {
+		respond(w, 0, nil, errorf(http.StatusBadRequest, "%v", err))
+		return
+	}
+
+	// Probe the URL without holding up other clients
+	dc := s.dm.NewDownloadController(u)
+	if dc.Status == controller.FAILED {
+		respond(w, 0, nil, errorf(http.StatusBadGateway, "could not get file details for %s", u))
+		return
+	}
+	if in.SpeedLimit != nil {
+		dc.SpeedLimit = *in.SpeedLimit
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var queue *controller.QueueController
+	switch {
+	case in.QueueID != "":
+		queue, err = s.findQueue(in.QueueID)
+	case len(s.dm.QueueList) > 0:
+		queue = s.dm.QueueList[0]
+	default:
+		err = errorf(http.StatusConflict, "there are no queues, create one first")
+	}
+	if err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+
+	added, err := s.dm.AddProbed(queue, dc, onDuplicate)
+	var duplicate *controller.DuplicateError
+	if errors.As(err, &duplicate) {
+		respond(w, 0, nil, errorf(http.StatusConflict, "%v", duplicate))
+		return
+	}
+	if err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+
+	// A reused download keeps its queue
+	status := http.StatusCreated
+	if added != dc {
+		status = http.StatusOK
+		queue, _, _ = s.dm.FindDownload(added.ID)
+	}
+	w.Header().Set("Location", API_PREFIX+"/downloads/"+added.ID)
+	respond(w, status, s.downloadView(queue, added), nil)
+}
+
+func (s *Server) updateDownload(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		SpeedLimit *int    `json:"speedLimit"`
+		Priority   *int    `json:"priority"`
+		Position   *int    `json:"position"`
+		QueueID    *string `json:"queueId"`
+	}
+	if err := decode(r, &in); err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+	if in.SpeedLimit != nil && *in.SpeedLimit < 0 {
+		respond(w, 0, nil, errorf(http.StatusBadRequest, "speedLimit must not be negative"))
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	queue, dc, err := s.findDownload(r.PathValue("id"))
+	if err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+	if in.QueueID != nil && *in.QueueID != queue.QueueID {
+		target, err := s.findQueue(*in.QueueID)
+		if err != nil {
+			respond(w, 0, nil, err)
+			return
+		}
+		if err := s.dm.MoveDownloadToQueue(dc.ID, target.QueueID); err != nil {
+			respond(w, 0, nil, errorf(http.StatusConflict, "%v", err))
+			return
+		}
+		queue = target
+	}
+	if in.Position != nil {
+		if err := s.dm.MoveDownload(dc.ID, *in.Position); err != nil {
+			respond(w, 0, nil, err)
+			return
+		}
+	}
+	if in.Priority != nil {
+		if err := s.dm.SetDownloadPriority(dc.ID, *in.Priority); err != nil {
+			respond(w, 0, nil, err)
+			return
+		}
+	}
+	if in.SpeedLimit != nil {
+		dc.Mutex.Lock()
+		dc.SpeedLimit = *in.SpeedLimit
+		dc.Mutex.Unlock()
+	}
+	respond(w, http.StatusOK, s.downloadView(queue, dc), s.dm.SaveDownload(dc))
+}
+
+// deleteDownload cancels a download and forgets it.
+func (s *Server) deleteDownload(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, _, err := s.findDownload(r.PathValue("id")); err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+	respond(w, 0, nil, s.dm.RemoveDownload(r.PathValue("id")))
+}
+
+// downloadAction applies start, pause, resume, cancel, retry or restart to a
+// download.
+func (s *Server) downloadAction(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	queue, dc, err := s.findDownload(r.PathValue("id"))
+	if err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+
+	status := dc.GetStatus()
+	conflict := func(action string) error {
+		return errorf(http.StatusConflict, "cannot %s download %s: it is %s", action, dc.ID, status)
+	}
+
+	switch action := r.PathValue("action"); action {
+	case "start":
+		if status != controller.NOT_STARTED {
+			err = conflict(action)
+		} else {
+			err = queue.StartDownload(dc.ID)
+		}
+	case "pause":
+		switch status {
+		case controller.ONGOING:
+			dc.Pause()
+		case controller.NOT_STARTED:
+			err = dc.SetStatus(controller.PAUSED)
+		default:
+			err = conflict(action)
+		}
+	case "resume":
+		if status != controller.PAUSED {
+			err = conflict(action)
+		} else {
+			err = queue.ResumeDownload(dc.ID)
+		}
+	case "cancel":
+		if finished(status) {
+			err = conflict(action)
+		} else {
+			err = queue.CancelDownload(dc.ID)
+		}
+	case "retry":
+		if status != controller.FAILED && status != controller.CANCELED {
+			err = conflict(action)
+		} else if err = dc.PrepareRetry(queue.TempPath); err == nil {
+			err = queue.StartDownload(dc.ID)
+		}
+	case "restart":
+		err = queue.RestartDownload(dc.ID)
+	default:
+		err = errorf(http.StatusNotFound, "unknown download action %q", action)
+	}
+	var transition *controller.TransitionError
+	if errors.As(err, &transition) {
+		err = errorf(http.StatusConflict, "%v", err)
+	}
+	if err == nil {
+		err = s.dm.SaveDownload(dc)
+	}
+	if err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+	respond(w, http.StatusOK, s.downloadView(queue, dc), nil)
+}
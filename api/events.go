@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+const (
+	// EVENT_INTERVAL is how often downloads are checked for changes.
+	EVENT_INTERVAL = time.Second
+	// KEEPALIVE_INTERVAL is how often an idle stream gets a comment line so
+	// proxies do not close it.
+	KEEPALIVE_INTERVAL = 15 * time.Second
+)
+
+// events streams Server-Sent Events: a "progress" event with the download
+// resource whenever a download changes, starting with all of them, and a
+// "removed" event when one disappears. Queues get "queue" and
+// "queue-removed" events the same way. ?queue=ID limits the stream to a queue.
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	queueID := r.URL.Query().Get("queue")
+	if queueID != "" {
+		s.lock.Lock()
+		_, err := s.findQueue(queueID)
+		s.lock.Unlock()
+		if err != nil {
+			respond(w, 0, nil, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(EVENT_INTERVAL)
+	defer ticker.Stop()
+	lastWrite := time.Now()
+	sent := map[string]downloadView{}
+	sentQueues := map[string]queueView{}
+
+	for {
+		queues, current := s.collect(queueID)
+		wrote := false
+		for id, view := range queues {
+			if previous, ok := sentQueues[id]; ok && reflect.DeepEqual(previous, view) {
+				continue
+			}
+			if err := writeEvent(w, "queue", view); err != nil {
+				return
+			}
+			sentQueues[id] = view
+			wrote = true
+		}
+		for id, view := range current {
+			if previous, ok := sent[id]; ok && previous == view {
+				continue
+			}
+			if err := writeEvent(w, "progress", view); err != nil {
+				return
+			}
+			sent[id] = view
+			wrote = true
+		}
+		for id := range sent {
+			if _, ok := current[id]; !ok {
+				if err := writeEvent(w, "removed", map[string]string{"id": id}); err != nil {
+					return
+				}
+				delete(sent, id)
+				wrote = true
+			}
+		}
+		for id := range sentQueues {
+			if _, ok := queues[id]; !ok {
+				if err := writeEvent(w, "queue-removed", map[string]string{"id": id}); err != nil {
+					return
+				}
+				delete(sentQueues, id)
+				wrote = true
+			}
+		}
+		if !wrote && time.Since(lastWrite) >= KEEPALIVE_INTERVAL {
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			wrote = true
+		}
+		if wrote {
+			flusher.Flush()
+			lastWrite = time.Now()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collect returns the current view of every queue and download, keyed by ID.
+func (s *Server) collect(queueID string) (map[string]queueView, map[string]downloadView) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	queues := map[string]queueView{}
+	views := map[string]downloadView{}
+	for _, queue := range s.dm.QueueList {
+		if queueID != "" && queue.QueueID != queueID {
+			continue
+		}
+		queues[queue.QueueID] = newQueueView(queue)
+		for _, dc := range queue.Downloads() {
+			views[dc.ID] = s.downloadView(queue, dc)
+		}
+	}
+	return queues, views
+}
+
+func writeEvent(w http.ResponseWriter, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
@@ -0,0 +1,300 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mjghr/tech-download-manager/controller"
+)
+
+// queueView is the queue resource: the persisted QueueController fields with
+// the downloads referenced by ID.
+type queueView struct {
+	ID                      string     `json:"queueId"`
+	Name                    string     `json:"name"`
+	SpeedLimit              int        `json:"speedLimit"`
+	ConcurrentDownloadLimit int        `json:"concurrentDownloadLimit"`
+	StartTime               time.Time  `json:"startTime"`
+	EndTime                 time.Time  `json:"endTime"`
+	Schedule                string     `json:"schedule,omitempty"`
+	NextWindowStart         *time.Time `json:"nextWindowStart,omitempty"`
+	NextWindowEnd           *time.Time `json:"nextWindowEnd,omitempty"`
+	Order                   string     `json:"order"`
+	AutoResume              bool       `json:"autoResume"`
+	TempPath                string     `json:"tempPath"`
+	SavePath                string     `json:"savePath"`
+	DownloadIDs             []string   `json:"downloadIds"`
+}
+
+func newQueueView(queue *controller.QueueController) queueView {
+	downloads := queue.Downloads()
+	ids := make([]string, len(downloads))
+	for i, dc := range downloads {
+		ids[i] = dc.ID
+	}
+	view := queueView{
+		ID:                      queue.QueueID,
+		Name:                    queue.QueueName,
+		SpeedLimit:              queue.SpeedLimit,
+		ConcurrentDownloadLimit: queue.ConcurrentDownloadLimit,
+		StartTime:               queue.StartTime,
+		EndTime:                 queue.EndTime,
+		Order:                   string(queue.GetOrder()),
+		AutoResume:              queue.AutoResume,
+		TempPath:                queue.TempPath,
+		SavePath:                queue.SavePath,
+		DownloadIDs:             ids,
+	}
+	if queue.Schedule != nil {
+		view.Schedule = queue.Schedule.String()
+	}
+	if start, end, ok := queue.NextWindow(time.Now()); ok {
+		view.NextWindowStart = &start
+		if !end.IsZero() {
+			view.NextWindowEnd = &end
+		}
+	}
+	return view
+}
+
+// queueInput holds the writable queue fields; nil fields are left unchanged.
+type queueInput struct {
+	Name                    *string    `json:"name"`
+	SpeedLimit              *int       `json:"speedLimit"`
+	ConcurrentDownloadLimit *int       `json:"concurrentDownloadLimit"`
+	StartTime               *time.Time `json:"startTime"`
+	EndTime                 *time.Time `json:"endTime"`
+	Schedule                *string    `json:"schedule"`
+	Order                   *string    `json:"order"`
+	AutoResume              *bool      `json:"autoResume"`
+	TempPath                *string    `json:"tempPath"`
+	SavePath                *string    `json:"savePath"`
+}
+
+// apply validates every field of in before changing queue, so a bad request
+// leaves it as it was.
+func (in queueInput) apply(queue *controller.QueueController) error {
+	if in.Name != nil && *in.Name == "" {
+		return errorf(http.StatusBadRequest, "name must not be empty")
+	}
+	if in.SpeedLimit != nil && *in.SpeedLimit < 0 {
+		return errorf(http.StatusBadRequest, "speedLimit must not be negative")
+	}
+	if in.ConcurrentDownloadLimit != nil && *in.ConcurrentDownloadLimit < 1 {
+		return errorf(http.StatusBadRequest, "concurrentDownloadLimit must be at least 1")
+	}
+	start, end := queue.StartTime, queue.EndTime
+	if in.StartTime != nil {
+		start = *in.StartTime
+	}
+	if in.EndTime != nil {
+		end = *in.EndTime
+	}
+	if (in.StartTime != nil || in.EndTime != nil) && !end.After(start) {
+		return errorf(http.StatusBadRequest, "endTime must be after startTime")
+	}
+	var schedule *controller.Schedule
+	if in.Schedule != nil && *in.Schedule != "" {
+		var err error
+		if schedule, err = controller.ParseSchedule(*in.Schedule); err != nil {
+			return errorf(http.StatusBadRequest, "schedule: %v", err)
+		}
+	}
+	var order controller.OrderPolicy
+	if in.Order != nil {
+		var err error
+		if order, err = controller.ParseOrderPolicy(*in.Order); err != nil {
+			return errorf(http.StatusBadRequest, "order: %v", err)
+		}
+	}
+
+	// Changing paths is the only step that can still fail, so it goes first
+	if in.TempPath != nil || in.SavePath != nil {
+		tempPath, savePath := queue.TempPath, queue.SavePath
+		if in.TempPath != nil {
+			tempPath = *in.TempPath
+		}
+		if in.SavePath != nil {
+			savePath = *in.SavePath
+		}
+		if err := queue.SetPaths(tempPath, savePath); err != nil {
+			return errorf(http.StatusBadRequest, "%v", err)
+		}
+	}
+	if in.Name != nil {
+		queue.SetName(*in.Name)
+	}
+	if in.SpeedLimit != nil {
+		queue.SetSpeedLimit(*in.SpeedLimit)
+	}
+	if in.ConcurrentDownloadLimit != nil {
+		queue.SetConcurrentLimit(*in.ConcurrentDownloadLimit)
+	}
+	if in.StartTime != nil || in.EndTime != nil {
+		queue.SetTimeWindow(start, end)
+	}
+	if in.Schedule != nil {
+		queue.SetSchedule(schedule)
+	}
+	if in.Order != nil {
+		queue.SetOrder(order)
+	}
+	if in.AutoResume != nil {
+		queue.SetAutoResume(*in.AutoResume)
+	}
+	return nil
+}
+
+// findQueue must be called with the lock held.
+func (s *Server) findQueue(id string) (*controller.QueueController, error) {
+	queue, err := s.dm.FindQueue(id)
+	if err != nil {
+		return nil, errorf(http.StatusNotFound, "%v", err)
+	}
+	return queue, nil
+}
+
+func (s *Server) listQueues(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	views := make([]queueView, len(s.dm.QueueList))
+	for i, queue := range s.dm.QueueList {
+		views[i] = newQueueView(queue)
+	}
+	respond(w, http.StatusOK, views, nil)
+}
+
+func (s *Server) getQueue(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	queue, err := s.findQueue(r.PathValue("id"))
+	if err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+	respond(w, http.StatusOK, newQueueView(queue), nil)
+}
+
+func (s *Server) createQueue(w http.ResponseWriter, r *http.Request) {
+	var in queueInput
+	if err := decode(r, &in); err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+	if in.Name == nil || *in.Name == "" {
+		respond(w, 0, nil, errorf(http.StatusBadRequest, "name is required"))
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := s.dm.FindQueue(*in.Name); err == nil {
+		respond(w, 0, nil, errorf(http.StatusConflict, "a queue named %s already exists", *in.Name))
+		return
+	}
+	queue := controller.NewQueueController(*in.Name)
+	if err := in.apply(queue); err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+	if err := s.dm.CreateQueue(queue); err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+	w.Header().Set("Location", API_PREFIX+"/queues/"+queue.QueueID)
+	respond(w, http.StatusCreated, newQueueView(queue), nil)
+}
+
+func (s *Server) updateQueue(w http.ResponseWriter, r *http.Request) {
+	var in queueInput
+	if err := decode(r, &in); err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	queue, err := s.findQueue(r.PathValue("id"))
+	if err == nil {
+		err = in.apply(queue)
+	}
+	if err == nil {
+		err = s.dm.SaveQueue(queue)
+	}
+	if err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+	respond(w, http.StatusOK, newQueueView(queue), nil)
+}
+
+// deleteQueue refuses to remove a queue with unfinished downloads unless
+// ?force=true is given, in which case they are canceled.
+func (s *Server) deleteQueue(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	queue, err := s.findQueue(r.PathValue("id"))
+	if err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+	if r.URL.Query().Get("force") != "true" {
+		for _, dc := range queue.Downloads() {
+			if !finished(dc.GetStatus()) {
+				respond(w, 0, nil, errorf(http.StatusConflict, "queue %s has unfinished downloads, pass force=true to remove it anyway", queue.QueueName))
+				return
+			}
+		}
+	}
+
+	queue.CancelAll()
+	respond(w, 0, nil, s.dm.RemoveQueue(queue.QueueID))
+}
+
+// queueAction applies start, pause, resume or cancel to a whole queue.
+func (s *Server) queueAction(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	queue, err := s.findQueue(r.PathValue("id"))
+	if err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+
+	switch action := r.PathValue("action"); action {
+	case "start":
+		err = queue.StartPending()
+	case "pause":
+		queue.PauseAll()
+	case "resume":
+		for _, dc := range queue.Downloads() {
+			if dc.GetStatus() == controller.PAUSED {
+				if resumeErr := queue.ResumeDownload(dc.ID); resumeErr != nil && err == nil {
+					err = resumeErr
+				}
+			}
+		}
+	case "cancel":
+		err = queue.CancelAll()
+	default:
+		err = errorf(http.StatusNotFound, "unknown queue action %q", action)
+	}
+	if err == nil {
+		err = s.dm.SaveQueues()
+	}
+	if err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+	respond(w, http.StatusOK, newQueueView(queue), nil)
+}
+
+func finished(status controller.Status) bool {
+	return status == controller.COMPLETED || status == controller.CANCELED || status == controller.FAILED
+}
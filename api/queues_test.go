@@ -0,0 +1,29 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mjghr/tech-download-manager/controller"
+)
+
+func TestQueueInputApplyIsAllOrNothing(t *testing.T) {
+	queue := controller.NewQueueController("Default Queue")
+	limit := queue.ConcurrentDownloadLimit
+	for _, body := range []string{
+		`{"name": "renamed", "concurrentDownloadLimit": 4, "schedule": "25:00-02:00"}`,
+		`{"name": "renamed", "speedLimit": 1024, "order": "random"}`,
+		`{"name": "renamed", "concurrentDownloadLimit": 0}`,
+	} {
+		var in queueInput
+		if err := json.Unmarshal([]byte(body), &in); err != nil {
+			t.Fatal(err)
+		}
+		if err := in.apply(queue); err == nil {
+			t.Errorf("apply(%s) succeeded, want an error", body)
+		}
+		if queue.QueueName != "Default Queue" || queue.ConcurrentDownloadLimit != limit || queue.SpeedLimit == 1024 {
+			t.Errorf("apply(%s) changed the queue before failing", body)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// webUI serves the dashboard at / with its assets under /static/. The files
+// hold no data, so they are public; the page asks for the token itself.
+func webUI() http.Handler {
+	files, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServerFS(files)
+}
+
+func isWebUIPath(path string) bool {
+	return path == "/" || strings.HasPrefix(path, "/static/")
+}
@@ -0,0 +1,290 @@
+// Dashboard for the tdm HTTP API: renders queues and downloads from the
+// /api/v1/events stream and drives them through the REST endpoints.
+"use strict";
+
+const API = "/api/v1";
+const queues = new Map();
+const downloads = new Map();
+let token = localStorage.getItem("tdm-token") || "";
+let events = null;
+
+const $ = (selector) => document.querySelector(selector);
+
+function showMessage(text) {
+  const message = $("#message");
+  message.textContent = text;
+  message.hidden = !text;
+}
+
+async function api(method, path, body) {
+  const headers = {};
+  if (token) headers["Authorization"] = "Bearer " + token;
+  if (body !== undefined) headers["Content-Type"] = "application/json";
+  const response = await fetch(API + path, {
+    method,
+    headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  if (response.status === 401) {
+    askForToken();
+    throw new Error("the API token is missing or wrong");
+  }
+  if (response.status === 204) return null;
+  const data = await response.json();
+  if (!response.ok) throw new Error(data.error || response.statusText);
+  return data;
+}
+
+// run performs an action and reports its failure instead of throwing.
+async function run(action) {
+  try {
+    showMessage("");
+    await action();
+  } catch (err) {
+    showMessage(err.message);
+  }
+}
+
+function askForToken() {
+  if (events) events.close();
+  events = null;
+  setConnected(false);
+  $("#token-form").hidden = false;
+}
+
+function setConnected(connected) {
+  const connection = $("#connection");
+  connection.textContent = connected ? "live" : "offline";
+  connection.className = connected ? "online" : "offline";
+}
+
+async function connect() {
+  const [queueList, downloadList] = await Promise.all([api("GET", "/queues"), api("GET", "/downloads")]);
+  queues.clear();
+  downloads.clear();
+  queueList.forEach((queue) => queues.set(queue.queueId, queue));
+  downloadList.forEach((download) => downloads.set(download.id, download));
+  render();
+
+  if (events) events.close();
+  events = new EventSource(API + "/events" + (token ? "?token=" + encodeURIComponent(token) : ""));
+  events.onopen = () => setConnected(true);
+  events.onerror = () => setConnected(false);
+  const update = (map, render) => (event) => {
+    const value = JSON.parse(event.data);
+    map.set(value.id || value.queueId, value);
+    render();
+  };
+  const remove = (map, render) => (event) => {
+    map.delete(JSON.parse(event.data).id);
+    render();
+  };
+  events.addEventListener("progress", update(downloads, renderDownloads));
+  events.addEventListener("removed", remove(downloads, renderDownloads));
+  events.addEventListener("queue", update(queues, render));
+  events.addEventListener("queue-removed", remove(queues, render));
+}
+
+function formatBytes(bytes) {
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let i = 0;
+  while (bytes >= 1024 && i < units.length - 1) {
+    bytes /= 1024;
+    i++;
+  }
+  return (i === 0 ? bytes : bytes.toFixed(1)) + " " + units[i];
+}
+
+function formatSpeed(bytes) {
+  return bytes > 0 ? formatBytes(bytes) + "/s" : "-";
+}
+
+function formatTime(iso) {
+  return new Date(iso).toLocaleString([], { dateStyle: "short", timeStyle: "short" });
+}
+
+// formatWindow shows the queue's current or next time window.
+function formatWindow(queue) {
+  if (!queue.nextWindowStart) return "no upcoming window";
+  const end = queue.nextWindowEnd ? formatTime(queue.nextWindowEnd) : "";
+  const range = formatTime(queue.nextWindowStart) + " - " + end;
+  return queue.schedule ? `${range} (${queue.schedule})` : range;
+}
+
+// toLocalInput converts an RFC 3339 time to a datetime-local value.
+function toLocalInput(iso) {
+  const date = new Date(iso);
+  date.setMinutes(date.getMinutes() - date.getTimezoneOffset());
+  return date.toISOString().slice(0, 16);
+}
+
+function cell(row, content) {
+  const td = row.insertCell();
+  if (content instanceof Node) td.append(content);
+  else td.textContent = content;
+  return td;
+}
+
+function button(label, action) {
+  const b = document.createElement("button");
+  b.textContent = label;
+  b.addEventListener("click", () => run(action));
+  return b;
+}
+
+// downloadActions lists what can be done to a download in its status.
+const downloadActions = {
+  probing: [],
+  not_started: ["start", "pause"],
+  ongoing: ["pause", "cancel", "restart"],
+  verifying: [],
+  paused: ["resume", "cancel", "restart"],
+  failed: ["retry", "restart"],
+  canceled: ["retry", "restart"],
+  completed: ["restart"],
+};
+
+function renderDownloads() {
+  const body = $("#downloads tbody");
+  body.replaceChildren();
+  for (const download of downloads.values()) {
+    const row = body.insertRow();
+    const name = cell(row, download.fileName);
+    name.title = download.url;
+    cell(row, queues.get(download.queueId)?.name || download.queueId);
+    const status = download.status.replace("_", " ") + (download.pauseReason ? ` (${download.pauseReason})` : "");
+    cell(row, status).className = "status " + download.status;
+
+    const progress = document.createElement("progress");
+    progress.max = 100;
+    progress.value = download.progress;
+    const td = cell(row, progress);
+    td.append(" " + download.progress.toFixed(1) + "%");
+
+    cell(row, formatSpeed(download.speed));
+    cell(row, formatBytes(download.completedBytes) + " / " + formatBytes(download.totalSize));
+
+    const actions = cell(row, "");
+    for (const action of downloadActions[download.status] || []) {
+      actions.append(button(action, () => api("POST", `/downloads/${download.id}/${action}`)));
+    }
+    actions.append(button("delete", async () => {
+      if (confirm(`Remove ${download.fileName}?`)) await api("DELETE", `/downloads/${download.id}`);
+    }));
+  }
+}
+
+function renderQueues() {
+  const body = $("#queues tbody");
+  body.replaceChildren();
+  const select = $("#add-download select");
+  const selected = select.value;
+  select.replaceChildren();
+
+  for (const queue of queues.values()) {
+    const row = body.insertRow();
+    cell(row, queue.name);
+    cell(row, queue.concurrentDownloadLimit);
+    cell(row, formatSpeed(queue.speedLimit));
+    cell(row, formatWindow(queue));
+    cell(row, queue.savePath);
+    cell(row, queue.downloadIds.length);
+
+    const actions = cell(row, "");
+    for (const action of ["start", "pause", "resume", "cancel"]) {
+      actions.append(button(action, () => api("POST", `/queues/${queue.queueId}/${action}`)));
+    }
+    actions.append(button("edit", () => editQueue(queue)));
+    actions.append(button("delete", async () => {
+      if (!confirm(`Remove queue ${queue.name} and its downloads?`)) return;
+      await api("DELETE", `/queues/${queue.queueId}?force=true`);
+    }));
+
+    select.add(new Option(queue.name, queue.queueId, false, queue.queueId === selected));
+  }
+}
+
+function render() {
+  renderQueues();
+  renderDownloads();
+}
+
+function editQueue(queue) {
+  const form = $("#queue-form");
+  form.queueId.value = queue.queueId;
+  form.name.value = queue.name;
+  form.concurrentDownloadLimit.value = queue.concurrentDownloadLimit;
+  form.speedLimitKB.value = Math.round(queue.speedLimit / 1024);
+  form.startTime.value = toLocalInput(queue.startTime);
+  form.endTime.value = toLocalInput(queue.endTime);
+  form.schedule.value = queue.schedule || "";
+  form.order.value = queue.order;
+  form.savePath.value = queue.savePath;
+  form.tempPath.value = queue.tempPath;
+  form.querySelector("h2").textContent = "Edit " + queue.name;
+  form.scrollIntoView();
+}
+
+// queueInput collects the filled in fields; empty ones keep their value.
+function queueInput(form) {
+  const input = { name: form.name.value };
+  if (form.concurrentDownloadLimit.value) input.concurrentDownloadLimit = Number(form.concurrentDownloadLimit.value);
+  if (form.speedLimitKB.value) input.speedLimit = Number(form.speedLimitKB.value) * 1024;
+  if (form.startTime.value) input.startTime = new Date(form.startTime.value).toISOString();
+  if (form.endTime.value) input.endTime = new Date(form.endTime.value).toISOString();
+  // An emptied schedule field removes the schedule of an existing queue
+  if (form.schedule.value || form.queueId.value) input.schedule = form.schedule.value;
+  if (form.order.value) input.order = form.order.value;
+  if (form.savePath.value) input.savePath = form.savePath.value;
+  if (form.tempPath.value) input.tempPath = form.tempPath.value;
+  return input;
+}
+
+document.querySelectorAll(".tab").forEach((tab) => {
+  tab.addEventListener("click", () => {
+    document.querySelectorAll(".tab").forEach((t) => t.classList.toggle("active", t === tab));
+    document.querySelectorAll("main section").forEach((section) => {
+      section.hidden = section.id !== tab.dataset.tab;
+    });
+  });
+});
+
+$("#token-form").addEventListener("submit", (event) => {
+  event.preventDefault();
+  token = event.target.token.value;
+  localStorage.setItem("tdm-token", token);
+  event.target.hidden = true;
+  run(connect);
+});
+
+$("#add-download").addEventListener("submit", (event) => {
+  event.preventDefault();
+  const form = event.target;
+  run(async () => {
+    const download = await api("POST", "/downloads", { url: form.url.value, queueId: form.queueId.value });
+    downloads.set(download.id, download);
+    renderDownloads();
+    form.url.value = "";
+  });
+});
+
+$("#queue-form").addEventListener("submit", (event) => {
+  event.preventDefault();
+  const form = event.target;
+  run(async () => {
+    const id = form.queueId.value;
+    const queue = id
+      ? await api("PATCH", `/queues/${id}`, queueInput(form))
+      : await api("POST", "/queues", queueInput(form));
+    queues.set(queue.queueId, queue);
+    render();
+    form.reset();
+  });
+});
+
+$("#queue-form").addEventListener("reset", (event) => {
+  event.target.queueId.value = "";
+  event.target.querySelector("h2").textContent = "New queue";
+});
+
+run(connect);
@@ -0,0 +1,282 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/mjghr/tech-download-manager/controller"
+	"github.com/mjghr/tech-download-manager/manager"
+)
+
+// Exit codes returned by Run.
+const (
+	EXIT_OK      = 0
+	EXIT_FAILURE = 1
+	EXIT_USAGE   = 2
+)
+
+const usageText = `usage: tdm [config flags] <command> [args]
+
+Commands:
+  add URL [--queue Q]             add a download to a queue
+  import FILE [--queue Q]         add every URL of a list file, expanding [1-10] and {a,b}
+  import --session FILE [--dir D] continue an aria2 session or wget -i list, adopting partial files
+  ls [--queue Q]                  list downloads
+  pause ID                        pause a download
+  resume ID                       make a paused download eligible to run again
+  cancel ID                       cancel a download and remove its temp files
+  retry ID                        run a failed or canceled download again from its partial files
+  restart ID                      discard a download's progress and fetch it again from the start
+  history ID                      show when a download changed status, and why
+  move ID top|up|down|bottom|N    change a download's place in its queue
+  move ID --queue Q               move a download to another queue, keeping its progress
+  priority ID N                   start waiting downloads with a higher priority first
+  run [--queue Q]                 run pending downloads until they finish
+  queue ls                        list queues
+  queue create NAME [options]     create a queue
+  queue edit Q [options]          change a queue's settings
+  queue rm Q [--force]            remove a queue and its downloads
+  queue export Q FILE             write a queue and its URLs to a bundle to share
+  queue import FILE [options]     create a queue from a bundle, e.g. with --save-dir D
+  state export|import FILE        copy the state to or from a JSON file
+  daemon                          run downloads in the background, controlled over a socket
+  daemon stop                     stop the running daemon
+
+While a daemon is running, commands and the terminal UI are forwarded to it.
+
+A URL already in a queue, or a file already at its destination, makes add a
+duplicate; add --on-duplicate skip|redownload|resume says what to do with it.
+
+Every command accepts --json for machine-readable output. Q is a queue ID or name.
+Run without a command to start the terminal UI.
+`
+
+// usageError marks errors caused by invalid arguments.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usagef(format string, args ...any) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// command is the state shared by every subcommand.
+type command struct {
+	ctx    context.Context
+	dm     *manager.DownloadManager
+	lock   sync.Locker
+	live   bool
+	dir    string
+	stdout io.Writer
+	stderr io.Writer
+	json   bool
+}
+
+func isHelp(args []string) bool {
+	return len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help"
+}
+
+// Run loads the persisted state into dm, executes the subcommand in args and
+// returns the process exit code.
+func Run(dm *manager.DownloadManager, args []string, stdout, stderr io.Writer) int {
+	if !isHelp(args) {
+		err := dm.LoadQueues()
+		var restored *controller.BackupRestoredError
+		if errors.As(err, &restored) {
+			fmt.Fprintf(stderr, "warning: %v\n", restored)
+			err = nil
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+			return EXIT_FAILURE
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return Execute(ctx, dm, nil, args, stdout, stderr)
+}
+
+// Session describes the client a daemon executes a command for.
+type Session struct {
+	// Lock guards the daemon's manager while the command uses it.
+	Lock sync.Locker
+	// Dir is the client's working directory, used for relative paths.
+	Dir string
+}
+
+// Execute runs the subcommand in args against an already loaded dm and
+// returns the exit code. A non-nil session means dm belongs to a running
+// daemon; ctx then ends when the client that sent the command goes away.
+func Execute(ctx context.Context, dm *manager.DownloadManager, session *Session, args []string, stdout, stderr io.Writer) int {
+	if isHelp(args) {
+		fmt.Fprint(stdout, usageText)
+		return EXIT_OK
+	}
+
+	c := &command{ctx: ctx, dm: dm, lock: &sync.Mutex{}, stdout: stdout, stderr: stderr}
+	if session != nil {
+		c.lock, c.live, c.dir = session.Lock, true, session.Dir
+	}
+
+	c.lock.Lock()
+	err := c.dispatch(args)
+	c.lock.Unlock()
+	var usage *usageError
+	switch {
+	case err == nil:
+		return EXIT_OK
+	case errors.As(err, &usage):
+		fmt.Fprintf(stderr, "error: %v\n\n%s", err, usageText)
+		return EXIT_USAGE
+	case errors.Is(err, errInterrupted):
+		return EXIT_INTERRUPTED
+	case errors.Is(err, errFailed):
+		// The command already reported what failed.
+		return EXIT_FAILURE
+	default:
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return EXIT_FAILURE
+	}
+}
+
+// errFailed is returned by commands that printed their own failure report.
+var errFailed = errors.New("command failed")
+
+func (c *command) dispatch(args []string) error {
+	name, rest := args[0], args[1:]
+	switch name {
+	case "add":
+		return c.add(rest)
+	case "import":
+		return c.importList(rest)
+	case "ls", "list":
+		return c.list(rest)
+	case "pause":
+		return c.pause(rest)
+	case "resume":
+		return c.resume(rest)
+	case "cancel":
+		return c.cancel(rest)
+	case "retry":
+		return c.retry(rest)
+	case "restart":
+		return c.restart(rest)
+	case "history":
+		return c.history(rest)
+	case "move":
+		return c.move(rest)
+	case "priority":
+		return c.priority(rest)
+	case "run":
+		return c.run(rest)
+	case "queue":
+		if len(rest) == 0 {
+			return usagef("queue needs a subcommand: ls, create, edit, rm, export or import")
+		}
+		switch rest[0] {
+		case "ls", "list":
+			return c.queueList(rest[1:])
+		case "create":
+			return c.queueCreate(rest[1:])
+		case "edit":
+			return c.queueEdit(rest[1:])
+		case "rm", "remove":
+			return c.queueRemove(rest[1:])
+		case "export":
+			return c.queueExport(rest[1:])
+		case "import":
+			return c.queueImport(rest[1:])
+		default:
+			return usagef("unknown queue subcommand %q", rest[0])
+		}
+	case "state":
+		return c.state(rest)
+	default:
+		return usagef("unknown command %q", name)
+	}
+}
+
+// newFlagSet returns a flag set for a subcommand with --json already defined.
+func (c *command) newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&c.json, "json", false, "print machine-readable JSON")
+	return fs
+}
+
+// parse parses flags that may appear before, between or after positional
+// arguments and checks the number of positional arguments.
+func parse(fs *flag.FlagSet, args []string, wantArgs int, argNames string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, usagef("%s: %v", fs.Name(), err)
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if wantArgs >= 0 && len(positional) != wantArgs {
+		if wantArgs == 0 {
+			return nil, usagef("%s takes no arguments", fs.Name())
+		}
+		return nil, usagef("usage: tdm %s %s", fs.Name(), argNames)
+	}
+	return positional, nil
+}
+
+// path resolves a file argument against the client's working directory.
+func (c *command) path(name string) string {
+	if c.dir == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(c.dir, name)
+}
+
+func (c *command) printJSON(v any) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printTable writes rows as aligned columns.
+func (c *command) printTable(header []string, rows [][]string) {
+	widths := make([]int, len(header))
+	for i, h := range header {
+		widths[i] = len(h)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+	writeRow := func(row []string) {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = fmt.Sprintf("%-*s", widths[i], cell)
+		}
+		fmt.Fprintln(c.stdout, strings.TrimRight(strings.Join(cells, "  "), " "))
+	}
+	writeRow(header)
+	for _, row := range rows {
+		writeRow(row)
+	}
+}
@@ -0,0 +1,524 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/mjghr/tech-download-manager/controller"
+)
+
+// EXIT_INTERRUPTED is returned when "run" is stopped by a signal or detached
+// from the daemon.
+const EXIT_INTERRUPTED = 130
+
+var errInterrupted = errors.New("interrupted")
+
+// downloadView is the JSON shape of a download.
+type downloadView struct {
+	ID             string  `json:"id"`
+	QueueID        string  `json:"queueId"`
+	Queue          string  `json:"queue"`
+	URL            string  `json:"url"`
+	FileName       string  `json:"fileName"`
+	Status         string  `json:"status"`
+	PauseReason    string  `json:"pauseReason,omitempty"`
+	Priority       int     `json:"priority,omitempty"`
+	CompletedBytes int     `json:"completedBytes"`
+	TotalSize      int     `json:"totalSize"`
+	Progress       float64 `json:"progress"`
+}
+
+func newDownloadView(queue *controller.QueueController, dc *controller.DownloadController) downloadView {
+	completed, total := dc.Progress()
+	var progress float64
+	if total > 0 {
+		progress = float64(completed) / float64(total) * 100
+	}
+	return downloadView{
+		ID:             dc.ID,
+		QueueID:        queue.QueueID,
+		Queue:          queue.QueueName,
+		URL:            dc.Url,
+		FileName:       dc.FileName,
+		Status:         dc.GetStatus().String(),
+		PauseReason:    dc.PauseReason,
+		Priority:       dc.Priority,
+		CompletedBytes: completed,
+		TotalSize:      total,
+		Progress:       progress,
+	}
+}
+
+func (c *command) printDownloads(views []downloadView) error {
+	if c.json {
+		return c.printJSON(views)
+	}
+	rows := make([][]string, len(views))
+	for i, v := range views {
+		status := v.Status
+		if v.PauseReason != "" {
+			status += " (" + v.PauseReason + ")"
+		}
+		rows[i] = []string{v.ID, v.Queue, status, fmt.Sprintf("%.1f%%", v.Progress), formatBytes(v.TotalSize), v.FileName}
+	}
+	c.printTable([]string{"ID", "QUEUE", "STATUS", "PROGRESS", "SIZE", "FILE"}, rows)
+	return nil
+}
+
+func formatBytes(n int) string {
+	return fmt.Sprintf("%.2f MB", float64(n)/1024/1024)
+}
+
+func (c *command) add(args []string) error {
+	fs := c.newFlagSet("add")
+	queueName := fs.String("queue", "", "queue ID or name (defaults to the first queue)")
+	onDuplicate := fs.String("on-duplicate", "", "what to do with a duplicate: ask, skip, redownload or resume (defaults to download.on_duplicate)")
+	positional, err := parse(fs, args, 1, "URL [--queue Q] [--on-duplicate ACTION]")
+	if err != nil {
+		return err
+	}
+
+	parsedURL, err := url.Parse(positional[0])
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return usagef("invalid URL %q", positional[0])
+	}
+	action, err := controller.ParseDuplicateAction(*onDuplicate)
+	if err != nil {
+		return usagef("add: %v", err)
+	}
+
+	queue, err := c.targetQueue(*queueName)
+	if err != nil {
+		return err
+	}
+
+	dc := c.dm.NewDownloadController(parsedURL)
+	if dc.Status == controller.FAILED {
+		return fmt.Errorf("could not get file details for %s", parsedURL)
+	}
+	added, err := c.dm.AddProbed(queue, dc, action)
+	var duplicate *controller.DuplicateError
+	switch {
+	case errors.As(err, &duplicate) && action == controller.DUPLICATE_ASK:
+		return fmt.Errorf("%w; pass --on-duplicate skip, redownload or resume", duplicate)
+	case errors.As(err, &duplicate):
+		if c.json {
+			return c.printJSON(map[string]any{"skipped": duplicate})
+		}
+		fmt.Fprintf(c.stdout, "Skipped: %v\n", duplicate)
+		return nil
+	case err != nil:
+		return fmt.Errorf("could not add download: %w", err)
+	}
+
+	// A reused download may be in another queue
+	if queue, _, err = c.dm.FindDownload(added.ID); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(newDownloadView(queue, added))
+	}
+	if added != dc {
+		fmt.Fprintf(c.stdout, "%s (%s) in queue %s is now %s\n", added.ID, added.FileName, queue.QueueName, added.GetStatus())
+		return nil
+	}
+	fmt.Fprintf(c.stdout, "Added %s (%s) to queue %s\n", dc.ID, dc.FileName, queue.QueueName)
+	return nil
+}
+
+// targetQueue resolves the --queue flag, creating a default queue when none exist.
+func (c *command) targetQueue(idOrName string) (*controller.QueueController, error) {
+	if idOrName != "" {
+		return c.dm.FindQueue(idOrName)
+	}
+	if len(c.dm.QueueList) > 0 {
+		return c.dm.QueueList[0], nil
+	}
+
+	queue := controller.NewQueueController("Default Queue")
+	c.dm.AddQueue(queue)
+	if err := c.dm.SaveQueue(queue); err != nil {
+		return nil, fmt.Errorf("could not save queue: %w", err)
+	}
+	return queue, nil
+}
+
+func (c *command) list(args []string) error {
+	fs := c.newFlagSet("ls")
+	queueName := fs.String("queue", "", "only list downloads in this queue")
+	if _, err := parse(fs, args, 0, ""); err != nil {
+		return err
+	}
+
+	queues := c.dm.QueueList
+	if *queueName != "" {
+		queue, err := c.dm.FindQueue(*queueName)
+		if err != nil {
+			return err
+		}
+		queues = []*controller.QueueController{queue}
+	}
+
+	views := make([]downloadView, 0)
+	for _, queue := range queues {
+		for _, dc := range queue.Downloads() {
+			views = append(views, newDownloadView(queue, dc))
+		}
+	}
+	return c.printDownloads(views)
+}
+
+// changeStatus runs change on the download named in args and persists it.
+func (c *command) changeStatus(name string, args []string, change func(*controller.QueueController, *controller.DownloadController) error) error {
+	fs := c.newFlagSet(name)
+	positional, err := parse(fs, args, 1, "ID")
+	if err != nil {
+		return err
+	}
+
+	queue, dc, err := c.dm.FindDownload(positional[0])
+	if err != nil {
+		return err
+	}
+	if err := change(queue, dc); err != nil {
+		return err
+	}
+	if err := c.dm.SaveDownload(dc); err != nil {
+		return fmt.Errorf("could not save download: %w", err)
+	}
+
+	if c.json {
+		return c.printJSON(newDownloadView(queue, dc))
+	}
+	fmt.Fprintf(c.stdout, "%s is now %s\n", dc.ID, dc.GetStatus())
+	return nil
+}
+
+func (c *command) pause(args []string) error {
+	return c.changeStatus("pause", args, func(queue *controller.QueueController, dc *controller.DownloadController) error {
+		switch dc.GetStatus() {
+		case controller.ONGOING:
+			dc.Pause()
+		case controller.NOT_STARTED:
+			return dc.SetStatus(controller.PAUSED)
+		default:
+			return fmt.Errorf("cannot pause download %s: it is %s", dc.ID, dc.GetStatus())
+		}
+		return nil
+	})
+}
+
+func (c *command) resume(args []string) error {
+	return c.changeStatus("resume", args, func(queue *controller.QueueController, dc *controller.DownloadController) error {
+		if dc.GetStatus() != controller.PAUSED {
+			return fmt.Errorf("cannot resume download %s: it is %s", dc.ID, dc.GetStatus())
+		}
+		// The daemon starts it again from its partial files.
+		if c.live {
+			return queue.ResumeDownload(dc.ID)
+		}
+		// Otherwise nothing is running it, so the download goes back to
+		// pending and the next "run" continues it from its partial files.
+		return dc.SetStatus(controller.NOT_STARTED)
+	})
+}
+
+func (c *command) cancel(args []string) error {
+	return c.changeStatus("cancel", args, func(queue *controller.QueueController, dc *controller.DownloadController) error {
+		if err := queue.CancelDownload(dc.ID); err != nil {
+			return err
+		}
+		if dc.GetStatus() != controller.CANCELED {
+			return fmt.Errorf("cannot cancel download %s: it is %s", dc.ID, dc.GetStatus())
+		}
+		return nil
+	})
+}
+
+func (c *command) retry(args []string) error {
+	return c.changeStatus("retry", args, func(queue *controller.QueueController, dc *controller.DownloadController) error {
+		if status := dc.GetStatus(); status != controller.FAILED && status != controller.CANCELED {
+			return fmt.Errorf("cannot retry download %s: it is %s", dc.ID, status)
+		}
+		if err := dc.PrepareRetry(queue.TempPath); err != nil {
+			return err
+		}
+		// As with resume, the daemon runs it straight away and otherwise
+		// the next "run" does.
+		if c.live {
+			return queue.StartDownload(dc.ID)
+		}
+		return nil
+	})
+}
+
+func (c *command) restart(args []string) error {
+	return c.changeStatus("restart", args, func(queue *controller.QueueController, dc *controller.DownloadController) error {
+		return queue.RestartDownload(dc.ID)
+	})
+}
+
+// transitionView is the JSON shape of a status change.
+type transitionView struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+func newTransitionViews(dc *controller.DownloadController) []transitionView {
+	history := dc.StatusHistory()
+	views := make([]transitionView, len(history))
+	for i, t := range history {
+		views[i] = transitionView{From: t.From.String(), To: t.To.String(), At: t.At, Reason: t.Reason}
+	}
+	return views
+}
+
+// history prints the status changes of a download, oldest first.
+func (c *command) history(args []string) error {
+	fs := c.newFlagSet("history")
+	positional, err := parse(fs, args, 1, "ID")
+	if err != nil {
+		return err
+	}
+	_, dc, err := c.dm.FindDownload(positional[0])
+	if err != nil {
+		return err
+	}
+
+	views := newTransitionViews(dc)
+	if c.json {
+		return c.printJSON(views)
+	}
+	rows := make([][]string, len(views))
+	for i, v := range views {
+		rows[i] = []string{v.At.Local().Format(time.DateTime), v.From, v.To, v.Reason}
+	}
+	c.printTable([]string{"TIME", "FROM", "TO", "REASON"}, rows)
+	return nil
+}
+
+// move reorders a download within its queue, or moves it to another queue
+// with --queue, keeping its partial files.
+func (c *command) move(args []string) error {
+	fs := c.newFlagSet("move")
+	queueName := fs.String("queue", "", "move the download to this queue")
+	positional, err := parse(fs, args, -1, "")
+	if err != nil {
+		return err
+	}
+	want := 2
+	if *queueName != "" {
+		want = 1
+	}
+	if len(positional) != want {
+		return usagef("usage: tdm move ID top|up|down|bottom|N, or tdm move ID --queue Q")
+	}
+
+	queue, dc, err := c.dm.FindDownload(positional[0])
+	if err != nil {
+		return err
+	}
+	if *queueName != "" {
+		target, err := c.dm.FindQueue(*queueName)
+		if err != nil {
+			return err
+		}
+		if err := c.dm.MoveDownloadToQueue(dc.ID, target.QueueID); err != nil {
+			return err
+		}
+		if c.json {
+			return c.printJSON(newDownloadView(target, dc))
+		}
+		fmt.Fprintf(c.stdout, "%s moved to queue %s\n", dc.ID, target.QueueName)
+		return nil
+	}
+
+	index := slices.Index(queue.DownloadControllers, dc)
+	switch where := positional[1]; where {
+	case "top":
+		index = 0
+	case "up":
+		index--
+	case "down":
+		index++
+	case "bottom":
+		index = len(queue.DownloadControllers) - 1
+	default:
+		n, err := strconv.Atoi(where)
+		if err != nil || n < 1 {
+			return usagef("move: position must be top, up, down, bottom or a number from 1, got %q", where)
+		}
+		index = n - 1
+	}
+	if err := c.dm.MoveDownload(dc.ID, index); err != nil {
+		return err
+	}
+	index = slices.Index(queue.DownloadControllers, dc)
+	if c.json {
+		return c.printJSON(newDownloadView(queue, dc))
+	}
+	fmt.Fprintf(c.stdout, "%s is now number %d in queue %s\n", dc.ID, index+1, queue.QueueName)
+	return nil
+}
+
+// priority sets which of the waiting downloads start first.
+func (c *command) priority(args []string) error {
+	fs := c.newFlagSet("priority")
+	// A negative priority is not a flag
+	var negative []string
+	args = slices.DeleteFunc(slices.Clone(args), func(arg string) bool {
+		if n, err := strconv.Atoi(arg); err == nil && n < 0 {
+			negative = append(negative, arg)
+			return true
+		}
+		return false
+	})
+	positional, err := parse(fs, args, -1, "")
+	if err != nil {
+		return err
+	}
+	positional = append(positional, negative...)
+	if len(positional) != 2 {
+		return usagef("usage: tdm priority ID N")
+	}
+	priority, err := strconv.Atoi(positional[1])
+	if err != nil {
+		return usagef("priority: %q is not a number", positional[1])
+	}
+
+	queue, dc, err := c.dm.FindDownload(positional[0])
+	if err != nil {
+		return err
+	}
+	if err := c.dm.SetDownloadPriority(dc.ID, priority); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(newDownloadView(queue, dc))
+	}
+	fmt.Fprintf(c.stdout, "%s now has priority %d\n", dc.ID, priority)
+	return nil
+}
+
+func (c *command) run(args []string) error {
+	fs := c.newFlagSet("run")
+	queueName := fs.String("queue", "", "only run this queue")
+	if _, err := parse(fs, args, 0, ""); err != nil {
+		return err
+	}
+
+	queues := c.dm.QueueList
+	if *queueName != "" {
+		queue, err := c.dm.FindQueue(*queueName)
+		if err != nil {
+			return err
+		}
+		queues = []*controller.QueueController{queue}
+	}
+
+	type runItem struct {
+		queue *controller.QueueController
+		dc    *controller.DownloadController
+	}
+	var items []runItem
+	for _, queue := range queues {
+		for _, dc := range queue.Downloads() {
+			// Outside the daemon nothing runs before this command; continue
+			// what an earlier run left interrupted from its partial files,
+			// as well as what a closing window or a full disk paused.
+			if !c.live && (dc.PausedFor(controller.PAUSE_INTERRUPTED) || dc.PausedFor(controller.PAUSE_WINDOW_CLOSED) || dc.PausedFor(controller.PAUSE_DISK_FULL)) {
+				dc.SetStatus(controller.NOT_STARTED)
+			}
+			if dc.GetStatus() == controller.NOT_STARTED {
+				items = append(items, runItem{queue, dc})
+			}
+		}
+	}
+	if len(items) == 0 {
+		if c.json {
+			return c.printJSON([]downloadView{})
+		}
+		fmt.Fprintln(c.stdout, "Nothing to run")
+		return nil
+	}
+
+	for _, queue := range queues {
+		if err := queue.StartPending(); err != nil {
+			return fmt.Errorf("could not start queue %s: %w", queue.QueueName, err)
+		}
+	}
+
+	dcs := make([]*controller.DownloadController, len(items))
+	for i, item := range items {
+		dcs[i] = item.dc
+	}
+	if err := c.wait(queues, dcs); err != nil {
+		return err
+	}
+
+	views := make([]downloadView, len(items))
+	failed := false
+	for i, item := range items {
+		views[i] = newDownloadView(item.queue, item.dc)
+		if item.dc.GetStatus() != controller.COMPLETED {
+			failed = true
+		}
+	}
+	if err := c.printDownloads(views); err != nil {
+		return err
+	}
+	if failed {
+		return errFailed
+	}
+	return nil
+}
+
+// wait blocks until the queues finish, reporting the progress of dcs. It
+// releases the manager meanwhile so a daemon keeps serving other clients.
+func (c *command) wait(queues []*controller.QueueController, dcs []*controller.DownloadController) error {
+	c.lock.Unlock()
+	defer c.lock.Lock()
+
+	done := make(chan struct{})
+	go func() {
+		for _, queue := range queues {
+			queue.WaitForCompletion()
+		}
+		close(done)
+	}()
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-c.ctx.Done():
+			// Progress is flushed when the manager closes. The next start
+			// pauses the downloads as interrupted, and the next run (or a
+			// queue with AutoResume) continues them from their partial files.
+			// In the daemon they just keep running.
+			if !c.live {
+				fmt.Fprintln(c.stderr, "Interrupted, progress saved")
+			}
+			return errInterrupted
+		case <-ticker.C:
+			if !c.json {
+				completed, total := 0, 0
+				for _, dc := range dcs {
+					done, size := dc.Progress()
+					completed += done
+					total += size
+				}
+				fmt.Fprintf(c.stderr, "%s / %s\n", formatBytes(completed), formatBytes(total))
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mjghr/tech-download-manager/controller"
+	"github.com/mjghr/tech-download-manager/manager"
+)
+
+// importList adds every URL of a list file to a queue. See
+// manager.ParseURLList for the format, and manager.ParseSession for the
+// aria2 sessions and wget lists read with --session.
+func (c *command) importList(args []string) error {
+	fs := c.newFlagSet("import")
+	queueName := fs.String("queue", "", "queue ID or name (defaults to the first queue)")
+	dryRun := fs.Bool("dry-run", false, "only print the URLs the list expands to")
+	session := fs.Bool("session", false, "read an aria2 session or wget -i list and continue its partial files")
+	dir := fs.String("dir", ".", "where partial files of entries without a dir option are, with --session")
+	positional, err := parse(fs, args, 1, "FILE [--queue Q] [--dry-run] [--session [--dir DIR]]")
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(c.path(positional[0]))
+	if err != nil {
+		return err
+	}
+	parseList := manager.ParseURLList
+	if *session {
+		parseList = manager.ParseSession
+	}
+	entries, err := parseList(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("invalid URL list %s:\n%w", positional[0], err)
+	}
+	if *session {
+		for i := range entries {
+			if entries[i].Dir == "" {
+				entries[i].Dir = *dir
+			}
+			entries[i].Dir = c.path(entries[i].Dir)
+		}
+	}
+
+	if *dryRun {
+		if c.json {
+			return c.printJSON(entries)
+		}
+		rows := make([][]string, len(entries))
+		for i, entry := range entries {
+			rows[i] = []string{fmt.Sprint(entry.Line), entry.URL, entry.FileName, entry.Dir}
+		}
+		c.printTable([]string{"LINE", "URL", "FILE", "DIR"}, rows)
+		return nil
+	}
+
+	queue, err := c.targetQueue(*queueName)
+	if err != nil {
+		return err
+	}
+
+	// Probing and copying partial files can take a while; let a daemon
+	// serve other clients meanwhile
+	tmpPath := queue.TempPath
+	known := c.dm.KnownURLs()
+	adopted := 0
+	c.lock.Unlock()
+	var dcs []*controller.DownloadController
+	var probeErr error
+	if *session {
+		dcs, adopted, probeErr = c.dm.ProbeSession(entries, tmpPath, known)
+	} else {
+		dcs, probeErr = c.dm.ProbeBatch(entries)
+	}
+	c.lock.Lock()
+
+	// The queue may have been removed while unlocked
+	if queue, err = c.dm.FindQueue(queue.QueueID); err != nil {
+		return err
+	}
+	added, skipped, err := c.dm.AddDownloads(queue, dcs)
+	if err != nil {
+		return fmt.Errorf("could not save downloads: %w", err)
+	}
+
+	if c.json {
+		views := make([]downloadView, len(added))
+		for i, dc := range added {
+			// A reused download may be in another queue
+			owner, _, err := c.dm.FindDownload(dc.ID)
+			if err != nil {
+				owner = queue
+			}
+			views[i] = newDownloadView(owner, dc)
+		}
+		if err := c.printJSON(views); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(c.stdout, "Imported %d of %d downloads into queue %s\n", len(added), len(entries), queue.QueueName)
+		if adopted > 0 {
+			fmt.Fprintf(c.stdout, "Continuing from %s already on disk\n", formatBytes(adopted))
+		}
+	}
+	// Skipped duplicates are reported, but are not a failure
+	if skipped != nil {
+		fmt.Fprintln(c.stderr, skipped)
+	}
+	if probeErr != nil {
+		fmt.Fprintln(c.stderr, probeErr)
+		return errFailed
+	}
+	return nil
+}
@@ -0,0 +1,365 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mjghr/tech-download-manager/controller"
+)
+
+// queueView is the JSON shape of a queue.
+type queueView struct {
+	ID                      string    `json:"id"`
+	Name                    string    `json:"name"`
+	ConcurrentDownloadLimit int       `json:"concurrentDownloadLimit"`
+	SpeedLimitKB            int       `json:"speedLimitKb"`
+	SavePath                string    `json:"savePath"`
+	TempPath                string    `json:"tempPath"`
+	StartTime               time.Time `json:"startTime"`
+	EndTime                 time.Time `json:"endTime"`
+	Schedule                string    `json:"schedule,omitempty"`
+	NextWindow              []string  `json:"nextWindow,omitempty"` // start and end, RFC 3339
+	Order                   string    `json:"order"`
+	AutoResume              bool      `json:"autoResume"`
+	Downloads               int       `json:"downloads"`
+}
+
+func newQueueView(queue *controller.QueueController) queueView {
+	view := queueView{
+		ID:                      queue.QueueID,
+		Name:                    queue.QueueName,
+		ConcurrentDownloadLimit: queue.ConcurrentDownloadLimit,
+		SpeedLimitKB:            queue.SpeedLimit / 1024,
+		SavePath:                queue.SavePath,
+		TempPath:                queue.TempPath,
+		StartTime:               queue.StartTime,
+		EndTime:                 queue.EndTime,
+		Order:                   string(queue.GetOrder()),
+		AutoResume:              queue.AutoResume,
+		Downloads:               len(queue.DownloadControllers),
+	}
+	if queue.Schedule != nil {
+		view.Schedule = queue.Schedule.String()
+	}
+	if start, end, ok := queue.NextWindow(time.Now()); ok {
+		view.NextWindow = []string{start.Format(time.RFC3339), ""}
+		if !end.IsZero() {
+			view.NextWindow[1] = end.Format(time.RFC3339)
+		}
+	}
+	return view
+}
+
+func (c *command) printQueue(queue *controller.QueueController, verb string) error {
+	if c.json {
+		return c.printJSON(newQueueView(queue))
+	}
+	fmt.Fprintf(c.stdout, "%s queue %s (%s)\n", verb, queue.QueueName, queue.QueueID)
+	return nil
+}
+
+func (c *command) queueList(args []string) error {
+	fs := c.newFlagSet("queue ls")
+	if _, err := parse(fs, args, 0, ""); err != nil {
+		return err
+	}
+
+	views := make([]queueView, len(c.dm.QueueList))
+	for i, queue := range c.dm.QueueList {
+		views[i] = newQueueView(queue)
+	}
+	if c.json {
+		return c.printJSON(views)
+	}
+
+	rows := make([][]string, len(views))
+	for i, v := range views {
+		// START and END show the window open now or the next one
+		start, end := "-", "-"
+		if v.NextWindow != nil {
+			start, end = v.NextWindow[0], v.NextWindow[1]
+		}
+		schedule := v.Schedule
+		if schedule == "" {
+			schedule = "-"
+		}
+		rows[i] = []string{
+			v.ID, v.Name,
+			fmt.Sprint(v.ConcurrentDownloadLimit),
+			fmt.Sprintf("%d KB/s", v.SpeedLimitKB),
+			start, end, schedule, v.Order,
+			fmt.Sprint(v.Downloads), v.SavePath,
+		}
+	}
+	c.printTable([]string{"ID", "NAME", "CONCURRENT", "SPEED", "START", "END", "SCHEDULE", "ORDER", "DOWNLOADS", "SAVE PATH"}, rows)
+	return nil
+}
+
+// queueOptions are the settings shared by "queue create" and "queue edit".
+type queueOptions struct {
+	concurrency  int
+	speedLimitKB int
+	saveDir      string
+	tempDir      string
+	start        time.Time
+	end          time.Time
+	schedule     *controller.Schedule
+	order        controller.OrderPolicy
+	autoResume   bool
+}
+
+func (o *queueOptions) register(fs *flag.FlagSet) {
+	fs.IntVar(&o.concurrency, "concurrency", 0, "concurrent download limit")
+	fs.IntVar(&o.speedLimitKB, "speed-limit-kb", 0, "speed limit per download in KB/s")
+	fs.StringVar(&o.saveDir, "save-dir", "", "directory finished files are saved to")
+	fs.StringVar(&o.tempDir, "temp-dir", "", "directory for partial chunk files")
+	fs.Func("start", "start of the time window (RFC 3339)", timeFlag(&o.start))
+	fs.Func("end", "end of the time window (RFC 3339)", timeFlag(&o.end))
+	fs.Func("schedule", "recurring schedule such as \"01:00-07:00; mon-fri; tz=Europe/Berlin\", or none", func(value string) error {
+		if value == "none" {
+			o.schedule = nil
+			return nil
+		}
+		schedule, err := controller.ParseSchedule(value)
+		o.schedule = schedule
+		return err
+	})
+	fs.Func("order", "order downloads start in: priority, fifo, smallest-first, largest-first or round-robin", func(value string) error {
+		order, err := controller.ParseOrderPolicy(value)
+		o.order = order
+		return err
+	})
+	fs.BoolVar(&o.autoResume, "auto-resume", false, "continue downloads interrupted by an exit when the manager starts")
+}
+
+func timeFlag(t *time.Time) func(string) error {
+	return func(value string) error {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("expected an RFC 3339 time such as 2025-01-02T03:04:05Z")
+		}
+		*t = parsed
+		return nil
+	}
+}
+
+// apply changes the settings whose flags were given on the command line.
+func (o *queueOptions) apply(fs *flag.FlagSet, queue *controller.QueueController) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	if set["concurrency"] {
+		if o.concurrency < 1 {
+			return usagef("--concurrency must be at least 1")
+		}
+		queue.SetConcurrentLimit(o.concurrency)
+	}
+	if set["speed-limit-kb"] {
+		if o.speedLimitKB < 0 {
+			return usagef("--speed-limit-kb must not be negative")
+		}
+		queue.SpeedLimit = o.speedLimitKB * 1024
+	}
+	if set["save-dir"] || set["temp-dir"] {
+		tempDir, saveDir := queue.TempPath, queue.SavePath
+		if set["temp-dir"] {
+			tempDir = o.tempDir
+		}
+		if set["save-dir"] {
+			saveDir = o.saveDir
+		}
+		if err := queue.SetPaths(tempDir, saveDir); err != nil {
+			return err
+		}
+	}
+	if set["start"] || set["end"] {
+		start, end := queue.StartTime, queue.EndTime
+		if set["start"] {
+			start = o.start
+		}
+		if set["end"] {
+			end = o.end
+		}
+		if !end.After(start) {
+			return usagef("the end of the time window must be after its start")
+		}
+		queue.SetTimeWindow(start, end)
+	}
+	if set["schedule"] {
+		queue.SetSchedule(o.schedule)
+	}
+	if set["order"] {
+		queue.SetOrder(o.order)
+	}
+	if set["auto-resume"] {
+		queue.SetAutoResume(o.autoResume)
+	}
+	return nil
+}
+
+func (c *command) queueCreate(args []string) error {
+	fs := c.newFlagSet("queue create")
+	var options queueOptions
+	options.register(fs)
+	positional, err := parse(fs, args, 1, "NAME [options]")
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.dm.FindQueue(positional[0]); err == nil {
+		return fmt.Errorf("a queue named %s already exists", positional[0])
+	}
+
+	queue := controller.NewQueueController(positional[0])
+	if err := options.apply(fs, queue); err != nil {
+		return err
+	}
+	c.dm.AddQueue(queue)
+	if err := c.dm.SaveQueue(queue); err != nil {
+		return fmt.Errorf("could not save queue: %w", err)
+	}
+	return c.printQueue(queue, "Created")
+}
+
+func (c *command) queueEdit(args []string) error {
+	fs := c.newFlagSet("queue edit")
+	var options queueOptions
+	options.register(fs)
+	name := fs.String("name", "", "new queue name")
+	positional, err := parse(fs, args, 1, "Q [options]")
+	if err != nil {
+		return err
+	}
+
+	queue, err := c.dm.FindQueue(positional[0])
+	if err != nil {
+		return err
+	}
+	if err := options.apply(fs, queue); err != nil {
+		return err
+	}
+	if *name != "" {
+		queue.QueueName = *name
+	}
+	if err := c.dm.SaveQueue(queue); err != nil {
+		return fmt.Errorf("could not save queue: %w", err)
+	}
+	return c.printQueue(queue, "Updated")
+}
+
+func (c *command) queueRemove(args []string) error {
+	fs := c.newFlagSet("queue rm")
+	force := fs.Bool("force", false, "remove even if downloads are unfinished")
+	positional, err := parse(fs, args, 1, "Q [--force]")
+	if err != nil {
+		return err
+	}
+
+	queue, err := c.dm.FindQueue(positional[0])
+	if err != nil {
+		return err
+	}
+	for _, dc := range queue.Downloads() {
+		status := dc.GetStatus()
+		if status == controller.COMPLETED || status == controller.CANCELED || status == controller.FAILED {
+			continue
+		}
+		if !*force {
+			return fmt.Errorf("queue %s has unfinished downloads, use --force to remove it anyway", queue.QueueName)
+		}
+	}
+
+	// Cancel unfinished downloads so their temp files are removed too
+	queue.CancelAll()
+	if err := c.dm.RemoveQueue(queue.QueueID); err != nil {
+		return err
+	}
+	return c.printQueue(queue, "Removed")
+}
+
+// queueExport writes a queue to a bundle file that can be shared.
+func (c *command) queueExport(args []string) error {
+	fs := c.newFlagSet("queue export")
+	positional, err := parse(fs, args, 2, "Q FILE")
+	if err != nil {
+		return err
+	}
+
+	queue, err := c.dm.FindQueue(positional[0])
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(c.path(positional[1]))
+	if err != nil {
+		return err
+	}
+	if err := controller.NewQueueBundle(queue).Write(file); err != nil {
+		file.Close()
+		return fmt.Errorf("could not write bundle: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("could not write bundle: %w", err)
+	}
+
+	if c.json {
+		return c.printJSON(map[string]string{"queue": queue.QueueID, "file": positional[1]})
+	}
+	fmt.Fprintf(c.stdout, "Exported queue %s with %d downloads to %s\n", queue.QueueName, len(queue.DownloadControllers), positional[1])
+	return nil
+}
+
+// queueImport creates a queue from a bundle written by queueExport.
+func (c *command) queueImport(args []string) error {
+	fs := c.newFlagSet("queue import")
+	saveDir := fs.String("save-dir", "", "directory finished files are saved to (defaults to the bundle's)")
+	name := fs.String("name", "", "name of the new queue (defaults to the bundle's)")
+	positional, err := parse(fs, args, 1, "FILE [--save-dir D] [--name N]")
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(c.path(positional[0]))
+	if err != nil {
+		return err
+	}
+	bundle, err := controller.ReadQueueBundle(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("invalid bundle %s:\n%w", positional[0], err)
+	}
+	if *name != "" {
+		bundle.Name = *name
+	}
+	if *saveDir != "" {
+		*saveDir = c.path(*saveDir)
+	}
+
+	// Probing can take a while; let a daemon serve other clients meanwhile
+	c.lock.Unlock()
+	queue, dcs, probeErr := c.dm.ProbeBundle(bundle, *saveDir)
+	c.lock.Lock()
+	if queue == nil {
+		return probeErr
+	}
+	added, skipped, err := c.dm.AddImportedQueue(queue, dcs)
+	if err != nil {
+		return fmt.Errorf("could not save queue: %w", err)
+	}
+
+	if err := c.printQueue(queue, "Imported"); err != nil {
+		return err
+	}
+	if !c.json {
+		fmt.Fprintf(c.stdout, "Added %d of %d downloads, saving to %s\n", len(added), len(bundle.Downloads), queue.SavePath)
+	}
+	if skipped != nil {
+		fmt.Fprintln(c.stderr, skipped)
+	}
+	if probeErr != nil {
+		fmt.Fprintln(c.stderr, probeErr)
+		return errFailed
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/mjghr/tech-download-manager/controller"
+)
+
+// state copies the whole state between the configured store and a portable
+// JSON file, e.g. to move from the json to the bolt backend.
+func (c *command) state(args []string) error {
+	fs := c.newFlagSet("state")
+	positional, err := parse(fs, args, 2, "export|import FILE")
+	if err != nil {
+		return err
+	}
+
+	file := controller.NewJSONStore(c.path(positional[1]))
+	switch positional[0] {
+	case "export":
+		err = controller.CopyState(file, c.dm.Store)
+	case "import":
+		if c.live {
+			return fmt.Errorf("cannot import state into a running daemon, stop it with \"tdm daemon stop\" first")
+		}
+		err = controller.CopyState(c.dm.Store, file)
+	default:
+		return usagef("unknown state command %q, expected export or import", positional[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(map[string]string{"action": positional[0], "file": positional[1]})
+	}
+	fmt.Fprintf(c.stdout, "State %sed: %s\n", positional[0], positional[1])
+	return nil
+}
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"net"
 	"net/http"
+
+	"github.com/mjghr/tech-download-manager/config"
 )
 
 type HTTPClient struct {
@@ -12,8 +15,15 @@ type HTTPClient struct {
 }
 
 func NewHTTPClient() *HTTPClient {
+	cfg := config.Get().Download
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: cfg.ConnectTimeout}).DialContext
+	transport.ResponseHeaderTimeout = cfg.ResponseTimeout
+
+	// No overall client timeout: it would also cap the time spent reading
+	// large response bodies.
 	return &HTTPClient{
-		client: &http.Client{},
+		client: &http.Client{Transport: transport},
 	}
 }
 
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mjghr/tech-download-manager/api"
+	"github.com/mjghr/tech-download-manager/cli"
+	"github.com/mjghr/tech-download-manager/config"
+	"github.com/mjghr/tech-download-manager/controller"
+	"github.com/mjghr/tech-download-manager/daemon"
+	"github.com/mjghr/tech-download-manager/manager"
+)
+
+// runDaemon handles "daemon", which serves the download manager on the
+// control socket until stopped, and "daemon stop".
+func runDaemon(args []string) int {
+	socket := config.Get().Paths.Socket
+
+	switch {
+	case len(args) == 1 && args[0] == "stop":
+		client, err := daemon.Dial(socket)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: no daemon is running on %s\n", socket)
+			return cli.EXIT_FAILURE
+		}
+		defer client.Close()
+		if err := client.Shutdown(); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return cli.EXIT_FAILURE
+		}
+		fmt.Println("Daemon stopped")
+		return cli.EXIT_OK
+	case len(args) > 0:
+		fmt.Fprintln(os.Stderr, "usage: tdm daemon [stop]")
+		return cli.EXIT_USAGE
+	}
+
+	store, err := controller.OpenStateStore(config.Get().Paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return cli.EXIT_FAILURE
+	}
+	defer store.Close()
+
+	dm := manager.NewDownloadManager(store)
+	defer dm.Close()
+
+	server := daemon.NewServer(dm, socket)
+	if err := server.Listen(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return cli.EXIT_FAILURE
+	}
+
+	err = dm.LoadQueues()
+	var restored *controller.BackupRestoredError
+	if errors.As(err, &restored) {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", restored)
+		err = nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		server.Close()
+		return cli.EXIT_FAILURE
+	}
+
+	// Loading paused the downloads the previous process was running
+	if err := dm.ResumeInterrupted(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not resume interrupted downloads: %v\n", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg := config.Get().API; cfg.Enabled {
+		listener, err := net.Listen("tcp", cfg.Listen)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: could not start the API: %v\n", err)
+			server.Close()
+			return cli.EXIT_FAILURE
+		}
+		apiDone := make(chan error, 1)
+		go func() {
+			apiDone <- api.NewServer(dm, server.Locker(), cfg.Token).Serve(ctx, listener)
+		}()
+		// Stop the API with the daemon
+		defer func() {
+			stop()
+			if err := <-apiDone; err != nil {
+				fmt.Fprintf(os.Stderr, "error: API: %v\n", err)
+			}
+		}()
+		fmt.Fprintf(os.Stderr, "API listening on http://%s%s\n", listener.Addr(), api.API_PREFIX)
+	}
+
+	fmt.Fprintf(os.Stderr, "Daemon listening on %s\n", socket)
+	if err := server.Serve(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return cli.EXIT_FAILURE
+	}
+	return cli.EXIT_OK
+}
@@ -1,32 +1,86 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	_ "time/tzdata" // schedules name time zones that may not be installed
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mjghr/tech-download-manager/cli"
 	"github.com/mjghr/tech-download-manager/config"
 	"github.com/mjghr/tech-download-manager/controller"
+	"github.com/mjghr/tech-download-manager/daemon"
+	"github.com/mjghr/tech-download-manager/manager"
 	"github.com/mjghr/tech-download-manager/ui"
 	"github.com/mjghr/tech-download-manager/ui/logs"
 )
 
-
 func main() {
-	// Load environment variables
-	config.LoadEnv()
+	// Load configuration from file, environment and flags
+	args, err := config.Load(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if spec := config.Get().Queue.Schedule; spec != "" {
+		if _, err := controller.ParseSchedule(spec); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid configuration: queue.schedule: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if _, err := controller.ParseOrderPolicy(config.Get().Queue.Order); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: queue.order: %v\n", err)
+		os.Exit(2)
+	}
+
+	if len(args) > 0 && args[0] == "daemon" {
+		os.Exit(runDaemon(args[1:]))
+	}
 
-	// Log loaded queues for debugging
-	filename := "queues.json"
-	loadedQueues, err := controller.LoadQueueControllers(filename)
+	// Attach to a running daemon, if there is one
+	if client, err := daemon.Dial(config.Get().Paths.Socket); err == nil {
+		defer client.Close()
+		if len(args) > 0 {
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			code := client.Exec(ctx, args, os.Stdout, os.Stderr)
+			stop()
+			client.Close()
+			os.Exit(code)
+		}
+		runUI(client)
+		return
+	}
+
+	// Open the configured state store
+	store, err := controller.OpenStateStore(config.Get().Paths)
 	if err != nil {
-		logs.Log(fmt.Sprintf("Error loading queues: %v", err))
-	} else {
-		logs.Log(fmt.Sprintf("Loaded %d queues from %s", len(loadedQueues), filename))
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
+	defer store.Close()
+
+	dm := manager.NewDownloadManager(store)
+
+	// Run a headless command if one was given
+	if len(args) > 0 {
+		code := cli.Run(dm, args, os.Stdout, os.Stderr)
+		dm.Close()
+		store.Close()
+		os.Exit(code)
+	}
+
+	runUI(dm)
+	dm.Close()
+}
 
-	// Create and run the app
-	p := tea.NewProgram(ui.NewAppModel(), tea.WithAltScreen())
+// runUI runs the terminal UI until the user quits. Downloads run by a local
+// manager stop with it; those run by a daemon keep going.
+func runUI(service manager.Service) {
+	p := tea.NewProgram(ui.NewAppModel(service), tea.WithAltScreen())
 	logs.Log("Starting download manager...")
 
 	if _, err := p.Run(); err != nil {
@@ -0,0 +1,486 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/mjghr/tech-download-manager/util"
+)
+
+// Config is the typed application configuration. Values are resolved in the
+// order defaults < config file < environment variables < command-line flags.
+type Config struct {
+	General  GeneralConfig  `toml:"general"`
+	Paths    PathsConfig    `toml:"paths"`
+	Download DownloadConfig `toml:"download"`
+	Queue    QueueConfig    `toml:"queue"`
+	Hosts    HostsConfig    `toml:"hosts"`
+	API      APIConfig      `toml:"api"`
+
+	// Path is the config file the values were read from, if any.
+	Path string `toml:"-"`
+}
+
+type GeneralConfig struct {
+	WelcomeMessage string `toml:"welcome_message"`
+}
+
+type PathsConfig struct {
+	StateBackend string `toml:"state_backend"`
+	StateFile    string `toml:"state_file"`
+	StateDB      string `toml:"state_db"`
+	StateBackups int    `toml:"state_backups"`
+	TempDir      string `toml:"temp_dir"`
+	SaveDir      string `toml:"save_dir"`
+	Socket       string `toml:"socket"`
+}
+
+type DownloadConfig struct {
+	MaxWorkers      int           `toml:"max_workers"`
+	SpeedLimitKB    int           `toml:"speed_limit_kb"`
+	UserAgent       string        `toml:"user_agent"`
+	TmpFilePrefix   string        `toml:"tmp_file_prefix"`
+	ConnectTimeout  time.Duration `toml:"connect_timeout"`
+	ResponseTimeout time.Duration `toml:"response_timeout"`
+	MaxRetries      int           `toml:"max_retries"`
+	RetryDelay      time.Duration `toml:"retry_delay"`
+
+	CheckpointInterval time.Duration `toml:"checkpoint_interval"`
+
+	// MaxActiveDownloads and MaxConnections cap the downloads running at
+	// once and the connections they open, across all queues; 0 is no limit.
+	MaxActiveDownloads int `toml:"max_active_downloads"`
+	MaxConnections     int `toml:"max_connections"`
+
+	// OnDuplicate is what happens by default when a download being added
+	// duplicates another or a file at its destination, as
+	// controller.ParseDuplicateAction reads it. DuplicateCheck says when an
+	// existing file counts as a duplicate: "name", "size" or "hash".
+	OnDuplicate    string `toml:"on_duplicate"`
+	DuplicateCheck string `toml:"duplicate_check"`
+
+	// Preallocate reserves the disk space of each chunk file before it is
+	// written, where the file system supports it. A download without room
+	// is paused and DiskRecheckInterval is how often it looks again.
+	Preallocate         bool          `toml:"preallocate"`
+	DiskRecheckInterval time.Duration `toml:"disk_recheck_interval"`
+}
+
+type QueueConfig struct {
+	ConcurrentDownloadLimit int           `toml:"concurrent_download_limit"`
+	SpeedLimitKB            int           `toml:"speed_limit_kb"`
+	Window                  time.Duration `toml:"window"`
+	// Schedule, when set, is the recurring schedule of new queues in the
+	// form controller.ParseSchedule reads, instead of a one-off Window.
+	Schedule string `toml:"schedule"`
+	// Order is the order policy of new queues, as controller.ParseOrderPolicy
+	// reads it.
+	Order string `toml:"order"`
+	// AutoResume makes new queues continue the downloads the previous
+	// process left interrupted when the manager starts.
+	AutoResume bool `toml:"auto_resume"`
+}
+
+// HostsConfig limits the requests sent to each host, across all downloads
+// and queues, so servers that ban clients opening too many connections can
+// be used safely. Hosts are told apart by name without the port.
+type HostsConfig struct {
+	// MaxConnections caps the connections open to one host; 0 is no limit.
+	MaxConnections int `toml:"max_connections"`
+	// RequestDelay is the least time between two new requests to one host.
+	RequestDelay time.Duration `toml:"request_delay"`
+	// Rules override the limits for the hosts they match; the first match
+	// wins.
+	Rules []HostRule `toml:"rule"`
+}
+
+// HostRule overrides the limits of the hosts matching Pattern, a shell
+// pattern such as "*.example.com". Unset limits keep their default.
+type HostRule struct {
+	Pattern        string         `toml:"pattern"`
+	MaxConnections *int           `toml:"max_connections"`
+	RequestDelay   *time.Duration `toml:"request_delay"`
+}
+
+// Limits returns the connection limit and request delay for host.
+func (h HostsConfig) Limits(host string) (maxConnections int, requestDelay time.Duration) {
+	maxConnections, requestDelay = h.MaxConnections, h.RequestDelay
+	host = strings.ToLower(host)
+	for _, rule := range h.Rules {
+		if ok, _ := path.Match(strings.ToLower(rule.Pattern), host); !ok {
+			continue
+		}
+		if rule.MaxConnections != nil {
+			maxConnections = *rule.MaxConnections
+		}
+		if rule.RequestDelay != nil {
+			requestDelay = *rule.RequestDelay
+		}
+		break
+	}
+	return maxConnections, requestDelay
+}
+
+// APIConfig controls the HTTP API served by the daemon.
+type APIConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Listen  string `toml:"listen"`
+	// Token, when set, must be sent as a bearer token with every request.
+	Token string `toml:"token"`
+}
+
+var current = Default()
+
+// Get returns the active configuration. Before Load is called it holds the defaults.
+func Get() *Config {
+	return current
+}
+
+// Default returns the built-in configuration.
+func Default() *Config {
+	return &Config{
+		General: GeneralConfig{
+			WelcomeMessage: "Welcome to Download Manager",
+		},
+		Paths: PathsConfig{
+			StateBackend: STATE_BACKEND_JSON,
+			StateFile:    defaultDataPath(STATE_FILE_NAME),
+			StateDB:      defaultDataPath(STATE_DB_NAME),
+			StateBackups: 3,
+			TempDir:      util.GiveDefaultTempPath(),
+			SaveDir:      util.GiveDefaultSavePath(),
+			Socket:       defaultSocketPath(),
+		},
+		Download: DownloadConfig{
+			MaxWorkers:      8,
+			SpeedLimitKB:    0,
+			UserAgent:       "tech-idm",
+			TmpFilePrefix:   "tmpfile",
+			ConnectTimeout:  30 * time.Second,
+			ResponseTimeout: 60 * time.Second,
+			MaxRetries:      3,
+			RetryDelay:      2 * time.Second,
+
+			CheckpointInterval: 2 * time.Second,
+
+			OnDuplicate:    "ask",
+			DuplicateCheck: "name",
+
+			DiskRecheckInterval: 30 * time.Second,
+		},
+		Queue: QueueConfig{
+			ConcurrentDownloadLimit: 1,
+			SpeedLimitKB:            100,
+			Window:                  24 * time.Hour,
+			Order:                   "priority",
+		},
+		API: APIConfig{
+			Listen: "127.0.0.1:8089",
+		},
+	}
+}
+
+// DefaultConfigPath returns $XDG_CONFIG_HOME/tech-download-manager/config.toml
+// (or the platform equivalent).
+func DefaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return CONFIG_FILE_NAME
+	}
+	return filepath.Join(dir, APP_NAME, CONFIG_FILE_NAME)
+}
+
+// defaultDataPath returns name inside $XDG_DATA_HOME/tech-download-manager.
+func defaultDataPath(name string) string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return name
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, APP_NAME, name)
+}
+
+// defaultSocketPath returns the daemon's control socket inside
+// $XDG_RUNTIME_DIR, falling back to the data directory.
+func defaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, APP_NAME, SOCKET_NAME)
+	}
+	return defaultDataPath(SOCKET_NAME)
+}
+
+// Load resolves the configuration from the config file, the environment and
+// the given command-line arguments, validates it and makes it the active one.
+// The arguments left over after flag parsing are returned.
+func Load(args []string) ([]string, error) {
+	// Parse flags once on a scratch config to learn which ones were set and
+	// where the config file lives; they are re-applied last so they win.
+	scratch := Default()
+	fs := newFlagSet(scratch)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	setFlags := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = f.Value.String()
+	})
+
+	cfg := Default()
+	path, explicit := DefaultConfigPath(), false
+	if v, ok := os.LookupEnv(envName("config")); ok {
+		path, explicit = v, true
+	}
+	if v, ok := setFlags["config"]; ok {
+		path, explicit = v, true
+	}
+
+	if err := cfg.loadFile(path, explicit); err != nil {
+		return nil, err
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	final := newFlagSet(cfg)
+	for name, value := range setFlags {
+		if err := final.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid value %q for flag -%s: %w", value, name, err)
+		}
+	}
+
+	cfg.Paths.StateFile = expandHome(cfg.Paths.StateFile)
+	cfg.Paths.StateDB = expandHome(cfg.Paths.StateDB)
+	cfg.Paths.TempDir = expandHome(cfg.Paths.TempDir)
+	cfg.Paths.SaveDir = expandHome(cfg.Paths.SaveDir)
+	cfg.Paths.Socket = expandHome(cfg.Paths.Socket)
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	current = cfg
+	return fs.Args(), nil
+}
+
+// expandHome replaces a leading "~" with the user's home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
+
+func (c *Config) loadFile(path string, explicit bool) error {
+	if _, err := os.Stat(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) && !explicit {
+			return nil
+		}
+		return fmt.Errorf("could not read config file %s: %w", path, err)
+	}
+
+	meta, err := toml.DecodeFile(path, c)
+	if err != nil {
+		return fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return fmt.Errorf("unknown keys in config file %s: %s", path, strings.Join(keys, ", "))
+	}
+
+	c.Path = path
+	return nil
+}
+
+// applyEnv overrides values from TDM_* environment variables. Every flag has a
+// matching variable, e.g. -state-file is TDM_STATE_FILE.
+func (c *Config) applyEnv() error {
+	// SPEED_LIMIT_KB predates the config system and is still honoured.
+	if v, ok := os.LookupEnv("SPEED_LIMIT_KB"); ok {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid SPEED_LIMIT_KB value %q: %w", v, err)
+		}
+		c.Download.SpeedLimitKB = limit
+	}
+
+	fs := newFlagSet(c)
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		name := envName(f.Name)
+		if v, ok := os.LookupEnv(name); ok {
+			if err := fs.Set(f.Name, v); err != nil {
+				errs = append(errs, fmt.Errorf("invalid value %q for %s: %w", v, name, err))
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+func envName(flagName string) string {
+	return ENV_PREFIX + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+func newFlagSet(c *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet(APP_NAME, flag.ContinueOnError)
+	fs.StringVar(&c.Path, "config", c.Path, "path to the config file")
+	fs.StringVar(&c.Paths.StateBackend, "state-backend", c.Paths.StateBackend, "state store backend: json or bolt")
+	fs.StringVar(&c.Paths.StateFile, "state-file", c.Paths.StateFile, "file the queues are persisted to by the json backend")
+	fs.StringVar(&c.Paths.StateDB, "state-db", c.Paths.StateDB, "database the queues are persisted to by the bolt backend")
+	fs.IntVar(&c.Paths.StateBackups, "state-backups", c.Paths.StateBackups, "number of rotated state file backups to keep")
+	fs.StringVar(&c.Paths.TempDir, "temp-dir", c.Paths.TempDir, "directory for partial chunk files")
+	fs.StringVar(&c.Paths.SaveDir, "save-dir", c.Paths.SaveDir, "directory finished files are saved to")
+	fs.StringVar(&c.Paths.Socket, "socket", c.Paths.Socket, "control socket of the background daemon")
+	fs.IntVar(&c.Download.MaxWorkers, "max-workers", c.Download.MaxWorkers, "maximum connections per download")
+	fs.IntVar(&c.Download.SpeedLimitKB, "speed-limit-kb", c.Download.SpeedLimitKB, "per-download speed limit in KB/s (0 = unlimited)")
+	fs.StringVar(&c.Download.UserAgent, "user-agent", c.Download.UserAgent, "User-Agent header sent with every request")
+	fs.StringVar(&c.Download.TmpFilePrefix, "tmp-file-prefix", c.Download.TmpFilePrefix, "prefix of temporary chunk files")
+	fs.DurationVar(&c.Download.ConnectTimeout, "connect-timeout", c.Download.ConnectTimeout, "timeout for establishing a connection")
+	fs.DurationVar(&c.Download.ResponseTimeout, "response-timeout", c.Download.ResponseTimeout, "timeout for receiving response headers")
+	fs.IntVar(&c.Download.MaxRetries, "max-retries", c.Download.MaxRetries, "retries per failed chunk")
+	fs.DurationVar(&c.Download.RetryDelay, "retry-delay", c.Download.RetryDelay, "delay between chunk retries")
+	fs.DurationVar(&c.Download.CheckpointInterval, "checkpoint-interval", c.Download.CheckpointInterval, "how often download progress is persisted")
+	fs.IntVar(&c.Download.MaxActiveDownloads, "max-active-downloads", c.Download.MaxActiveDownloads, "maximum downloads running at once across all queues (0 = unlimited)")
+	fs.IntVar(&c.Download.MaxConnections, "max-connections", c.Download.MaxConnections, "maximum connections open at once across all queues (0 = unlimited)")
+	fs.StringVar(&c.Download.OnDuplicate, "on-duplicate", c.Download.OnDuplicate, "what to do with a duplicate download: ask, skip, redownload or resume")
+	fs.StringVar(&c.Download.DuplicateCheck, "duplicate-check", c.Download.DuplicateCheck, "when a file at a download's destination is a duplicate: name, size or hash")
+	fs.BoolVar(&c.Download.Preallocate, "preallocate", c.Download.Preallocate, "reserve the disk space of chunk files before writing them")
+	fs.DurationVar(&c.Download.DiskRecheckInterval, "disk-recheck-interval", c.Download.DiskRecheckInterval, "how often a download paused for disk space checks for room")
+	fs.IntVar(&c.Hosts.MaxConnections, "host-max-connections", c.Hosts.MaxConnections, "maximum connections open to one host across all downloads (0 = unlimited)")
+	fs.DurationVar(&c.Hosts.RequestDelay, "host-request-delay", c.Hosts.RequestDelay, "minimum delay between new requests to one host")
+	fs.IntVar(&c.Queue.ConcurrentDownloadLimit, "queue-concurrency", c.Queue.ConcurrentDownloadLimit, "default concurrent download limit for new queues")
+	fs.IntVar(&c.Queue.SpeedLimitKB, "queue-speed-limit-kb", c.Queue.SpeedLimitKB, "default speed limit in KB/s for new queues")
+	fs.DurationVar(&c.Queue.Window, "queue-window", c.Queue.Window, "default length of a new queue's time window")
+	fs.StringVar(&c.Queue.Schedule, "queue-schedule", c.Queue.Schedule, "default recurring schedule for new queues, e.g. \"01:00-07:00; mon-fri\"")
+	fs.StringVar(&c.Queue.Order, "queue-order", c.Queue.Order, "default order new queues start downloads in: priority, fifo, smallest-first, largest-first or round-robin")
+	fs.BoolVar(&c.Queue.AutoResume, "queue-auto-resume", c.Queue.AutoResume, "make new queues continue interrupted downloads on startup")
+	fs.BoolVar(&c.API.Enabled, "api-enabled", c.API.Enabled, "serve the HTTP API from the daemon")
+	fs.StringVar(&c.API.Listen, "api-listen", c.API.Listen, "address the HTTP API listens on")
+	fs.StringVar(&c.API.Token, "api-token", c.API.Token, "bearer token required by the HTTP API")
+	return fs
+}
+
+// Validate reports every invalid value in the configuration.
+func (c *Config) Validate() error {
+	var errs []error
+	switch c.Paths.StateBackend {
+	case STATE_BACKEND_JSON:
+		if c.Paths.StateFile == "" {
+			errs = append(errs, errors.New("paths.state_file must not be empty"))
+		}
+	case STATE_BACKEND_BOLT:
+		if c.Paths.StateDB == "" {
+			errs = append(errs, errors.New("paths.state_db must not be empty"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("paths.state_backend must be %q or %q, got %q", STATE_BACKEND_JSON, STATE_BACKEND_BOLT, c.Paths.StateBackend))
+	}
+	if c.Paths.StateBackups < 0 {
+		errs = append(errs, fmt.Errorf("paths.state_backups must not be negative, got %d", c.Paths.StateBackups))
+	}
+	if c.Paths.TempDir == "" {
+		errs = append(errs, errors.New("paths.temp_dir must not be empty"))
+	}
+	if c.Paths.SaveDir == "" {
+		errs = append(errs, errors.New("paths.save_dir must not be empty"))
+	}
+	if c.Paths.Socket == "" {
+		errs = append(errs, errors.New("paths.socket must not be empty"))
+	}
+	if c.Download.MaxWorkers < 1 {
+		errs = append(errs, fmt.Errorf("download.max_workers must be at least 1, got %d", c.Download.MaxWorkers))
+	}
+	if c.Download.SpeedLimitKB < 0 {
+		errs = append(errs, fmt.Errorf("download.speed_limit_kb must not be negative, got %d", c.Download.SpeedLimitKB))
+	}
+	if c.Download.UserAgent == "" {
+		errs = append(errs, errors.New("download.user_agent must not be empty"))
+	}
+	if c.Download.TmpFilePrefix == "" {
+		errs = append(errs, errors.New("download.tmp_file_prefix must not be empty"))
+	}
+	if c.Download.ConnectTimeout < 0 {
+		errs = append(errs, fmt.Errorf("download.connect_timeout must not be negative, got %v", c.Download.ConnectTimeout))
+	}
+	if c.Download.ResponseTimeout < 0 {
+		errs = append(errs, fmt.Errorf("download.response_timeout must not be negative, got %v", c.Download.ResponseTimeout))
+	}
+	if c.Download.MaxRetries < 0 {
+		errs = append(errs, fmt.Errorf("download.max_retries must not be negative, got %d", c.Download.MaxRetries))
+	}
+	if c.Download.RetryDelay < 0 {
+		errs = append(errs, fmt.Errorf("download.retry_delay must not be negative, got %v", c.Download.RetryDelay))
+	}
+	if c.Download.CheckpointInterval <= 0 {
+		errs = append(errs, fmt.Errorf("download.checkpoint_interval must be positive, got %v", c.Download.CheckpointInterval))
+	}
+	if c.Download.MaxActiveDownloads < 0 {
+		errs = append(errs, fmt.Errorf("download.max_active_downloads must not be negative, got %d", c.Download.MaxActiveDownloads))
+	}
+	if c.Download.MaxConnections < 0 {
+		errs = append(errs, fmt.Errorf("download.max_connections must not be negative, got %d", c.Download.MaxConnections))
+	}
+	if c.Download.DiskRecheckInterval <= 0 {
+		errs = append(errs, fmt.Errorf("download.disk_recheck_interval must be positive, got %v", c.Download.DiskRecheckInterval))
+	}
+	if c.Hosts.MaxConnections < 0 {
+		errs = append(errs, fmt.Errorf("hosts.max_connections must not be negative, got %d", c.Hosts.MaxConnections))
+	}
+	if c.Hosts.RequestDelay < 0 {
+		errs = append(errs, fmt.Errorf("hosts.request_delay must not be negative, got %v", c.Hosts.RequestDelay))
+	}
+	for i, rule := range c.Hosts.Rules {
+		if _, err := path.Match(rule.Pattern, ""); rule.Pattern == "" || err != nil {
+			errs = append(errs, fmt.Errorf("hosts.rule %d: invalid pattern %q", i+1, rule.Pattern))
+		}
+		if rule.MaxConnections != nil && *rule.MaxConnections < 0 {
+			errs = append(errs, fmt.Errorf("hosts.rule %d: max_connections must not be negative, got %d", i+1, *rule.MaxConnections))
+		}
+		if rule.RequestDelay != nil && *rule.RequestDelay < 0 {
+			errs = append(errs, fmt.Errorf("hosts.rule %d: request_delay must not be negative, got %v", i+1, *rule.RequestDelay))
+		}
+	}
+	if c.Queue.ConcurrentDownloadLimit < 1 {
+		errs = append(errs, fmt.Errorf("queue.concurrent_download_limit must be at least 1, got %d", c.Queue.ConcurrentDownloadLimit))
+	}
+	if c.Queue.SpeedLimitKB < 0 {
+		errs = append(errs, fmt.Errorf("queue.speed_limit_kb must not be negative, got %d", c.Queue.SpeedLimitKB))
+	}
+	if c.Queue.Window <= 0 {
+		errs = append(errs, fmt.Errorf("queue.window must be positive, got %v", c.Queue.Window))
+	}
+	if c.API.Enabled {
+		host, _, err := net.SplitHostPort(c.API.Listen)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("api.listen must be host:port, got %q", c.API.Listen))
+		} else if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) && c.API.Token == "" {
+			// Anyone who can reach the API controls the downloads
+			errs = append(errs, fmt.Errorf("api.token must be set when api.listen is not a loopback address, got %q", c.API.Listen))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	return nil
+}
@@ -1,15 +1,25 @@
 package config
 
-var (
-	WELCOME_MESSAGE string
-	WORKERS_NUM     int
-	TMP_FILE_PREFIX string
-	JSON_ADDRESS string
-)
+const (
+	// APP_NAME is used as the directory name under the XDG config and data homes.
+	APP_NAME = "tech-download-manager"
+
+	// CONFIG_FILE_NAME is the name of the config file inside the config directory.
+	CONFIG_FILE_NAME = "config.toml"
+
+	// STATE_FILE_NAME is the default name of the persisted queue state file.
+	STATE_FILE_NAME = "queues.json"
+
+	// STATE_DB_NAME is the default name of the embedded state database.
+	STATE_DB_NAME = "state.db"
 
-func LoadEnv() {
-	TMP_FILE_PREFIX = "tmpfile"
-	WELCOME_MESSAGE = "asd"
-	WORKERS_NUM = 5
-	JSON_ADDRESS = "./../queues.json"
-}
+	// SOCKET_NAME is the default name of the daemon's control socket.
+	SOCKET_NAME = "daemon.sock"
+
+	// Supported state store backends.
+	STATE_BACKEND_JSON = "json"
+	STATE_BACKEND_BOLT = "bolt"
+
+	// ENV_PREFIX is prepended to every environment variable override.
+	ENV_PREFIX = "TDM_"
+)
@@ -0,0 +1,322 @@
+package controller
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/mjghr/tech-download-manager/config"
+	"github.com/mjghr/tech-download-manager/ui/logs"
+)
+
+// MIN_ADOPT_SPLIT is the smallest remainder of an adopted chunk that is split
+// again so the rest of the download still uses several connections.
+const MIN_ADOPT_SPLIT = 2 * 1024 * 1024
+
+// Aria2Control is the part of an aria2 .aria2 control file that tells which
+// pieces of a plain HTTP download are already on disk.
+type Aria2Control struct {
+	PieceLength int
+	TotalLength int
+	Bitfield    []byte
+}
+
+// ReadAria2Control parses an aria2 control file. Version 1 files are big
+// endian; version 0 files use the byte order of the machine that wrote them,
+// which is assumed to be little endian.
+func ReadAria2Control(path string) (*Aria2Control, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var version uint16
+	if err := binary.Read(file, binary.BigEndian, &version); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var order binary.ByteOrder
+	switch version {
+	case 0:
+		order = binary.LittleEndian
+	case 1:
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("unsupported aria2 control file version %d in %s", version, path)
+	}
+
+	var header struct {
+		Extension  uint32
+		InfoHashes uint32
+	}
+	if err := binary.Read(file, order, &header); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if header.InfoHashes > 0 {
+		return nil, fmt.Errorf("%s belongs to a torrent download", path)
+	}
+	var fields struct {
+		PieceLength    uint32
+		TotalLength    uint64
+		UploadLength   uint64
+		BitfieldLength uint32
+	}
+	if err := binary.Read(file, order, &fields); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if fields.PieceLength == 0 {
+		return nil, fmt.Errorf("invalid piece length in %s", path)
+	}
+	pieces := (fields.TotalLength + uint64(fields.PieceLength) - 1) / uint64(fields.PieceLength)
+	if uint64(fields.BitfieldLength) != (pieces+7)/8 {
+		return nil, fmt.Errorf("bitfield of %s does not match its length", path)
+	}
+
+	control := &Aria2Control{
+		PieceLength: int(fields.PieceLength),
+		TotalLength: int(fields.TotalLength),
+		Bitfield:    make([]byte, fields.BitfieldLength),
+	}
+	if _, err := io.ReadFull(file, control.Bitfield); err != nil {
+		return nil, fmt.Errorf("failed to read bitfield of %s: %w", path, err)
+	}
+	// Pieces in flight follow; their partial data is fetched again
+	return control, nil
+}
+
+// CompletedRanges returns the inclusive byte ranges of the finished pieces,
+// with adjacent pieces joined.
+func (c *Aria2Control) CompletedRanges() [][2]int {
+	var ranges [][2]int
+	pieces := (c.TotalLength + c.PieceLength - 1) / c.PieceLength
+	for i := 0; i < pieces; i++ {
+		if c.Bitfield[i/8]&(0x80>>(i%8)) == 0 {
+			continue
+		}
+		start := i * c.PieceLength
+		end := min(start+c.PieceLength, c.TotalLength) - 1
+		if n := len(ranges); n > 0 && ranges[n-1][1]+1 == start {
+			ranges[n-1][1] = end
+		} else {
+			ranges = append(ranges, [2]int{start, end})
+		}
+	}
+	return ranges
+}
+
+// AdoptPartial takes over a file another tool left half downloaded at
+// partialPath. With an aria2 control file next to it only the finished
+// pieces are trusted; otherwise the file is taken to be a prefix of the
+// download, as wget -c and curl -C leave it. The bytes become chunk files in
+// tmpPath, the partial file itself becoming the first when it can be renamed
+// there, and the partial file and its control file are removed. It returns
+// how many bytes were adopted, and 0 when there is no partial file. Without
+// room for the copies it returns a *DiskSpaceError and adopts nothing.
+func (d *DownloadController) AdoptPartial(partialPath, tmpPath string) (int, error) {
+	info, err := os.Stat(partialPath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	controlPath := partialPath + ".aria2"
+	var ranges [][2]int
+	if control, err := ReadAria2Control(controlPath); err == nil {
+		if control.TotalLength != d.TotalSize {
+			return 0, fmt.Errorf("%s is for %d bytes but the server now reports %d", controlPath, control.TotalLength, d.TotalSize)
+		}
+		for _, r := range control.CompletedRanges() {
+			// A preallocated file may be shorter than its bitfield claims
+			if r[0] < int(info.Size()) {
+				ranges = append(ranges, [2]int{r[0], min(r[1], int(info.Size())-1)})
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	} else {
+		controlPath = ""
+		if int(info.Size()) > d.TotalSize {
+			return 0, fmt.Errorf("%s is larger than the %d bytes the server reports", partialPath, d.TotalSize)
+		}
+		if info.Size() > 0 {
+			ranges = [][2]int{{0, int(info.Size()) - 1}}
+		}
+	}
+
+	chunks, completed := adoptedLayout(ranges, d.TotalSize, config.Get().Download.MaxWorkers)
+	workDir := d.WorkDir(tmpPath)
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create work directory: %w", err)
+	}
+
+	// On the same file system the partial file becomes chunk 0, which it
+	// starts, so only the other chunks need room for a copy
+	move := completed[0] > 0 && sameFileSystem(filepath.Dir(partialPath), tmpPath)
+	var needed int64
+	for idx, n := range completed {
+		if idx > 0 || !move {
+			needed += int64(n)
+		}
+	}
+	if free, err := freeSpace(tmpPath); err == nil && free < needed {
+		os.RemoveAll(workDir)
+		return 0, &DiskSpaceError{Path: tmpPath, Needed: needed, Free: free}
+	}
+
+	source, err := os.Open(partialPath)
+	if err != nil {
+		return 0, err
+	}
+	adopted := 0
+	for idx, chunk := range chunks {
+		if idx > 0 || !move {
+			if err := copyRange(source, d.chunkFile(tmpPath, idx), chunk[0], completed[idx]); err != nil {
+				source.Close()
+				// Leftovers would be resumed with the layout of a fresh download
+				os.RemoveAll(workDir)
+				return 0, err
+			}
+		}
+		adopted += completed[idx]
+	}
+	source.Close()
+
+	discard := func() {
+		if move {
+			// Give back the bytes the partial file still holds
+			os.Rename(d.chunkFile(tmpPath, 0), partialPath)
+		}
+		os.RemoveAll(workDir)
+	}
+	if move {
+		if err := moveIntoChunk(partialPath, d.chunkFile(tmpPath, 0), completed[0]); err != nil {
+			os.RemoveAll(workDir)
+			return 0, err
+		}
+	}
+	// The chunks must be on disk before the manifest claims them and the
+	// partial file is gone
+	if err := syncDir(workDir); err != nil {
+		discard()
+		return 0, err
+	}
+
+	d.Mutex.Lock()
+	d.Chunks = chunks
+	d.CompletedBytes = completed
+	d.Mutex.Unlock()
+	if err := d.writeManifest(tmpPath); err != nil {
+		discard()
+		return 0, err
+	}
+
+	if !move {
+		if err := os.Remove(partialPath); err != nil {
+			logs.Log(fmt.Sprintf("Warning: failed to remove adopted file %s: %v", partialPath, err))
+		}
+	}
+	if controlPath != "" {
+		if err := os.Remove(controlPath); err != nil {
+			logs.Log(fmt.Sprintf("Warning: failed to remove %s: %v", controlPath, err))
+		}
+	}
+	logs.Log(fmt.Sprintf("Adopted %d of %d bytes of %s from %s in %d chunks", adopted, d.TotalSize, d.FileName, partialPath, len(chunks)))
+	return adopted, nil
+}
+
+// copyRange writes n bytes of source starting at offset to a new file and
+// syncs it.
+func copyRange(source *os.File, fileName string, offset, n int) error {
+	out, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", fileName, err)
+	}
+	if _, err := io.Copy(out, io.NewSectionReader(source, int64(offset), int64(n))); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy into %s: %w", fileName, err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to sync %s: %w", fileName, err)
+	}
+	return out.Close()
+}
+
+// moveIntoChunk renames the partial file to the chunk file fileName and cuts
+// it to its first n bytes, which the chunk holds, and syncs it. The partial
+// file is put back if it cannot be made a chunk.
+func moveIntoChunk(partialPath, fileName string, n int) error {
+	if err := os.Rename(partialPath, fileName); err != nil {
+		return fmt.Errorf("failed to move %s: %w", partialPath, err)
+	}
+	err := os.Truncate(fileName, int64(n))
+	if err == nil {
+		var file *os.File
+		if file, err = os.OpenFile(fileName, os.O_WRONLY, 0); err == nil {
+			err = file.Sync()
+			file.Close()
+		}
+	}
+	if err != nil {
+		os.Rename(fileName, partialPath)
+		return fmt.Errorf("failed to adopt %s: %w", partialPath, err)
+	}
+	return syncDir(filepath.Dir(partialPath))
+}
+
+// adoptedLayout turns finished byte ranges into chunks whose completed part
+// is a prefix, as Download expects: each range starts a chunk that runs up to
+// the next one. Only the longest ranges are kept when there are more than
+// workers allows, and large unfinished tails are split so the rest of the
+// download is fetched over several connections.
+func adoptedLayout(ranges [][2]int, totalSize, workers int) ([][2]int, []int) {
+	if len(ranges) > workers-1 && len(ranges) > 1 {
+		sort.Slice(ranges, func(i, j int) bool {
+			return ranges[i][1]-ranges[i][0] > ranges[j][1]-ranges[j][0]
+		})
+		ranges = ranges[:max(workers-1, 1)]
+		sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	}
+
+	var starts, completed []int
+	if len(ranges) == 0 || ranges[0][0] > 0 {
+		starts = append(starts, 0)
+		completed = append(completed, 0)
+	}
+	for _, r := range ranges {
+		starts = append(starts, r[0])
+		completed = append(completed, r[1]-r[0]+1)
+	}
+	chunks := make([][2]int, len(starts))
+	for i, start := range starts {
+		end := totalSize - 1
+		if i+1 < len(starts) {
+			end = starts[i+1] - 1
+		}
+		chunks[i] = [2]int{start, end}
+	}
+
+	for len(chunks) < workers {
+		largest, remaining := -1, 0
+		for i, chunk := range chunks {
+			if left := chunk[1] - chunk[0] + 1 - completed[i]; left > remaining {
+				largest, remaining = i, left
+			}
+		}
+		if largest < 0 || remaining < MIN_ADOPT_SPLIT {
+			break
+		}
+		chunk := chunks[largest]
+		mid := chunk[0] + completed[largest] + remaining/2
+		chunks[largest][1] = mid - 1
+		chunks = append(chunks[:largest+1], append([][2]int{{mid, chunk[1]}}, chunks[largest+1:]...)...)
+		completed = append(completed[:largest+1], append([]int{0}, completed[largest+1:]...)...)
+	}
+	return chunks, completed
+}
@@ -0,0 +1,366 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltMetaBucket      = []byte("meta")
+	boltQueuesBucket    = []byte("queues")
+	boltDownloadsBucket = []byte("downloads")
+
+	boltVersionKey    = []byte("version")
+	boltQueueOrderKey = []byte("queueOrder")
+)
+
+// BoltStore keeps one record per queue and per download in an embedded bbolt
+// database, so a change to one download rewrites only that record.
+//
+// Records use the same JSON shapes as the state file. A queue record carries
+// its download IDs in "downloadIds" instead of the downloads themselves.
+type BoltStore struct {
+	path string
+	db   *bolt.DB
+}
+
+func OpenBoltStore(path string) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("could not create directory: %v", err)
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open state database %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltMetaBucket, boltQueuesBucket, boltDownloadsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		meta := tx.Bucket(boltMetaBucket)
+		if meta.Get(boltVersionKey) == nil {
+			return meta.Put(boltVersionKey, []byte(strconv.Itoa(STATE_VERSION)))
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not initialize state database %s: %w", path, err)
+	}
+
+	s := &BoltStore{path: path, db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate rewrites every record in the current schema if the database was
+// written by an older build, and refuses databases from newer builds.
+func (s *BoltStore) migrate() error {
+	var version int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		version, err = strconv.Atoi(string(tx.Bucket(boltMetaBucket).Get(boltVersionKey)))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("invalid schema version in %s: %w", s.path, err)
+	}
+	if version > STATE_VERSION {
+		return &UnsupportedVersionError{Path: s.path, Version: version}
+	}
+	if version == STATE_VERSION {
+		return nil
+	}
+
+	queues, err := s.Load()
+	if err != nil {
+		return err
+	}
+	return s.Save(queues)
+}
+
+func (s *BoltStore) Load() ([]*QueueController, error) {
+	var doc []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		version, err := strconv.Atoi(string(tx.Bucket(boltMetaBucket).Get(boltVersionKey)))
+		if err != nil {
+			return fmt.Errorf("invalid schema version: %w", err)
+		}
+
+		order, err := getQueueOrder(tx)
+		if err != nil {
+			return err
+		}
+
+		queues := make([]map[string]json.RawMessage, 0, len(order))
+		for _, queueID := range order {
+			raw := tx.Bucket(boltQueuesBucket).Get([]byte(queueID))
+			if raw == nil {
+				continue
+			}
+			record, err := assembleQueueRecord(tx, raw)
+			if err != nil {
+				return fmt.Errorf("invalid record for queue %s: %w", queueID, err)
+			}
+			queues = append(queues, record)
+		}
+
+		// Reassemble the state file envelope so records go through the same
+		// migrations as the JSON backend.
+		doc, err = json.Marshal(map[string]any{"version": version, "queues": queues})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read state database %s: %w", s.path, err)
+	}
+	return decodeState(s.path, doc)
+}
+
+// assembleQueueRecord replaces a queue record's "downloadIds" with the
+// download records they point to.
+func assembleQueueRecord(tx *bolt.Tx, raw []byte) (map[string]json.RawMessage, error) {
+	var record map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &record); err != nil {
+		return nil, err
+	}
+	var downloadIDs []string
+	if ids, ok := record["downloadIds"]; ok {
+		if err := json.Unmarshal(ids, &downloadIDs); err != nil {
+			return nil, err
+		}
+	}
+	delete(record, "downloadIds")
+
+	downloads := make([]json.RawMessage, 0, len(downloadIDs))
+	for _, id := range downloadIDs {
+		if d := tx.Bucket(boltDownloadsBucket).Get([]byte(id)); d != nil {
+			downloads = append(downloads, append(json.RawMessage(nil), d...))
+		}
+	}
+	list, err := json.Marshal(downloads)
+	if err != nil {
+		return nil, err
+	}
+	record["downloadControllers"] = list
+	return record, nil
+}
+
+func (s *BoltStore) Save(queues []*QueueController) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltQueuesBucket, boltDownloadsBucket} {
+			if err := tx.DeleteBucket(name); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+
+		order := make([]string, 0, len(queues))
+		for _, qc := range queues {
+			if err := putQueue(tx, qc); err != nil {
+				return err
+			}
+			for _, dc := range qc.Downloads() {
+				if err := putDownload(tx, dc); err != nil {
+					return err
+				}
+			}
+			order = append(order, qc.QueueID)
+		}
+		if err := putQueueOrder(tx, order); err != nil {
+			return err
+		}
+		return tx.Bucket(boltMetaBucket).Put(boltVersionKey, []byte(strconv.Itoa(STATE_VERSION)))
+	})
+}
+
+func (s *BoltStore) SaveQueue(qc *QueueController) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := putQueue(tx, qc); err != nil {
+			return err
+		}
+		order, err := getQueueOrder(tx)
+		if err != nil {
+			return err
+		}
+		for _, id := range order {
+			if id == qc.QueueID {
+				return nil
+			}
+		}
+		return putQueueOrder(tx, append(order, qc.QueueID))
+	})
+}
+
+// SaveDownload upserts dc and lists it in its queue's record, and in no other.
+func (s *BoltStore) SaveDownload(dc *DownloadController) error {
+	dc.Mutex.Lock()
+	queueID := dc.QueueID
+	dc.Mutex.Unlock()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := putDownload(tx, dc); err != nil {
+			return err
+		}
+		return linkDownload(tx, dc.ID, queueID)
+	})
+}
+
+func (s *BoltStore) DeleteQueue(queueID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		queues := tx.Bucket(boltQueuesBucket)
+		if raw := queues.Get([]byte(queueID)); raw != nil {
+			var record struct {
+				DownloadIDs []string `json:"downloadIds"`
+			}
+			if err := json.Unmarshal(raw, &record); err == nil {
+				for _, id := range record.DownloadIDs {
+					if err := tx.Bucket(boltDownloadsBucket).Delete([]byte(id)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if err := queues.Delete([]byte(queueID)); err != nil {
+			return err
+		}
+
+		order, err := getQueueOrder(tx)
+		if err != nil {
+			return err
+		}
+		for i, id := range order {
+			if id == queueID {
+				order = append(order[:i], order[i+1:]...)
+				break
+			}
+		}
+		return putQueueOrder(tx, order)
+	})
+}
+
+func (s *BoltStore) DeleteDownload(downloadID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltDownloadsBucket).Delete([]byte(downloadID)); err != nil {
+			return err
+		}
+		return linkDownload(tx, downloadID, "")
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// putQueue stores qc without its downloads, keeping only their order.
+func putQueue(tx *bolt.Tx, qc *QueueController) error {
+	// The queue is read under its lock with each download encoded as its ID
+	raw, err := encodeQueue(qc, func(dc *DownloadController) ([]byte, error) {
+		return json.Marshal(dc.ID)
+	})
+	if err != nil {
+		return err
+	}
+	var record map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &record); err != nil {
+		return err
+	}
+	record["downloadIds"] = record["downloadControllers"]
+	delete(record, "downloadControllers")
+
+	raw, err = json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltQueuesBucket).Put([]byte(qc.QueueID), raw)
+}
+
+func putDownload(tx *bolt.Tx, dc *DownloadController) error {
+	raw, err := dc.encodeRecord()
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltDownloadsBucket).Put([]byte(dc.ID), raw)
+}
+
+// linkDownload lists downloadID in the "downloadIds" of queue queueID,
+// appending it if missing, and removes it from every other queue record. An
+// empty queueID removes it from all of them.
+func linkDownload(tx *bolt.Tx, downloadID, queueID string) error {
+	queues := tx.Bucket(boltQueuesBucket)
+	updated := make(map[string][]byte)
+	err := queues.ForEach(func(key, raw []byte) error {
+		var record map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return nil // left alone, as DeleteQueue does
+		}
+		var ids []string
+		if list, ok := record["downloadIds"]; ok {
+			if err := json.Unmarshal(list, &ids); err != nil {
+				return nil
+			}
+		}
+
+		i := slices.Index(ids, downloadID)
+		switch owner := string(key) == queueID; {
+		case owner && i < 0:
+			ids = append(ids, downloadID)
+		case !owner && i >= 0:
+			ids = slices.Delete(ids, i, i+1)
+		default:
+			return nil
+		}
+
+		list, err := json.Marshal(ids)
+		if err != nil {
+			return err
+		}
+		record["downloadIds"] = list
+		if updated[string(key)], err = json.Marshal(record); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// A bucket cannot be written while it is iterated
+	for key, raw := range updated {
+		if err := queues.Put([]byte(key), raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getQueueOrder(tx *bolt.Tx) ([]string, error) {
+	var order []string
+	if raw := tx.Bucket(boltMetaBucket).Get(boltQueueOrderKey); raw != nil {
+		if err := json.Unmarshal(raw, &order); err != nil {
+			return nil, fmt.Errorf("invalid queue order: %w", err)
+		}
+	}
+	return order, nil
+}
+
+func putQueueOrder(tx *bolt.Tx, order []string) error {
+	raw, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltMetaBucket).Put(boltQueueOrderKey, raw)
+}
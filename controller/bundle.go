@@ -0,0 +1,198 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mjghr/tech-download-manager/config"
+)
+
+// BUNDLE_FORMAT marks a file as a queue bundle.
+const BUNDLE_FORMAT = "tdm-queue-bundle"
+
+// BUNDLE_VERSION is the bundle schema written by this build.
+const BUNDLE_VERSION = 1
+
+// QueueBundle is a queue as it is shared between machines: its settings and
+// what to download, without IDs, progress or runtime state. The save
+// directory is kept relative to the home directory when it is inside it.
+type QueueBundle struct {
+	Format                  string           `json:"format"`
+	Version                 int              `json:"version"`
+	Name                    string           `json:"name"`
+	SpeedLimit              int              `json:"speedLimit"`
+	ConcurrentDownloadLimit int              `json:"concurrentDownloadLimit"`
+	StartTime               time.Time        `json:"startTime"`
+	EndTime                 time.Time        `json:"endTime"`
+	Schedule                *Schedule        `json:"schedule,omitempty"`
+	Order                   OrderPolicy      `json:"order,omitempty"`
+	SaveDir                 string           `json:"saveDir,omitempty"`
+	Downloads               []BundleDownload `json:"downloads"`
+}
+
+// BundleDownload is one download of a QueueBundle.
+type BundleDownload struct {
+	URL       string            `json:"url"`
+	FileName  string            `json:"fileName,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Checksum  string            `json:"checksum,omitempty"`
+	TotalSize int               `json:"totalSize,omitempty"`
+}
+
+// NewQueueBundle captures a queue for sharing. Downloads keep their order and
+// are included whatever their status.
+func NewQueueBundle(qc *QueueController) *QueueBundle {
+	qc.mutex.Lock()
+	defer qc.mutex.Unlock()
+
+	bundle := &QueueBundle{
+		Format:                  BUNDLE_FORMAT,
+		Version:                 BUNDLE_VERSION,
+		Name:                    qc.QueueName,
+		SpeedLimit:              qc.SpeedLimit,
+		ConcurrentDownloadLimit: qc.ConcurrentDownloadLimit,
+		StartTime:               qc.StartTime,
+		EndTime:                 qc.EndTime,
+		Schedule:                qc.Schedule,
+		Order:                   qc.Order,
+		SaveDir:                 portablePath(qc.SavePath),
+		Downloads:               make([]BundleDownload, len(qc.DownloadControllers)),
+	}
+	for i, dc := range qc.DownloadControllers {
+		bundle.Downloads[i] = BundleDownload{
+			URL:       dc.Url,
+			FileName:  dc.FileName,
+			Headers:   dc.Headers,
+			Checksum:  dc.Checksum,
+			TotalSize: dc.TotalSize,
+		}
+	}
+	return bundle
+}
+
+// ReadQueueBundle parses and validates a bundle.
+func ReadQueueBundle(r io.Reader) (*QueueBundle, error) {
+	var bundle QueueBundle
+	if err := json.NewDecoder(r).Decode(&bundle); err != nil {
+		return nil, fmt.Errorf("not a queue bundle: %w", err)
+	}
+	if bundle.Format != BUNDLE_FORMAT {
+		return nil, fmt.Errorf("not a queue bundle: format is %q", bundle.Format)
+	}
+	if bundle.Version > BUNDLE_VERSION {
+		return nil, fmt.Errorf("queue bundle has version %d, but this build only supports up to version %d; please upgrade",
+			bundle.Version, BUNDLE_VERSION)
+	}
+	if bundle.Name == "" {
+		return nil, errors.New("queue bundle has no name")
+	}
+	if bundle.Schedule != nil {
+		if err := bundle.Schedule.Validate(); err != nil {
+			return nil, fmt.Errorf("queue bundle has an invalid schedule: %w", err)
+		}
+	}
+	if bundle.Order != "" {
+		order, err := ParseOrderPolicy(string(bundle.Order))
+		if err != nil {
+			return nil, fmt.Errorf("queue bundle has an invalid order: %w", err)
+		}
+		bundle.Order = order
+	}
+
+	var errs []error
+	for i, download := range bundle.Downloads {
+		u, err := url.Parse(download.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("download %d: invalid URL %q", i+1, download.URL))
+		}
+		if download.FileName != "" && download.FileName != filepath.Base(download.FileName) {
+			errs = append(errs, fmt.Errorf("download %d: file name %q must not contain a path", i+1, download.FileName))
+		}
+		if download.Checksum != "" {
+			if _, _, err := ParseChecksum(download.Checksum); err != nil {
+				errs = append(errs, fmt.Errorf("download %d: %w", i+1, err))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return &bundle, nil
+}
+
+// Write encodes the bundle as indented JSON.
+func (b *QueueBundle) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(b)
+}
+
+// NewQueue creates an empty queue with the bundle's settings and a new ID.
+// Files are saved to saveDir if given, otherwise to the bundle's directory
+// with ~ expanded on this machine, or the configured default when the bundle
+// has none. Chunk files always go to the configured temp directory. A window
+// that has already ended is moved to start now, keeping its length.
+func (b *QueueBundle) NewQueue(saveDir string) (*QueueController, error) {
+	queue := NewQueueController(b.Name)
+	if b.SpeedLimit >= 0 {
+		queue.SpeedLimit = b.SpeedLimit
+	}
+	if b.ConcurrentDownloadLimit > 0 {
+		queue.ConcurrentDownloadLimit = b.ConcurrentDownloadLimit
+	}
+	if b.EndTime.After(b.StartTime) {
+		start, end := b.StartTime, b.EndTime
+		if now := time.Now(); end.Before(now) {
+			start, end = now, now.Add(end.Sub(start))
+		}
+		queue.SetTimeWindow(start, end)
+	}
+	if b.Schedule != nil {
+		queue.SetSchedule(b.Schedule)
+	}
+	if b.Order != "" {
+		queue.SetOrder(b.Order)
+	}
+
+	if saveDir == "" {
+		saveDir = localPath(b.SaveDir)
+	}
+	if saveDir == "" {
+		saveDir = config.Get().Paths.SaveDir
+	}
+	if err := queue.SetPaths(queue.TempPath, saveDir); err != nil {
+		return nil, err
+	}
+	return queue, nil
+}
+
+// portablePath writes a path inside the home directory as ~/...
+func portablePath(path string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(home, path); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+		return filepath.ToSlash(filepath.Join("~", rel))
+	}
+	return path
+}
+
+// localPath expands a leading ~ of a portable path.
+func localPath(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, filepath.FromSlash(strings.TrimPrefix(path, "~")))
+}
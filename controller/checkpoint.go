@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mjghr/tech-download-manager/ui/logs"
+)
+
+// Checkpointer periodically persists the downloads whose progress changed
+// since the last flush, so a crash loses at most one interval of progress.
+type Checkpointer struct {
+	store    StateStore
+	interval time.Duration
+
+	mutex sync.Mutex
+	dirty map[string]*DownloadController
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var (
+	checkpointerMutex sync.Mutex
+	checkpointer      *Checkpointer
+)
+
+// SetCheckpointer installs the checkpointer every download reports progress to.
+func SetCheckpointer(c *Checkpointer) {
+	checkpointerMutex.Lock()
+	defer checkpointerMutex.Unlock()
+	checkpointer = c
+}
+
+func activeCheckpointer() *Checkpointer {
+	checkpointerMutex.Lock()
+	defer checkpointerMutex.Unlock()
+	return checkpointer
+}
+
+func NewCheckpointer(store StateStore, interval time.Duration) *Checkpointer {
+	return &Checkpointer{
+		store:    store,
+		interval: interval,
+		dirty:    make(map[string]*DownloadController),
+	}
+}
+
+// MarkDirty schedules dc to be written at the next flush. Repeated calls
+// within one interval are coalesced into a single write.
+func (c *Checkpointer) MarkDirty(dc *DownloadController) {
+	c.mutex.Lock()
+	c.dirty[dc.ID] = dc
+	c.mutex.Unlock()
+}
+
+// Flush writes every dirty download to the store. The store reads each
+// download under its own lock, so transfers are not held up by the write.
+// Downloads that could not be written stay dirty for the next flush.
+func (c *Checkpointer) Flush() error {
+	c.mutex.Lock()
+	dirty := c.dirty
+	c.dirty = make(map[string]*DownloadController)
+	c.mutex.Unlock()
+
+	var firstErr error
+	for _, dc := range dirty {
+		if err := c.store.SaveDownload(dc); err != nil {
+			logs.Log(fmt.Sprintf("Failed to checkpoint download %s: %v", dc.ID, err))
+			if firstErr == nil {
+				firstErr = err
+			}
+			// A newer mark already writes its latest progress
+			c.mutex.Lock()
+			if _, ok := c.dirty[dc.ID]; !ok {
+				c.dirty[dc.ID] = dc
+			}
+			c.mutex.Unlock()
+		}
+	}
+	return firstErr
+}
+
+// Start flushes in the background every interval until Stop is called.
+func (c *Checkpointer) Start() {
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.Flush()
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the background flushing and writes any remaining progress.
+func (c *Checkpointer) Stop() error {
+	if c.stop != nil {
+		close(c.stop)
+		<-c.done
+		c.stop = nil
+	}
+	return c.Flush()
+}
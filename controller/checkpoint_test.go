@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+)
+
+// failingStore fails to save downloads while fail is set.
+type failingStore struct {
+	StateStore
+	fail  bool
+	saved []string
+}
+
+func (s *failingStore) SaveDownload(dc *DownloadController) error {
+	if s.fail {
+		return errors.New("disk full")
+	}
+	s.saved = append(s.saved, dc.ID)
+	return nil
+}
+
+func TestCheckpointerFlushKeepsFailedDownloads(t *testing.T) {
+	store := &failingStore{fail: true}
+	c := NewCheckpointer(store, 0)
+	c.MarkDirty(&DownloadController{ID: "dc-1"})
+	if err := c.Flush(); err == nil {
+		t.Fatal("Flush succeeded with a failing store")
+	}
+
+	store.fail = false
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.saved) != 1 || store.saved[0] != "dc-1" {
+		t.Errorf("saved %v after the store recovered, want [dc-1]", store.saved)
+	}
+	if err := c.Flush(); err != nil || len(store.saved) != 1 {
+		t.Errorf("a clean flush saved %v, %v, want nothing more", store.saved, err)
+	}
+}
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mjghr/tech-download-manager/ui/logs"
+)
+
+// checksumAlgorithms are the digests a Checksum may name, spelled as in
+// aria2 input files.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":     md5.New,
+	"sha-1":   sha1.New,
+	"sha-224": sha256.New224,
+	"sha-256": sha256.New,
+	"sha-384": sha512.New384,
+	"sha-512": sha512.New,
+}
+
+// ParseChecksum validates a checksum of the form "sha-256=<hex digest>" and
+// returns its hash and expected digest.
+func ParseChecksum(checksum string) (hash.Hash, []byte, error) {
+	algorithm, digest, ok := strings.Cut(checksum, "=")
+	if !ok {
+		return nil, nil, fmt.Errorf("checksum %q must look like sha-256=<hex digest>", checksum)
+	}
+	newHash, ok := checksumAlgorithms[strings.ToLower(algorithm)]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+	h := newHash()
+	expected, err := hex.DecodeString(digest)
+	if err != nil || len(expected) != h.Size() {
+		return nil, nil, fmt.Errorf("invalid %s digest %q", algorithm, digest)
+	}
+	return h, expected, nil
+}
+
+// VerifyChecksum hashes the merged file in saveDir and compares it to the
+// download's Checksum, if it has one.
+func (d *DownloadController) VerifyChecksum(saveDir string) error {
+	if d.Checksum == "" {
+		return nil
+	}
+	h, expected, err := ParseChecksum(d.Checksum)
+	if err != nil {
+		return err
+	}
+
+	fileName := filepath.Join(saveDir, d.FileName)
+	file, err := os.Open(fileName)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for verification: %w", fileName, err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(h, file); err != nil {
+		return fmt.Errorf("failed to read %s for verification: %w", fileName, err)
+	}
+
+	if actual := h.Sum(nil); !bytes.Equal(actual, expected) {
+		return fmt.Errorf("checksum mismatch for %s: expected %x, got %x", fileName, expected, actual)
+	}
+	logs.Log(fmt.Sprintf("Checksum of %s verified", fileName))
+	return nil
+}
+
+// discardCorrupt removes the merged file and the chunk files of a download
+// that failed verification, so a retry fetches the data again instead of
+// merging the same bytes.
+func (d *DownloadController) discardCorrupt(tmpPath, saveDir string) {
+	if err := os.Remove(filepath.Join(saveDir, d.FileName)); err != nil && !os.IsNotExist(err) {
+		logs.Log(fmt.Sprintf("Warning: failed to remove %s: %v", d.FileName, err))
+	}
+	if err := d.CleanupTmpFiles(tmpPath); err != nil {
+		logs.Log(fmt.Sprintf("Warning: failed to clean up temp files for %s: %v", d.ID, err))
+	}
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/mjghr/tech-download-manager/config"
+	"github.com/mjghr/tech-download-manager/ui/logs"
+)
+
+// PAUSE_DISK_FULL is the PauseReason of a download paused because its temp or
+// save directory ran out of space; it resumes by itself once there is room.
+const PAUSE_DISK_FULL = "disk full"
+
+// errDiskFull stops a transfer that has no room to write.
+var errDiskFull = fmt.Errorf("%w: the disk is full", errStopped)
+
+// DiskSpaceError reports a directory without room for a download.
+type DiskSpaceError struct {
+	Path   string
+	Needed int64
+	Free   int64
+}
+
+func (e *DiskSpaceError) Error() string {
+	return fmt.Sprintf("not enough space in %s: %d bytes needed, %d free", e.Path, e.Needed, e.Free)
+}
+
+// isDiskFull reports whether err is a write that failed for lack of space.
+func isDiskFull(err error) bool {
+	var spaceErr *DiskSpaceError
+	return errors.Is(err, syscall.ENOSPC) || errors.As(err, &spaceErr)
+}
+
+// CheckDiskSpace returns a *DiskSpaceError unless tmpPath has room for the
+// rest of the download and saveDir for the merged file, both at once when
+// they are on the same file system. Directories whose free space cannot be
+// told are assumed to have room.
+func (d *DownloadController) CheckDiskSpace(tmpPath, saveDir string) error {
+	completed, total := d.Progress()
+	// Space reserved by preallocation is free for this download only
+	remaining := int64(total-completed) - d.reservedBytes(tmpPath)
+	needs := []struct {
+		path  string
+		bytes int64
+	}{{tmpPath, max(remaining, 0)}, {saveDir, int64(total)}}
+	if sameFileSystem(tmpPath, saveDir) {
+		needs = needs[1:]
+		needs[0].bytes += max(remaining, 0)
+	}
+
+	for _, need := range needs {
+		free, err := freeSpace(need.path)
+		if err != nil {
+			logs.Log(fmt.Sprintf("Warning: cannot tell the free space in %s: %v", need.path, err))
+			continue
+		}
+		if free < need.bytes {
+			return &DiskSpaceError{Path: need.path, Needed: need.bytes, Free: free}
+		}
+	}
+	return nil
+}
+
+// reservedBytes returns how much space the chunk files in tmpPath have
+// allocated beyond their size.
+func (d *DownloadController) reservedBytes(tmpPath string) int64 {
+	d.Mutex.Lock()
+	chunks := len(d.Chunks)
+	d.Mutex.Unlock()
+
+	var reserved int64
+	for idx := 0; idx < chunks; idx++ {
+		info, err := os.Stat(d.chunkFile(tmpPath, idx))
+		if err != nil {
+			continue
+		}
+		reserved += max(allocatedBytes(info)-info.Size(), 0)
+	}
+	return reserved
+}
+
+// discardMerged removes a merged file left incomplete when the disk filled up.
+func (d *DownloadController) discardMerged(saveDir string) {
+	if err := os.Remove(filepath.Join(saveDir, d.FileName)); err != nil && !os.IsNotExist(err) {
+		logs.Log(fmt.Sprintf("Warning: failed to remove %s: %v", d.FileName, err))
+	}
+}
+
+// waitForSpace blocks while dc is paused for lack of space, checking every
+// DiskRecheckInterval for room in the queue's directories. It reports true
+// once there is room, and false when the download was resumed, canceled or
+// moved by other means meanwhile.
+func (qc *QueueController) waitForSpace(dc *DownloadController) bool {
+	for {
+		time.Sleep(config.Get().Download.DiskRecheckInterval)
+		if !dc.PausedFor(PAUSE_DISK_FULL) || dc.queueID() != qc.QueueID {
+			return false
+		}
+		err := dc.CheckDiskSpace(qc.TempPath, qc.SavePath)
+		if err == nil {
+			logs.Log(fmt.Sprintf("There is room for download %s again", dc.ID))
+			return true
+		}
+		logs.Log(fmt.Sprintf("Download %s still waits for space: %v", dc.ID, err))
+	}
+}
+
+// continueWhenRoom hands dc, paused for lack of space, to the queue to
+// continue once there is room, as processDownload does for the downloads it
+// runs.
+func (qc *QueueController) continueWhenRoom(dc *DownloadController) {
+	logs.Log(fmt.Sprintf("Download %s will resume once there is room", dc.ID))
+	go func() {
+		if qc.waitForSpace(dc) {
+			qc.enqueue(dc)
+		}
+	}()
+}
@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// freeSpace returns the bytes available to unprivileged users in the file
+// system holding path.
+func freeSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+// sameFileSystem reports whether a and b are on the same file system.
+func sameFileSystem(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	statA, okA := infoA.Sys().(*syscall.Stat_t)
+	statB, okB := infoB.Sys().(*syscall.Stat_t)
+	return okA && okB && statA.Dev == statB.Dev
+}
+
+// allocatedBytes returns the disk space a file takes up.
+func allocatedBytes(info os.FileInfo) int64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Blocks * 512
+	}
+	return info.Size()
+}
+
+// preallocate reserves n bytes of file from offset on. File systems that
+// cannot do so are left to allocate as the file is written.
+func preallocate(file *os.File, offset, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	// FALLOC_FL_KEEP_SIZE reserves the blocks without changing the file's
+	// size, which Download reads as the chunk's progress
+	const keepSize = 0x1
+	err := syscall.Fallocate(int(file.Fd()), keepSize, offset, n)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return nil
+	}
+	return err
+}
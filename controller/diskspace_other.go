@@ -0,0 +1,29 @@
+//go:build !linux
+
+package controller
+
+import (
+	"errors"
+	"os"
+)
+
+// freeSpace cannot tell the free space on this platform.
+func freeSpace(path string) (int64, error) {
+	return 0, errors.New("free space is not known on this platform")
+}
+
+// sameFileSystem cannot tell file systems apart on this platform.
+func sameFileSystem(a, b string) bool {
+	return false
+}
+
+// allocatedBytes returns the file's size, taken to be the space it takes up.
+func allocatedBytes(info os.FileInfo) int64 {
+	return info.Size()
+}
+
+// preallocate does nothing on this platform; the file is allocated as it is
+// written.
+func preallocate(file *os.File, offset, n int64) error {
+	return nil
+}
@@ -2,9 +2,13 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 
@@ -13,17 +17,6 @@ import (
 	"github.com/mjghr/tech-download-manager/ui/logs"
 )
 
-type Status int
-
-const (
-	NOT_STARTED Status = iota
-	PAUSED
-	FAILED
-	COMPLETED
-	ONGOING
-	CANCELED
-)
-
 type DownloadController struct {
 	ID             string             `json:"id"`
 	QueueID        string             `json:"queueId"`
@@ -33,17 +26,36 @@ type DownloadController struct {
 	Chunks         [][2]int           `json:"chunks"`
 	CompletedBytes []int              `json:"completedBytes"`
 	TotalSize      int                `json:"totalSize"`
-	HttpClient     *client.HTTPClient `json:"httpClient"`
+	HttpClient     *client.HTTPClient `json:"-"`
 	SpeedLimit     int                `json:"speedLimit"`
+	Headers        map[string]string  `json:"headers,omitempty"`
+	Checksum       string             `json:"checksum,omitempty"`
+	PauseReason    string             `json:"pauseReason,omitempty"` // why the manager paused it, empty for the user
+	Priority       int                `json:"priority,omitempty"`    // higher starts first
+	ETag           string             `json:"etag,omitempty"`
+	LastModified   string             `json:"lastModified,omitempty"`
+	History        []Transition       `json:"history,omitempty"` // status changes, oldest first
 
-	PauseChan   chan bool            `json:"-"`
 	Mutex       sync.Mutex           `json:"-"`
-	ResumeChan  chan bool            `json:"-"`
 	TokenBucket chan struct{}        `json:"-"`
 	CancelFuncs []context.CancelFunc `json:"-"`
 	ctx         context.Context      `json:"-"`
+
+	// durableBytes holds how many bytes of each chunk were on disk at its
+	// last sync; checkpoints persist these rather than CompletedBytes, see
+	// durableProgress.
+	durableBytes []int `json:"-"`
+
+	// stop ends the running transfer, if any, without canceling the
+	// download; transferDone is closed once it has returned.
+	stop         context.CancelCauseFunc `json:"-"`
+	transferDone chan struct{}           `json:"-"`
 }
 
+// PAUSE_WINDOW_CLOSED is the PauseReason of a download paused because its
+// queue's time window closed; the queue resumes it when the next one opens.
+const PAUSE_WINDOW_CLOSED = "window closed"
+
 func (d *DownloadController) SplitIntoChunks(workers, chunkSize int) [][2]int {
 	logs.Log(fmt.Sprintf(("Starting to split download %s into %d chunks (total size: %d bytes)"), d.ID, d.Chunks, d.TotalSize))
 	arr := make([][2]int, workers)
@@ -73,31 +85,49 @@ func (d *DownloadController) SplitIntoChunks(workers, chunkSize int) [][2]int {
 	return arr
 }
 
+// Cancel cancels the download and removes its partial files once its
+// transfer, if any, has returned.
 func (d *DownloadController) Cancel(tmp string) {
+	// Deferred first so the slot is given back after the transfer returned
+	defer scheduler.release(d)
 	d.Mutex.Lock()
-	defer d.Mutex.Unlock()
-
-	if d.Status == ONGOING || d.Status == PAUSED {
-		d.Status = CANCELED
+	canceled := d.Status == ONGOING || d.Status == PAUSED || d.Status == NOT_STARTED
+	if canceled {
+		d.moveTo(CANCELED, "")
 		logs.Log(fmt.Sprintf("Download %s has been canceled", d.ID))
+	} else {
+		logs.Log(fmt.Sprintf("Download %s is not ongoing, paused or pending, no action taken", d.ID))
+	}
+	d.Mutex.Unlock()
+	if !canceled {
+		return
+	}
 
-		// Cancel all ongoing goroutines
-		for _, cancelFunc := range d.CancelFuncs {
-			cancelFunc()
-		}
+	// The chunks must stop writing before their files are removed
+	d.stopTransfer(errCanceled)
+	d.Mutex.Lock()
+	for _, cancelFunc := range d.CancelFuncs {
+		cancelFunc()
+	}
+	d.Mutex.Unlock()
 
-		// Clean up temporary files
-		err := d.CleanupTmpFiles(tmp)
-		if err != nil {
-			logs.Log(fmt.Sprintf("Warning: failed to clean up temp files for %s: %v", d.ID, err))
-		}
+	// Clean up temporary files
+	err := d.CleanupTmpFiles(tmp)
+	if err != nil {
+		logs.Log(fmt.Sprintf("Warning: failed to clean up temp files for %s: %v", d.ID, err))
+	}
+}
 
-		// Notify any waiting goroutines
-		close(d.PauseChan)
-		close(d.ResumeChan)
-	} else {
-		logs.Log(fmt.Sprintf("Download %s is not ongoing or paused, no action taken", d.ID))
+// RequestHeaders returns the headers for a request: the configured
+// User-Agent overridden by any custom headers.
+func RequestHeaders(custom map[string]string) map[string]string {
+	headers := map[string]string{
+		"User-Agent": config.Get().Download.UserAgent,
+	}
+	for key, value := range custom {
+		headers[key] = value
 	}
+	return headers
 }
 
 func (d *DownloadController) Download(idx int, byteChunk [2]int, tmpPath string, ctx context.Context) error {
@@ -106,11 +136,11 @@ func (d *DownloadController) Download(idx int, byteChunk [2]int, tmpPath string,
 	// Check if HttpClient is initialized
 	if d.HttpClient == nil {
 		logs.Log(fmt.Sprintf("HTTP client for download %s is nil, initializing it", d.ID))
-		d.HttpClient = &client.HTTPClient{}
+		d.HttpClient = client.NewHTTPClient()
 	}
 
 	// Define chunk file
-	fileName := fmt.Sprintf("%s/%s-%s-%d.tmp", tmpPath, config.TMP_FILE_PREFIX, d.FileName, idx)
+	fileName := d.chunkFile(tmpPath, idx)
 	logs.Log(fmt.Sprintf("Creating temporary file for chunk %d: %s", idx, fileName))
 
 	// Check if the file already exists
@@ -146,11 +176,50 @@ func (d *DownloadController) Download(idx int, byteChunk [2]int, tmpPath string,
 	}
 	defer file.Close()
 
-	headers := map[string]string{
-		"User-Agent": "tech-idm",
-		"Range":      fmt.Sprintf("bytes=%d-%d", byteChunk[0]+startOffset, byteChunk[1]),
+	// saveProgress makes the first n bytes of the chunk durable and only then
+	// lets checkpoints claim them, so a checkpoint never claims data that is
+	// not on disk.
+	saveProgress := func(n int) error {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+		d.markDurable(idx, n)
+		d.checkpoint()
+		return nil
+	}
+	// Bytes left by an earlier transfer in this process may not be synced yet
+	if startOffset > 0 {
+		if err := saveProgress(startOffset); err != nil {
+			logs.Log(fmt.Sprintf("Failed to sync %s for chunk %d: %v", fileName, idx, err))
+		}
+	}
+
+	// A chunk that is already complete must not be requested again: the
+	// Range would start past its end and the server would reject it.
+	if byteChunk[0]+startOffset > byteChunk[1] {
+		logs.Log(fmt.Sprintf("Chunk %d of %s already complete", idx, d.FileName))
+		d.setCompletedBytes(idx, startOffset)
+		return nil
+	}
+
+	if config.Get().Download.Preallocate {
+		if err := preallocate(file, int64(startOffset), int64(byteChunk[1]-byteChunk[0]+1-startOffset)); err != nil {
+			logs.Log(fmt.Sprintf("Failed to reserve space for chunk %d of %s: %v", idx, d.FileName, err))
+			return fmt.Errorf("failed to reserve space for chunk %d: %w", idx, err)
+		}
 	}
 
+	headers := RequestHeaders(d.Headers)
+	headers["Range"] = fmt.Sprintf("bytes=%d-%d", byteChunk[0]+startOffset, byteChunk[1])
+
+	release, err := hostLimiter.acquire(ctx, d.Url)
+	if err != nil {
+		logs.Log(fmt.Sprintf("Gave up waiting for a connection for chunk %d of %s: %v", idx, d.FileName, err))
+		return fmt.Errorf("waiting for a connection for chunk %d: %w", idx, err)
+	}
+	// Deferred before the body is closed, so the connection is given back after
+	defer release()
+
 	resp, err := d.HttpClient.SendRequestWithContext(ctx, "GET", d.Url, headers)
 	if err != nil {
 		logs.Log(fmt.Sprintf("Failed to send request for chunk %d of %s: %v", idx, d.FileName, err))
@@ -163,7 +232,16 @@ func (d *DownloadController) Download(idx int, byteChunk [2]int, tmpPath string,
 		return fmt.Errorf("invalid response for chunk %d: status code %d", idx, resp.StatusCode)
 	}
 
+	// A server that ignores Range answers 200 with the whole file, which
+	// would corrupt any chunk that does not start at byte 0.
+	if resp.StatusCode != http.StatusPartialContent && byteChunk[0]+startOffset != 0 {
+		logs.Log(fmt.Sprintf("Server ignored the Range request for chunk %d of %s", idx, d.FileName))
+		return fmt.Errorf("server ignored range request for chunk %d: status code %d", idx, resp.StatusCode)
+	}
+
 	startTime := time.Now()
+	lastCheckpoint := startTime
+	checkpointInterval := config.Get().Download.CheckpointInterval
 	totalRead := startOffset
 	buffer := make([]byte, 32*1024)
 
@@ -171,10 +249,9 @@ func (d *DownloadController) Download(idx int, byteChunk [2]int, tmpPath string,
 		select {
 		case <-ctx.Done():
 			logs.Log(fmt.Sprintf("Download of chunk %d for %s canceled", idx, d.FileName))
+			saveProgress(totalRead)
 			return ctx.Err()
 		default:
-			d.checkPause()
-
 			n, readErr := resp.Body.Read(buffer)
 			if n > 0 {
 				logs.Log(fmt.Sprintf("Read %d bytes for chunk %d of %s", n, idx, d.FileName))
@@ -184,35 +261,42 @@ func (d *DownloadController) Download(idx int, byteChunk [2]int, tmpPath string,
 					return fmt.Errorf("failed writing %d bytes to %s for chunk %d: %w", n, fileName, idx, writeErr)
 				}
 				totalRead += n
+				d.setCompletedBytes(idx, totalRead)
 
-				// Ensure CompletedBytes is initialized
-				if d.CompletedBytes == nil {
-					d.CompletedBytes = make([]int, len(d.Chunks))
-				}
-				if idx < len(d.CompletedBytes) {
-					d.CompletedBytes[idx] = totalRead
-					logs.Log(fmt.Sprintf("Chunk %d of %s: total bytes downloaded so far: %d", idx, d.FileName, d.CompletedBytes[idx]))
-				} else {
-					logs.Log(fmt.Sprintf("Warning: Chunk index %d is out of bounds for CompletedBytes array (length %d)", idx, len(d.CompletedBytes)))
+				if time.Since(lastCheckpoint) >= checkpointInterval {
+					if syncErr := saveProgress(totalRead); syncErr != nil {
+						logs.Log(fmt.Sprintf("Failed to sync %s for chunk %d: %v", fileName, idx, syncErr))
+					}
+					lastCheckpoint = time.Now()
 				}
 
 				if d.SpeedLimit > 0 {
-					expectedTime := float64(totalRead) / float64(d.SpeedLimit) // seconds
+					// Only bytes of this request count, or a resumed chunk would first
+					// sleep off what earlier sessions downloaded
+					expectedTime := float64(totalRead-startOffset) / float64(d.SpeedLimit) // seconds
 					elapsed := time.Since(startTime).Seconds()
 					if elapsed < expectedTime {
 						sleepDuration := time.Duration((expectedTime - elapsed) * float64(time.Second))
 						logs.Log(fmt.Sprintf("Chunk %d of %s: sleeping for %.2f seconds to respect speed limit of %d bytes/s", idx, d.FileName, sleepDuration.Seconds(), d.SpeedLimit))
-						time.Sleep(sleepDuration)
+						// A pause does not wait for the sleep to end
+						timer := time.NewTimer(sleepDuration)
+						select {
+						case <-timer.C:
+						case <-ctx.Done():
+							timer.Stop()
+						}
 					}
 				}
 			}
 
 			if readErr == io.EOF {
 				logs.Log(fmt.Sprintf("Finished reading chunk %d of %s: reached EOF", idx, d.FileName))
+				saveProgress(totalRead)
 				return nil
 			}
 			if readErr != nil {
 				logs.Log(fmt.Sprintf("Error reading chunk %d of %s: %v", idx, d.FileName, readErr))
+				saveProgress(totalRead)
 				return fmt.Errorf("error reading chunk %d of %s: %w", idx, d.FileName, readErr)
 			}
 		}
@@ -230,7 +314,7 @@ func (d *DownloadController) MergeDownloads(dirPath, mergeDir string) error {
 	defer out.Close()
 
 	for idx := range d.Chunks {
-		fileName := fmt.Sprintf("%s/%s-%s-%d.tmp", dirPath, config.TMP_FILE_PREFIX, d.FileName, idx)
+		fileName := d.chunkFile(dirPath, idx)
 		logs.Log(fmt.Sprintf(("Opening chunk %d file for merging: %s"), idx, fileName))
 		in, err := os.Open(fileName)
 		if err != nil {
@@ -252,55 +336,156 @@ func (d *DownloadController) MergeDownloads(dirPath, mergeDir string) error {
 	return nil
 }
 
+// CleanupTmpFiles removes the download's work directory in tmpPath with its
+// chunk files and manifest.
 func (d *DownloadController) CleanupTmpFiles(tmpPath string) error {
-	logs.Log(fmt.Sprintf(("Starting cleanup of temporary files for %s"), d.FileName))
-	for idx := range d.Chunks {
-		fileName := fmt.Sprintf("%s/%s-%s-%d.tmp", tmpPath, config.TMP_FILE_PREFIX, d.FileName, idx)
-		logs.Log(fmt.Sprintf(("Attempting to remove temporary file: %s"), fileName))
-		err := os.Remove(fileName)
-		if err != nil {
-			logs.Log(fmt.Sprintf(("Failed to remove temporary file %s: %v"), fileName, err))
-			return fmt.Errorf("failed to remove temporary file %s: %w", fileName, err)
-		}
-		logs.Log(fmt.Sprintf(("Successfully removed temporary file %s"), fileName))
+	dir := d.WorkDir(tmpPath)
+	logs.Log(fmt.Sprintf(("Removing work directory %s of %s"), dir, d.FileName))
+	if err := os.RemoveAll(dir); err != nil {
+		logs.Log(fmt.Sprintf(("Failed to remove work directory %s: %v"), dir, err))
+		return fmt.Errorf("failed to remove work directory %s: %w", dir, err)
 	}
 	logs.Log(fmt.Sprintf(("Completed cleanup of all temporary files for %s"), d.FileName))
 	return nil
 }
 
-func (d *DownloadController) checkPause() {
+// MoveTmpFiles moves the work directory of d from one temp directory to
+// another, copying it when the directories are on different file systems.
+func (d *DownloadController) MoveTmpFiles(from, to string) error {
+	if filepath.Clean(from) == filepath.Clean(to) {
+		return nil
+	}
+	source, target := d.WorkDir(from), d.WorkDir(to)
+	if _, err := os.Stat(source); os.IsNotExist(err) {
+		return nil
+	}
+	if _, err := os.Stat(target); err == nil {
+		return fmt.Errorf("work directory of %s already exists in %s", d.ID, to)
+	}
+	if err := os.MkdirAll(to, 0755); err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	if err := os.Rename(source, target); err == nil {
+		logs.Log(fmt.Sprintf("Moved work directory %s to %s", source, target))
+		return nil
+	}
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return fmt.Errorf("failed to read work directory %s: %w", source, err)
+	}
+	if err := os.Mkdir(target, 0755); err != nil {
+		return fmt.Errorf("failed to create work directory: %w", err)
+	}
+	for _, entry := range entries {
+		if err := copyFile(filepath.Join(source, entry.Name()), filepath.Join(target, entry.Name())); err != nil {
+			os.RemoveAll(target)
+			return fmt.Errorf("failed to move %s: %w", filepath.Join(source, entry.Name()), err)
+		}
+	}
+	if err := os.RemoveAll(source); err != nil {
+		logs.Log(fmt.Sprintf("Warning: failed to remove moved work directory %s: %v", source, err))
+	}
+	logs.Log(fmt.Sprintf("Copied work directory %s to %s", source, target))
+	return nil
+}
+
+// copyFile copies source to a new file target.
+func copyFile(source, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(target)
+		return err
+	}
+	return out.Close()
+}
+
+// beginTransfer creates the context of a new transfer of d, which Cancel
+// cancels and stopTransfer stops. finish must be called once the transfer
+// has returned.
+func (d *DownloadController) beginTransfer() (ctx context.Context, stop context.CancelCauseFunc, finish func()) {
+	parent, cancel := context.WithCancel(context.Background())
+	ctx, stop = context.WithCancelCause(parent)
+	done := make(chan struct{})
+
 	d.Mutex.Lock()
-	if d.Status == PAUSED {
-		logs.Log(fmt.Sprintf(("Download %s is paused, waiting for resume signal"), d.ID))
-		d.Mutex.Unlock()
-		<-d.ResumeChan
-		logs.Log(fmt.Sprintf(("Received resume signal for download %s"), d.ID))
-	} else {
+	d.CancelFuncs = append(d.CancelFuncs, cancel)
+	d.ctx = ctx
+	d.stop, d.transferDone = stop, done
+	d.durableBytes = make([]int, len(d.Chunks))
+	copy(d.durableBytes, d.durableProgress())
+	d.Mutex.Unlock()
+
+	return ctx, stop, func() {
+		d.Mutex.Lock()
+		if d.transferDone == done {
+			d.stop, d.transferDone = nil, nil
+		}
 		d.Mutex.Unlock()
+		stop(nil)
+		close(done)
 	}
 }
 
+// stopTransfer stops the running transfer of d with cause, keeping its
+// partial files, and waits for it to return. It reports whether there was
+// one.
+func (d *DownloadController) stopTransfer(cause error) bool {
+	d.Mutex.Lock()
+	stop, done := d.stop, d.transferDone
+	d.Mutex.Unlock()
+	if stop == nil {
+		return false
+	}
+	stop(cause)
+	<-done
+	return true
+}
+
+// Pause pauses an ongoing download and stops its transfer, so its requests
+// are canceled and their connections closed. The chunk files keep what was
+// fetched, and resuming requests each unfinished chunk again from there.
 func (d *DownloadController) Pause() {
 	d.Mutex.Lock()
-	if d.Status == ONGOING {
-		d.Status = PAUSED
+	paused := d.Status == ONGOING
+	if paused {
+		d.moveTo(PAUSED, "")
 		logs.Log(fmt.Sprintf(("Download %s has been paused"), d.ID))
 	} else {
 		logs.Log(fmt.Sprintf(("Download %s is already paused or not ongoing, no action taken"), d.ID))
 	}
 	d.Mutex.Unlock()
+	if paused {
+		d.checkpoint()
+		d.stopTransfer(errPaused)
+	}
+	scheduler.release(d)
 }
 
-func (d *DownloadController) Resume() {
-	d.Mutex.Lock()
-	if d.Status == PAUSED {
-		d.Status = ONGOING
-		logs.Log(fmt.Sprintf(("Download %s has been resumed"), d.ID))
-		d.ResumeChan <- true // Notify goroutines to resume
-	} else {
-		logs.Log(fmt.Sprintf(("Download %s is not paused, no action taken"), d.ID))
+// Progress returns the bytes downloaded so far and the total size.
+func (dc *DownloadController) Progress() (completed, total int) {
+	dc.Mutex.Lock()
+	defer dc.Mutex.Unlock()
+	for _, n := range dc.CompletedBytes {
+		completed += n
 	}
-	d.Mutex.Unlock()
+	return completed, dc.TotalSize
+}
+
+// URL returns the URL the download is fetched from.
+func (dc *DownloadController) URL() string {
+	dc.Mutex.Lock()
+	defer dc.Mutex.Unlock()
+	return dc.Url
 }
 
 func (dc *DownloadController) GetStatus() Status {
@@ -309,23 +494,225 @@ func (dc *DownloadController) GetStatus() Status {
 	return dc.Status
 }
 
-func (dc *DownloadController) SetStatus(newStatus Status) {
+// SetStatus moves the download to newStatus, or returns a *TransitionError
+// if it cannot go there from its current status.
+func (dc *DownloadController) SetStatus(newStatus Status) error {
+	return dc.setStatus(newStatus, "")
+}
+
+// setStatus is SetStatus recording reason in the history.
+func (dc *DownloadController) setStatus(newStatus Status, reason string) error {
+	dc.Mutex.Lock()
+	err := dc.moveTo(newStatus, reason)
+	dc.Mutex.Unlock()
+	if err != nil {
+		logs.Log(fmt.Sprintf("Warning: %v", err))
+		return err
+	}
+	dc.checkpoint()
+	if newStatus == ONGOING {
+		scheduler.wake()
+	} else {
+		scheduler.release(dc)
+	}
+	return nil
+}
+
+// state returns the status and pause reason together.
+func (dc *DownloadController) state() (Status, string) {
 	dc.Mutex.Lock()
 	defer dc.Mutex.Unlock()
-	dc.Status = newStatus
+	return dc.Status, dc.PauseReason
 }
 
-func (d *DownloadController) Retry(idx int, byteChunk [2]int, tmpPath string, maxRetries int) error {
-	var err error
+// queueID returns the ID of the queue the download is in.
+func (dc *DownloadController) queueID() string {
+	dc.Mutex.Lock()
+	defer dc.Mutex.Unlock()
+	return dc.QueueID
+}
+
+// pauseFor pauses a download that is downloading or verifying on the
+// manager's behalf. It reports false when the download was neither.
+func (dc *DownloadController) pauseFor(reason string) bool {
+	dc.Mutex.Lock()
+	if dc.Status != ONGOING && dc.Status != VERIFYING {
+		dc.Mutex.Unlock()
+		return false
+	}
+	dc.moveTo(PAUSED, reason)
+	dc.Mutex.Unlock()
+	logs.Log(fmt.Sprintf("Download %s has been paused: %s", dc.ID, reason))
+	dc.checkpoint()
+	scheduler.release(dc)
+	return true
+}
+
+// PausedFor reports whether the download is paused for reason.
+func (dc *DownloadController) PausedFor(reason string) bool {
+	dc.Mutex.Lock()
+	defer dc.Mutex.Unlock()
+	return dc.Status == PAUSED && dc.PauseReason == reason
+}
+
+// pendingChunks returns how many chunks are unfinished, at least one, and so
+// how many connections the download opens unless its host allows fewer. It
+// must be called with the mutex held.
+func (d *DownloadController) pendingChunks() int {
+	pending := 0
+	for idx, chunk := range d.Chunks {
+		if idx >= len(d.CompletedBytes) || d.CompletedBytes[idx] < chunk[1]-chunk[0]+1 {
+			pending++
+		}
+	}
+	return max(pending, 1)
+}
+
+// setCompletedBytes records how many bytes of chunk idx are on disk.
+func (d *DownloadController) setCompletedBytes(idx, n int) {
+	d.Mutex.Lock()
+	defer d.Mutex.Unlock()
+
+	// Ensure CompletedBytes is initialized
+	if d.CompletedBytes == nil {
+		d.CompletedBytes = make([]int, len(d.Chunks))
+	}
+	if idx < len(d.CompletedBytes) {
+		d.CompletedBytes[idx] = n
+		logs.Log(fmt.Sprintf("Chunk %d of %s: total bytes downloaded so far: %d", idx, d.FileName, n))
+	} else {
+		logs.Log(fmt.Sprintf("Warning: Chunk index %d is out of bounds for CompletedBytes array (length %d)", idx, len(d.CompletedBytes)))
+	}
+}
+
+// markDurable records that the first n bytes of chunk idx are on disk.
+func (d *DownloadController) markDurable(idx, n int) {
+	d.Mutex.Lock()
+	defer d.Mutex.Unlock()
+	if idx < len(d.durableBytes) {
+		d.durableBytes[idx] = n
+	}
+}
+
+// durableProgress returns the bytes of each chunk known to be on disk: its
+// CompletedBytes, but no more than at its last sync once a transfer has
+// written it. It must be called with the mutex held.
+func (d *DownloadController) durableProgress() []int {
+	durable := slices.Clone(d.CompletedBytes)
+	for idx := range min(len(durable), len(d.durableBytes)) {
+		durable[idx] = min(durable[idx], d.durableBytes[idx])
+	}
+	return durable
+}
+
+// downloadRecord shadows a download's progress with its durable progress.
+type downloadRecord struct {
+	*DownloadController
+	CompletedBytes []int `json:"completedBytes"`
+}
+
+// encodeRecord encodes the download as it is persisted, reading it under its
+// lock and with only the progress that is on disk.
+func (d *DownloadController) encodeRecord() ([]byte, error) {
+	d.Mutex.Lock()
+	defer d.Mutex.Unlock()
+	return json.Marshal(downloadRecord{DownloadController: d, CompletedBytes: d.durableProgress()})
+}
+
+// checkpoint schedules the download's progress to be persisted.
+func (d *DownloadController) checkpoint() {
+	if c := activeCheckpointer(); c != nil {
+		c.MarkDirty(d)
+	}
+}
+
+// PrepareRetry readies a failed or canceled download to run again, keeping
+// whatever partial chunk data survived in tmpPath.
+func (d *DownloadController) PrepareRetry(tmpPath string) error {
+	if err := d.ReconcileProgress(tmpPath); err != nil {
+		return err
+	}
+
+	d.Mutex.Lock()
+	if err := d.moveTo(NOT_STARTED, "retry"); err != nil {
+		d.Mutex.Unlock()
+		return err
+	}
+	d.CancelFuncs = nil
+	d.ctx = nil
+	d.Mutex.Unlock()
+
+	d.checkpoint()
+	scheduler.wake()
+	return nil
+}
+
+// ReconcileProgress makes the checkpointed progress and the chunk files in
+// tmpPath agree after a restart. Each chunk is trusted only up to the smaller
+// of its checkpoint and its file size; a tail written after the last
+// checkpoint may be incomplete and is truncated. Chunk files of another
+// layout are discarded first, see checkWorkDir.
+func (d *DownloadController) ReconcileProgress(tmpPath string) error {
+	d.Mutex.Lock()
+	defer d.Mutex.Unlock()
+
+	if err := d.checkWorkDir(tmpPath); err != nil {
+		return err
+	}
+
+	if len(d.CompletedBytes) != len(d.Chunks) {
+		d.CompletedBytes = make([]int, len(d.Chunks))
+	}
+
+	for idx, chunk := range d.Chunks {
+		fileName := d.chunkFile(tmpPath, idx)
+		info, err := os.Stat(fileName)
+		if os.IsNotExist(err) {
+			d.CompletedBytes[idx] = 0
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to stat chunk file %s: %w", fileName, err)
+		}
+
+		valid := min(int(info.Size()), d.CompletedBytes[idx], chunk[1]-chunk[0]+1)
+		if valid < 0 {
+			valid = 0
+		}
+		if int(info.Size()) != valid {
+			logs.Log(fmt.Sprintf("Truncating chunk file %s from %d to %d bytes", fileName, info.Size(), valid))
+			if err := os.Truncate(fileName, int64(valid)); err != nil {
+				return fmt.Errorf("failed to truncate chunk file %s: %w", fileName, err)
+			}
+		}
+		d.CompletedBytes[idx] = valid
+	}
+	return nil
+}
+
+// downloadWithRetries downloads chunk idx, trying again up to
+// download.max_retries times, download.retry_delay apart, each time from
+// where its file ends. It gives up straight away once ctx ends or the disk is
+// full.
+func (d *DownloadController) downloadWithRetries(idx int, byteChunk [2]int, tmpPath string, ctx context.Context) error {
+	maxRetries := config.Get().Download.MaxRetries
+	err := d.Download(idx, byteChunk, tmpPath, ctx)
 	for retry := 0; retry < maxRetries; retry++ {
-		logs.Log(fmt.Sprintf("Attempting retry %d for chunk %d of %s", retry+1, idx, d.FileName))
-		err = d.Download(idx, byteChunk, tmpPath, d.ctx)
-		if err == nil {
-			logs.Log(fmt.Sprintf("Successfully retried chunk %d of %s", idx, d.FileName))
-			return nil
+		if err == nil || ctx.Err() != nil || isDiskFull(err) {
+			return err
 		}
-		logs.Log(fmt.Sprintf("Retry %d for chunk %d failed: %v", retry+1, idx, err))
-		time.Sleep(time.Second * 2) // Wait before retrying
+		logs.Log(fmt.Sprintf("Chunk %d of %s failed, retrying (%d of %d): %v", idx, d.FileName, retry+1, maxRetries, err))
+		timer := time.NewTimer(config.Get().Download.RetryDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		err = d.Download(idx, byteChunk, tmpPath, ctx)
+	}
+	if err != nil && maxRetries > 0 && ctx.Err() == nil && !isDiskFull(err) {
+		return fmt.Errorf("chunk %d failed after %d retries: %w", idx, maxRetries, err)
 	}
-	return fmt.Errorf("failed to download chunk %d after %d retries: %w", idx, maxRetries, err)
+	return err
 }
@@ -0,0 +1,186 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mjghr/tech-download-manager/config"
+	"github.com/mjghr/tech-download-manager/ui/logs"
+)
+
+// DuplicateAction says what happens when a download being added duplicates
+// one already known, or a file already at its destination.
+type DuplicateAction string
+
+const (
+	// DUPLICATE_ASK adds nothing and returns a *DuplicateError, so the user
+	// can choose one of the other actions.
+	DUPLICATE_ASK DuplicateAction = "ask"
+	// DUPLICATE_SKIP adds nothing either; the *DuplicateError tells why.
+	DUPLICATE_SKIP DuplicateAction = "skip"
+	// DUPLICATE_REDOWNLOAD starts the existing download over, or replaces the
+	// existing file once the new download finishes.
+	DUPLICATE_REDOWNLOAD DuplicateAction = "redownload"
+	// DUPLICATE_RESUME continues the existing download, or continues from the
+	// existing file: a complete one finishes the download without fetching
+	// anything and a shorter one is kept as its first bytes.
+	DUPLICATE_RESUME DuplicateAction = "resume"
+)
+
+// DUPLICATE_ACTIONS lists the actions in the order the UI offers them.
+var DUPLICATE_ACTIONS = []DuplicateAction{DUPLICATE_ASK, DUPLICATE_SKIP, DUPLICATE_REDOWNLOAD, DUPLICATE_RESUME}
+
+// ParseDuplicateAction returns the action with the given name, ignoring case.
+// An empty name is the configured default.
+func ParseDuplicateAction(name string) (DuplicateAction, error) {
+	if name == "" {
+		return DefaultDuplicateAction(), nil
+	}
+	for _, action := range DUPLICATE_ACTIONS {
+		if strings.EqualFold(name, string(action)) {
+			return action, nil
+		}
+	}
+	names := make([]string, len(DUPLICATE_ACTIONS))
+	for i, action := range DUPLICATE_ACTIONS {
+		names[i] = string(action)
+	}
+	return "", fmt.Errorf("unknown duplicate action %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// DefaultDuplicateAction returns the configured action for duplicates.
+func DefaultDuplicateAction() DuplicateAction {
+	name := config.Get().Download.OnDuplicate
+	for _, action := range DUPLICATE_ACTIONS {
+		if strings.EqualFold(name, string(action)) {
+			return action
+		}
+	}
+	logs.Log(fmt.Sprintf("Warning: ignoring invalid duplicate action %q", name))
+	return DUPLICATE_ASK
+}
+
+// DuplicateCheck decides when a file already at a download's destination
+// counts as a duplicate of it.
+type DuplicateCheck string
+
+const (
+	// DUPLICATE_CHECK_NAME counts any file with the download's name.
+	DUPLICATE_CHECK_NAME DuplicateCheck = "name"
+	// DUPLICATE_CHECK_SIZE counts a file only if it has the download's size.
+	DUPLICATE_CHECK_SIZE DuplicateCheck = "size"
+	// DUPLICATE_CHECK_HASH counts a file only if it matches the download's
+	// checksum, or its size when it has none.
+	DUPLICATE_CHECK_HASH DuplicateCheck = "hash"
+)
+
+// defaultDuplicateCheck returns the configured duplicate check.
+func defaultDuplicateCheck() DuplicateCheck {
+	name := config.Get().Download.DuplicateCheck
+	for _, check := range []DuplicateCheck{DUPLICATE_CHECK_NAME, DUPLICATE_CHECK_SIZE, DUPLICATE_CHECK_HASH} {
+		if strings.EqualFold(name, string(check)) {
+			return check
+		}
+	}
+	logs.Log(fmt.Sprintf("Warning: ignoring invalid duplicate check %q", name))
+	return DUPLICATE_CHECK_NAME
+}
+
+// ExistingFile is a file already at a download's destination.
+type ExistingFile struct {
+	Path string `json:"path"`
+	Size int    `json:"size"`
+	// Complete is set when the file has the download's size and matches its
+	// checksum, if it has one.
+	Complete bool `json:"complete"`
+}
+
+// FindExistingFile returns the file at the download's destination in saveDir
+// if it counts as a duplicate under the configured check, or nil.
+func (d *DownloadController) FindExistingFile(saveDir string) (*ExistingFile, error) {
+	path := filepath.Join(saveDir, d.FileName)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+
+	file := &ExistingFile{Path: path, Size: int(info.Size())}
+	file.Complete = file.Size == d.TotalSize
+	if file.Complete && d.Checksum != "" {
+		if err := d.VerifyChecksum(saveDir); err != nil {
+			logs.Log(fmt.Sprintf("Existing file %s is not %s: %v", path, d.Url, err))
+			file.Complete = false
+		}
+	}
+
+	switch defaultDuplicateCheck() {
+	case DUPLICATE_CHECK_SIZE:
+		if file.Size != d.TotalSize {
+			return nil, nil
+		}
+	case DUPLICATE_CHECK_HASH:
+		if !file.Complete {
+			return nil, nil
+		}
+	}
+	return file, nil
+}
+
+// AttachExisting continues the download from a file already at its
+// destination: a complete one finishes it without fetching anything, and a
+// shorter one is moved into chunk files in tmpPath as its first bytes.
+func (d *DownloadController) AttachExisting(file *ExistingFile, tmpPath string) error {
+	if file.Complete {
+		d.Mutex.Lock()
+		defer d.Mutex.Unlock()
+		if err := d.moveTo(COMPLETED, "the file was already complete"); err != nil {
+			return err
+		}
+		d.CompletedBytes = make([]int, len(d.Chunks))
+		for idx, chunk := range d.Chunks {
+			d.CompletedBytes[idx] = chunk[1] - chunk[0] + 1
+		}
+		logs.Log(fmt.Sprintf("Download %s attached to the complete file %s", d.ID, file.Path))
+		return nil
+	}
+	if file.Size >= d.TotalSize {
+		return fmt.Errorf("%s does not match %s, download it again instead", file.Path, d.Url)
+	}
+	if err := os.MkdirAll(tmpPath, 0755); err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	_, err := d.AdoptPartial(file.Path, tmpPath)
+	return err
+}
+
+// DuplicateError reports a download that was not added because it duplicates
+// a download of the same URL or a file at its destination.
+type DuplicateError struct {
+	URL string `json:"url"`
+	// DownloadID and QueueID name the download of the same URL, if any.
+	DownloadID string `json:"downloadId,omitempty"`
+	QueueID    string `json:"queueId,omitempty"`
+	Queue      string `json:"queue,omitempty"`
+	Status     string `json:"status,omitempty"`
+	// File is the file at the destination, if any.
+	File *ExistingFile `json:"file,omitempty"`
+}
+
+func (e *DuplicateError) Error() string {
+	switch {
+	case e.DownloadID != "":
+		return fmt.Sprintf("%s is already download %s in queue %s (%s)", e.URL, e.DownloadID, e.Queue, e.Status)
+	case e.File.Complete:
+		return fmt.Sprintf("%s is already downloaded to %s", e.URL, e.File.Path)
+	default:
+		return fmt.Sprintf("%s already exists (%d bytes), the destination of %s", e.File.Path, e.File.Size, e.URL)
+	}
+}
@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mjghr/tech-download-manager/config"
+	"github.com/mjghr/tech-download-manager/ui/logs"
+)
+
+// HostLimiter keeps the requests of all downloads and queues within each
+// host's limits from the hosts config: at most max_connections open at once,
+// and request_delay between the starts of two requests. Waiting requests get
+// a connection in the order they asked for one.
+type HostLimiter struct {
+	mutex sync.Mutex
+	hosts map[string]*hostState
+}
+
+// hostState is what the limiter knows about one host.
+type hostState struct {
+	open    int
+	waiting []chan struct{}
+	next    time.Time // when the next request may start
+}
+
+// hostLimiter limits the requests of every download in this process.
+var hostLimiter = NewHostLimiter()
+
+func NewHostLimiter() *HostLimiter {
+	return &HostLimiter{hosts: make(map[string]*hostState)}
+}
+
+// AcquireHost waits until a request to rawURL may be sent, for requests
+// made outside a download such as probes. The returned function must be
+// called once the response body is closed.
+func AcquireHost(rawURL string) (release func()) {
+	// Without a context to cancel, acquire cannot fail
+	release, _ = hostLimiter.acquire(context.Background(), rawURL)
+	return release
+}
+
+// hostKey returns the name of rawURL's host, which limits are kept by.
+func hostKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return rawURL
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// acquire blocks until rawURL's host has a free connection and its request
+// delay has passed, or ctx is done. On success the returned function gives
+// the connection back.
+func (l *HostLimiter) acquire(ctx context.Context, rawURL string) (func(), error) {
+	host := hostKey(rawURL)
+	maxConnections, delay := config.Get().Hosts.Limits(host)
+	if maxConnections <= 0 && delay <= 0 {
+		return func() {}, nil
+	}
+
+	granted := make(chan struct{})
+	l.mutex.Lock()
+	h := l.host(host)
+	h.waiting = append(h.waiting, granted)
+	l.grant(host, h)
+	if len(h.waiting) > 0 && h.waiting[len(h.waiting)-1] == granted {
+		logs.Log(fmt.Sprintf("Waiting for a connection to %s (%d open)", host, h.open))
+	}
+	l.mutex.Unlock()
+
+	select {
+	case <-granted:
+	case <-ctx.Done():
+		l.mutex.Lock()
+		for i, w := range h.waiting {
+			if w == granted {
+				h.waiting = append(h.waiting[:i], h.waiting[i+1:]...)
+				l.forget(host, h)
+				l.mutex.Unlock()
+				return nil, context.Cause(ctx)
+			}
+		}
+		// Granted just as ctx was done
+		l.mutex.Unlock()
+		l.release(host)
+		return nil, context.Cause(ctx)
+	}
+
+	// Take the next free start time, so requests are spaced by the delay
+	l.mutex.Lock()
+	start := time.Now()
+	if h.next.After(start) {
+		start = h.next
+	}
+	h.next = start.Add(delay)
+	l.mutex.Unlock()
+
+	if wait := time.Until(start); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			l.release(host)
+			return nil, context.Cause(ctx)
+		}
+	}
+
+	var once sync.Once
+	return func() { once.Do(func() { l.release(host) }) }, nil
+}
+
+// connections returns how many of wanted connections a download from
+// rawURL can open at once.
+func (l *HostLimiter) connections(rawURL string, wanted int) int {
+	maxConnections, _ := config.Get().Hosts.Limits(hostKey(rawURL))
+	if maxConnections > 0 && wanted > maxConnections {
+		return maxConnections
+	}
+	return wanted
+}
+
+// release gives back a connection to host and hands it to the next waiter.
+func (l *HostLimiter) release(host string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	h := l.host(host)
+	h.open--
+	l.grant(host, h)
+	l.forget(host, h)
+}
+
+// host returns the state of host, creating it. The mutex must be held.
+func (l *HostLimiter) host(host string) *hostState {
+	h, ok := l.hosts[host]
+	if !ok {
+		h = &hostState{}
+		l.hosts[host] = h
+	}
+	return h
+}
+
+// grant hands free connections to the first waiters. The mutex must be held.
+func (l *HostLimiter) grant(host string, h *hostState) {
+	maxConnections, _ := config.Get().Hosts.Limits(host)
+	for len(h.waiting) > 0 && (maxConnections <= 0 || h.open < maxConnections) {
+		close(h.waiting[0])
+		h.waiting = h.waiting[1:]
+		h.open++
+	}
+}
+
+// forget drops the state of a host that is idle and may be sent a request
+// right away. The mutex must be held.
+func (l *HostLimiter) forget(host string, h *hostState) {
+	if h.open == 0 && len(h.waiting) == 0 && !h.next.After(time.Now()) {
+		delete(l.hosts, host)
+	}
+}
@@ -1,13 +1,38 @@
 package controller
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+
+	"github.com/mjghr/tech-download-manager/config"
 )
 
+// BackupRestoredError is returned together with the restored queues when the
+// state file could not be read and a rotated backup was loaded instead.
+type BackupRestoredError struct {
+	Path   string
+	Backup string
+	Cause  error
+}
+
+func (e *BackupRestoredError) Error() string {
+	return fmt.Sprintf("state file %s is unreadable (%v), restored from backup %s", e.Path, e.Cause, e.Backup)
+}
+
+func (e *BackupRestoredError) Unwrap() error {
+	return e.Cause
+}
 
+// backupPath returns the name of the n-th rotated backup of filename, n >= 1.
+func backupPath(filename string, n int) string {
+	return fmt.Sprintf("%s.bak.%d", filename, n)
+}
+
+// SaveQueueControllers writes the queues to filename crash-safely: the data is
+// written to a temp file in the same directory and fsynced, the previous file is
+// kept as the newest backup, and the temp file is renamed over it.
 func SaveQueueControllers(filename string, data []*QueueController) error {
 	// Create the directory if it doesn't exist
 	dir := filepath.Dir(filename)
@@ -16,28 +41,126 @@ func SaveQueueControllers(filename string, data []*QueueController) error {
 		return fmt.Errorf("could not create directory: %v", err)
 	}
 
-	// Serialize data to JSON
-	jsonData, err := json.MarshalIndent(data, "", "  ")
+	// Serialize data to the versioned JSON envelope
+	jsonData, err := encodeState(data)
 	if err != nil {
 		return err
 	}
 
-	// Write to the file
-	return os.WriteFile(filename, jsonData, 0644)
+	// Never overwrite a file written by a newer build.
+	_, readErr := readQueueControllers(filename)
+	var tooNew *UnsupportedVersionError
+	if errors.As(readErr, &tooNew) {
+		return readErr
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("could not create temp file: %w", err)
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op once renamed
+
+	if _, err := tmp.Write(jsonData); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write temp file %s: %w", tmpName, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not sync temp file %s: %w", tmpName, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not close temp file %s: %w", tmpName, err)
+	}
+
+	// Only back up a file that is itself valid, so a missing or corrupt file
+	// never pushes a good backup out of the rotation.
+	if readErr == nil {
+		if err := rotateBackups(filename, config.Get().Paths.StateBackups); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmpName, filename); err != nil {
+		return fmt.Errorf("could not replace %s: %w", filename, err)
+	}
+	return syncDir(dir)
 }
 
+// rotateBackups shifts filename.bak.1..n-1 up by one and links or copies the
+// current file to filename.bak.1, leaving it in place until the new file is
+// renamed over it. With keep == 0 no backups are kept.
+func rotateBackups(filename string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
 
-func LoadQueueControllers(filename string) ([]*QueueController, error) {
-	jsonData, err := os.ReadFile(filename)
+	for n := keep - 1; n >= 1; n-- {
+		err := os.Rename(backupPath(filename, n), backupPath(filename, n+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not rotate backup %s: %w", backupPath(filename, n), err)
+		}
+	}
+
+	backup := backupPath(filename, 1)
+	if err := os.Remove(backup); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not rotate backup %s: %w", backup, err)
+	}
+	if err := os.Link(filename, backup); err != nil {
+		// Not every filesystem supports hard links
+		if err := copyFile(filename, backup); err != nil {
+			return fmt.Errorf("could not back up %s: %w", filename, err)
+		}
+	}
+	return nil
+}
+
+// syncDir fsyncs a directory so a rename inside it is durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// Some platforms (e.g. Windows) cannot sync directories; the rename is
+	// still atomic there, so the error is ignored.
+	d.Sync()
+	return nil
+}
+
+// LoadQueueControllers reads the queues from filename. If the file is missing
+// or corrupt, the newest valid backup is loaded instead and returned together
+// with a *BackupRestoredError describing what happened.
+func LoadQueueControllers(filename string) ([]*QueueController, error) {
+	queueControllers, err := readQueueControllers(filename)
+	if err == nil {
+		return queueControllers, nil
+	}
+
+	// A newer file is not corrupt; falling back to an older backup would
+	// silently lose whatever the newer build stored.
+	var tooNew *UnsupportedVersionError
+	if errors.As(err, &tooNew) {
 		return nil, err
 	}
 
-	var queueControllers []*QueueController
-	err = json.Unmarshal(jsonData, &queueControllers)
+	for n := 1; n <= config.Get().Paths.StateBackups; n++ {
+		backup := backupPath(filename, n)
+		restored, backupErr := readQueueControllers(backup)
+		if backupErr != nil {
+			continue
+		}
+		return restored, &BackupRestoredError{Path: filename, Backup: backup, Cause: err}
+	}
+
+	return nil, err
+}
+
+func readQueueControllers(filename string) ([]*QueueController, error) {
+	jsonData, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	return queueControllers, nil
+	return decodeState(filename, jsonData)
 }
@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// OrderPolicy decides in which order a queue's waiting downloads start.
+type OrderPolicy string
+
+const (
+	// ORDER_FIFO starts downloads in queue order.
+	ORDER_FIFO OrderPolicy = "fifo"
+	// ORDER_PRIORITY starts downloads with a higher priority first, and
+	// those of equal priority in queue order.
+	ORDER_PRIORITY OrderPolicy = "priority"
+	// ORDER_SMALLEST_FIRST and ORDER_LARGEST_FIRST start downloads by size;
+	// those of unknown size go last.
+	ORDER_SMALLEST_FIRST OrderPolicy = "smallest-first"
+	ORDER_LARGEST_FIRST  OrderPolicy = "largest-first"
+	// ORDER_ROUND_ROBIN takes turns between the hosts downloads come from,
+	// so one host's many files do not hold up the others.
+	ORDER_ROUND_ROBIN OrderPolicy = "round-robin"
+)
+
+// ORDER_POLICIES lists the policies in the order the UI offers them.
+var ORDER_POLICIES = []OrderPolicy{ORDER_PRIORITY, ORDER_FIFO, ORDER_SMALLEST_FIRST, ORDER_LARGEST_FIRST, ORDER_ROUND_ROBIN}
+
+// ParseOrderPolicy returns the policy with the given name, ignoring case. An
+// empty name is ORDER_PRIORITY.
+func ParseOrderPolicy(name string) (OrderPolicy, error) {
+	if name == "" {
+		return ORDER_PRIORITY, nil
+	}
+	for _, policy := range ORDER_POLICIES {
+		if strings.EqualFold(name, string(policy)) {
+			return policy, nil
+		}
+	}
+	names := make([]string, len(ORDER_POLICIES))
+	for i, policy := range ORDER_POLICIES {
+		names[i] = string(policy)
+	}
+	return "", fmt.Errorf("unknown order %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// GetOrder returns the queue's order policy.
+func (qc *QueueController) GetOrder() OrderPolicy {
+	qc.mutex.Lock()
+	defer qc.mutex.Unlock()
+	return qc.policy()
+}
+
+// policy returns the queue's order policy, ORDER_PRIORITY when it has none.
+// The mutex must be held.
+func (qc *QueueController) policy() OrderPolicy {
+	if qc.Order == "" {
+		return ORDER_PRIORITY
+	}
+	return qc.Order
+}
+
+// rank returns how the scheduler weighs dc against other waiting downloads:
+// its priority, which only counts under ORDER_PRIORITY, and its place in
+// order. The mutex must be held.
+func (qc *QueueController) rank(order []*DownloadController, dc *DownloadController) (priority, position int) {
+	if qc.policy() == ORDER_PRIORITY {
+		dc.Mutex.Lock()
+		priority = dc.Priority
+		dc.Mutex.Unlock()
+	}
+	return priority, slices.Index(order, dc)
+}
+
+// ordered returns the queue's downloads in the order its policy starts them.
+// The mutex must be held.
+func (qc *QueueController) ordered() []*DownloadController {
+	downloads := slices.Clone(qc.DownloadControllers)
+	switch qc.policy() {
+	case ORDER_PRIORITY:
+		priorities := make(map[*DownloadController]int, len(downloads))
+		for _, dc := range downloads {
+			dc.Mutex.Lock()
+			priorities[dc] = dc.Priority
+			dc.Mutex.Unlock()
+		}
+		slices.SortStableFunc(downloads, func(a, b *DownloadController) int {
+			return cmp.Compare(priorities[b], priorities[a])
+		})
+	case ORDER_SMALLEST_FIRST, ORDER_LARGEST_FIRST:
+		sign := 1
+		if qc.policy() == ORDER_LARGEST_FIRST {
+			sign = -1
+		}
+		key := func(dc *DownloadController) int {
+			if dc.TotalSize <= 0 {
+				return math.MaxInt
+			}
+			return sign * dc.TotalSize
+		}
+		slices.SortStableFunc(downloads, func(a, b *DownloadController) int {
+			return cmp.Compare(key(a), key(b))
+		})
+	case ORDER_ROUND_ROBIN:
+		// The nth download of each host goes in the nth round
+		turns := make(map[string]int)
+		rounds := make(map[*DownloadController]int, len(downloads))
+		for _, dc := range downloads {
+			host := dc.Url
+			if u, err := url.Parse(dc.Url); err == nil {
+				host = u.Host
+			}
+			rounds[dc] = turns[host]
+			turns[host]++
+		}
+		slices.SortStableFunc(downloads, func(a, b *DownloadController) int {
+			return cmp.Compare(rounds[a], rounds[b])
+		})
+	}
+	return downloads
+}
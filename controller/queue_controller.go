@@ -5,15 +5,40 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/mjghr/tech-download-manager/client"
+	"github.com/mjghr/tech-download-manager/config"
 	"github.com/mjghr/tech-download-manager/ui/logs"
-	"github.com/mjghr/tech-download-manager/util"
 )
 
+// WINDOW_RECHECK is how often a running download looks up its queue's time
+// window again.
+const WINDOW_RECHECK = time.Minute
+
+// errStopped ends a transfer without canceling its download; the partial
+// files are kept for the download to continue later.
+var errStopped = errors.New("transfer stopped")
+
+// errWindowClosed stops a transfer whose queue's time window has closed.
+var errWindowClosed = fmt.Errorf("%w: the queue's time window closed", errStopped)
+
+// errDownloadMoved stops a transfer whose download moved to another queue.
+var errDownloadMoved = fmt.Errorf("%w: the download moved to another queue", errStopped)
+
+// errPaused stops the transfer of a download paused by hand.
+var errPaused = fmt.Errorf("%w: the download was paused", errStopped)
+
+// errRestarted stops a transfer whose download starts over.
+var errRestarted = fmt.Errorf("%w: the download starts over", errStopped)
+
+// errCanceled ends the transfer of a canceled download, whose partial files
+// are removed once it has returned.
+var errCanceled = errors.New("the download was canceled")
+
 // QueueController manages a download queue with features like pause, resume, and concurrent download limits
 type QueueController struct {
 	QueueID                 string                `json:"queueId"`
@@ -21,13 +46,17 @@ type QueueController struct {
 	ConcurrentDownloadLimit int                   `json:"concurrentDownloadLimit"`
 	StartTime               time.Time             `json:"startTime"`
 	EndTime                 time.Time             `json:"endTime"`
+	Schedule                *Schedule             `json:"schedule,omitempty"` // replaces StartTime/EndTime when set
+	Order                   OrderPolicy           `json:"order,omitempty"`
+	AutoResume              bool                  `json:"autoResume,omitempty"` // continue interrupted downloads on startup
 	DownloadControllers     []*DownloadController `json:"downloadControllers"`
 	TempPath                string                `json:"tempPath"`
 	SavePath                string                `json:"savePath"`
 	QueueName               string                `json:"name"`
 
-	mutex sync.Mutex     `json:"-"`
-	wg    sync.WaitGroup `json:"-"`
+	mutex   sync.Mutex     `json:"-"`
+	wg      sync.WaitGroup `json:"-"`
+	started bool           `json:"-"` // Start was called, so pending downloads are processed
 }
 
 func (qc *QueueController) UpdateQueueController(savePath string, concurrentDownloadLimit, speedLimit int, startTime, endTime time.Time) {
@@ -35,7 +64,7 @@ func (qc *QueueController) UpdateQueueController(savePath string, concurrentDown
 		qc.SavePath = savePath
 	}
 	if concurrentDownloadLimit != 0 {
-		qc.ConcurrentDownloadLimit = concurrentDownloadLimit
+		qc.ConcurrentDownloadLimit = validLimit(qc.QueueID, concurrentDownloadLimit)
 	}
 	if speedLimit != 0 {
 		qc.SpeedLimit = speedLimit
@@ -49,21 +78,76 @@ func (qc *QueueController) UpdateQueueController(savePath string, concurrentDown
 }
 
 func NewQueueController(name string) *QueueController {
+	cfg := config.Get()
+	queueID := fmt.Sprintf("queue-%d", time.Now().UnixNano())
 	return &QueueController{
-		QueueID:                 fmt.Sprintf("queue-%d", time.Now().UnixNano()),
+		QueueID:                 queueID,
 		QueueName:               name,
-		ConcurrentDownloadLimit: 1,
-		SpeedLimit:              100 * 1024,
-		TempPath:                util.GiveDefaultTempPath(),
-		SavePath:                util.GiveDefaultSavePath(),
+		ConcurrentDownloadLimit: validLimit(queueID, cfg.Queue.ConcurrentDownloadLimit),
+		SpeedLimit:              cfg.Queue.SpeedLimitKB * 1024,
+		TempPath:                cfg.Paths.TempDir,
+		SavePath:                cfg.Paths.SaveDir,
 		DownloadControllers:     make([]*DownloadController, 0),
-		StartTime: time.Now(),
-		EndTime: time.Now().Add(time.Hour*24),
+		StartTime:               time.Now(),
+		EndTime:                 time.Now().Add(cfg.Queue.Window),
+		Schedule:                defaultSchedule(),
+		Order:                   defaultOrder(),
+		AutoResume:              cfg.Queue.AutoResume,
+	}
+}
+
+// validLimit returns a queue's concurrent download limit, raised to 1 with a
+// warning when it is lower: a queue that may run no download never starts one.
+func validLimit(queueID string, limit int) int {
+	if limit < 1 {
+		logs.Log(fmt.Sprintf("Warning: raising concurrent download limit %d of queue %s to 1", limit, queueID))
+		return 1
+	}
+	return limit
+}
+
+// defaultOrder parses the configured order policy for new queues.
+func defaultOrder() OrderPolicy {
+	name := config.Get().Queue.Order
+	policy, err := ParseOrderPolicy(name)
+	if err != nil {
+		logs.Log(fmt.Sprintf("Warning: ignoring invalid queue order %q: %v", name, err))
+		return ORDER_PRIORITY
 	}
+	return policy
 }
 
-// Start begins processing the download queue
+// defaultSchedule parses the configured schedule for new queues, if any.
+func defaultSchedule() *Schedule {
+	spec := config.Get().Queue.Schedule
+	if spec == "" {
+		return nil
+	}
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		logs.Log(fmt.Sprintf("Warning: ignoring invalid queue schedule %q: %v", spec, err))
+		return nil
+	}
+	return schedule
+}
+
+// Start begins processing the download queue: its pending and paused
+// downloads. Failed and canceled ones need a retry first.
 func (qc *QueueController) Start() error {
+	return qc.start(func(dc *DownloadController) bool {
+		return dc.GetStatus().CanTransition(ONGOING)
+	})
+}
+
+// StartPending starts only downloads that have not been started yet, leaving
+// paused, failed and canceled ones alone.
+func (qc *QueueController) StartPending() error {
+	return qc.start(func(dc *DownloadController) bool {
+		return dc.GetStatus() == NOT_STARTED
+	})
+}
+
+func (qc *QueueController) start(shouldStart func(*DownloadController) bool) error {
 	logs.Log(fmt.Sprintf("Starting queue %s processing", qc.QueueID))
 
 	// Check if temp directory exists, create if not
@@ -76,17 +160,22 @@ func (qc *QueueController) Start() error {
 		return fmt.Errorf("failed to create save directory: %w", err)
 	}
 
-	// Start each download in the queue but don't wait for completion
-	for _, dc := range qc.DownloadControllers {
-		// Skip already completed downloads
-		if dc.GetStatus() == COMPLETED {
-			logs.Log(fmt.Sprintf("Download %s skipped: already completed", dc.ID))
+	// Start each download in the queue but don't wait for completion, in
+	// the order they should get slots
+	qc.mutex.Lock()
+	qc.started = true
+	downloads := qc.ordered()
+	qc.mutex.Unlock()
+	for _, dc := range downloads {
+		// Skip downloads that cannot run
+		if !shouldStart(dc) {
+			logs.Log(fmt.Sprintf("Download %s skipped: already %v", dc.ID, dc.GetStatus()))
 			continue
 		}
 
 		// Start each download in a background goroutine
+		qc.wg.Add(1)
 		go func(downloadCtrl *DownloadController) {
-			qc.wg.Add(1)
 			defer qc.wg.Done()
 			qc.processDownload(downloadCtrl)
 		}(dc)
@@ -109,41 +198,108 @@ func (qc *QueueController) processDownload(dc *DownloadController) {
 		return
 	}
 
-	// Wait for a slot to become available
-	qc.waitForDownloadSlot(dc)
-
-	// Check if start time is in the future
-	now := time.Now()
-	if !qc.StartTime.IsZero() && now.Before(qc.StartTime) {
-		waitDuration := qc.StartTime.Sub(now)
-		logs.Log(fmt.Sprintf("Waiting %v for scheduled start time for download %s", waitDuration, dc.ID))
-		time.Sleep(waitDuration)
+	// The download is left alone once it is canceled, started or paused by
+	// hand, or moved to another queue, while it waits
+	status, reason := dc.state()
+	unchanged := func() bool {
+		s, r := dc.state()
+		return s == status && r == reason && dc.queueID() == qc.QueueID
 	}
 
-	// Check if we're already past end time
-	if !qc.EndTime.IsZero() && now.After(qc.EndTime) {
-		logs.Log(fmt.Sprintf("Download %s skipped as current time is past the end time", dc.ID))
-		return
+	for {
+		// Wait for the queue's window to open
+		start, _, ok := qc.NextWindow(time.Now())
+		if !ok {
+			logs.Log(fmt.Sprintf("Download %s skipped as the queue has no upcoming time window", dc.ID))
+			return
+		}
+		if waitDuration := time.Until(start); waitDuration > 0 {
+			logs.Log(fmt.Sprintf("Waiting %v for scheduled start time for download %s", waitDuration, dc.ID))
+			time.Sleep(waitDuration)
+		}
+
+		if !unchanged() || !scheduler.acquire(qc, dc, func() bool { return !unchanged() }) {
+			return
+		}
+		// The window may have closed while it waited for a slot
+		if !qc.inWindow(time.Now()) {
+			scheduler.release(dc)
+			continue
+		}
+
+		paused := qc.transfer(dc, true)
+		scheduler.release(dc)
+		switch {
+		case paused && dc.PausedFor(PAUSE_WINDOW_CLOSED):
+			logs.Log(fmt.Sprintf("Download %s will resume in the queue's next time window", dc.ID))
+		case paused && dc.PausedFor(PAUSE_DISK_FULL):
+			logs.Log(fmt.Sprintf("Download %s will resume once there is room", dc.ID))
+			if !qc.waitForSpace(dc) {
+				return
+			}
+		default:
+			return
+		}
+		status, reason = dc.state()
 	}
+}
 
+// transfer downloads the chunks of dc and assembles the file. It reports
+// true when it was stopped first, because the download was paused or moved,
+// the disk filled up or, if windowed, the queue's time window closed; its
+// partial files are then kept.
+func (qc *QueueController) transfer(dc *DownloadController, windowed bool) bool {
 	// Set speed limit from queue if not set individually
 	if dc.SpeedLimit == 0 {
 		dc.SpeedLimit = qc.SpeedLimit
 	}
 
 	// Mark this download as in progress
-	dc.SetStatus(ONGOING)
+	if err := dc.SetStatus(ONGOING); err != nil {
+		logs.Log(fmt.Sprintf("Download %s cannot start: %v", dc.ID, err))
+		return false
+	}
 	logs.Log(fmt.Sprintf("Starting download %s in queue %s", dc.ID, qc.QueueID))
 
 	// Split file into chunks
 	chunks := dc.Chunks
 
-	ctx, cancel := context.WithCancel(context.Background())
-	dc.CancelFuncs = append(dc.CancelFuncs, cancel)
-	dc.ctx = ctx
+	err := dc.prepareWorkDir(qc.TempPath)
+	if err == nil {
+		err = dc.CheckDiskSpace(qc.TempPath, qc.SavePath)
+	}
+	if err != nil {
+		if isDiskFull(err) {
+			dc.pauseFor(PAUSE_DISK_FULL)
+			logs.Log(fmt.Sprintf("Download %s cannot start: %v", dc.ID, err))
+			return true
+		}
+		dc.fail(err)
+		return false
+	}
+
+	ctx, stop, finish := dc.beginTransfer()
+	defer finish()
+
+	// A Cancel before the transfer began had nothing to stop, and may have
+	// removed the work directory before prepareWorkDir made it again
+	if dc.GetStatus() == CANCELED {
+		if err := dc.CleanupTmpFiles(qc.TempPath); err != nil {
+			logs.Log(fmt.Sprintf("Warning: failed to clean up temp files for %s: %v", dc.ID, err))
+		}
+		return false
+	}
+
+	done := make(chan struct{})
+	if windowed {
+		go qc.stopAtWindowEnd(dc, stop, done)
+	}
+
+	// The first chunk to fail stops the others
+	chunkCtx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
 
 	// Download each chunk
-	var downloadErr error
 	var chunkWg sync.WaitGroup
 
 	for i, chunk := range chunks {
@@ -154,51 +310,60 @@ func (qc *QueueController) processDownload(dc *DownloadController) {
 				logs.Log(fmt.Sprintf("Chunk %d for %s skipped: download not ONGOING", idx, dc.ID))
 				return
 			}
-			err := dc.Download(idx, byteChunk, qc.TempPath, ctx)
+			err := dc.downloadWithRetries(idx, byteChunk, qc.TempPath, chunkCtx)
 			if err != nil {
 				logs.Log(fmt.Sprintf("Error downloading chunk %d for %s: %v", idx, dc.FileName, err))
-				if errors.Is(downloadErr, context.Canceled) {
-					dc.SetStatus(CANCELED)
-				} else {
-					dc.SetStatus(CANCELED)
+				// Whatever was written is kept for when there is room again
+				if isDiskFull(err) {
+					dc.pauseFor(PAUSE_DISK_FULL)
+					stop(errDiskFull)
 				}
-				downloadErr = err
+				abort(err)
 			}
 		}(i, chunk)
 	}
 
 	// Wait for all chunks to complete
 	chunkWg.Wait()
-
-	if downloadErr != nil {
-		if errors.Is(downloadErr, context.Canceled) {
-			logs.Log(fmt.Sprintf("Download %s canceled: %v", dc.ID, downloadErr))
-			dc.SetStatus(CANCELED)
-		} else {
-			logs.Log(fmt.Sprintf("Download %s failed: %v", dc.ID, downloadErr))
-			dc.SetStatus(FAILED)
-		}
-
-		// Clean up temporary files on failure or cancellation
-		if err := dc.CleanupTmpFiles(qc.TempPath); err != nil {
-			logs.Log(fmt.Sprintf("Warning: failed to clean up temp files for %s: %v", dc.ID, err))
-		}
-		return
+	close(done)
+
+	switch cause := context.Cause(ctx); {
+	case errors.Is(cause, errStopped):
+		// A stopped transfer keeps the download's status
+		return true
+	case cause != nil:
+		// Cancel removes the partial files once this returns
+		logs.Log(fmt.Sprintf("Download %s canceled: %v", dc.ID, cause))
+		return false
+	}
+	if err := context.Cause(chunkCtx); err != nil {
+		// The chunks fetched so far are kept for a retry
+		dc.fail(err)
+		return false
+	}
+
+	// Merge chunks and cleanup. All bytes are on disk, so this goes ahead
+	// even if the window closed meanwhile.
+	if err := dc.SetStatus(VERIFYING); err != nil {
+		return false
+	}
+	err = dc.MergeDownloads(qc.TempPath, qc.SavePath)
+	if err != nil && isDiskFull(err) {
+		// The chunk files are whole, so the merge is simply done again
+		logs.Log(fmt.Sprintf("No room to merge chunks for %s: %v", dc.ID, err))
+		dc.discardMerged(qc.SavePath)
+		dc.pauseFor(PAUSE_DISK_FULL)
+		return true
 	}
-
-	// Check if we're past the end time
-	if !qc.EndTime.IsZero() && time.Now().After(qc.EndTime) {
-		logs.Log(fmt.Sprintf("Download %s completed chunks but current time is past the end time, not merging", dc.ID))
-		dc.Status = FAILED
-		return
+	if err != nil {
+		dc.fail(err)
+		return false
 	}
 
-	// Merge chunks and cleanup
-	err := dc.MergeDownloads(qc.TempPath, qc.SavePath)
-	if err != nil {
-		logs.Log(fmt.Sprintf("Failed to merge chunks for %s: %v", dc.ID, err))
-		dc.Status = FAILED
-		return
+	if err := dc.VerifyChecksum(qc.SavePath); err != nil {
+		dc.discardCorrupt(qc.TempPath, qc.SavePath)
+		dc.fail(err)
+		return false
 	}
 
 	err = dc.CleanupTmpFiles(qc.TempPath)
@@ -207,41 +372,44 @@ func (qc *QueueController) processDownload(dc *DownloadController) {
 		// Still consider the download complete even if cleanup fails
 	}
 
-	dc.Status = COMPLETED
+	dc.SetStatus(COMPLETED)
 	logs.Log(fmt.Sprintf("Download %s completed successfully", dc.ID))
+	return false
 }
 
-// waitForDownloadSlot waits until a download slot is available
-func (qc *QueueController) waitForDownloadSlot(dc *DownloadController) {
+// stopAtWindowEnd pauses dc and stops its transfer when the queue's time
+// window closes, unless done is closed first. The window is looked up again
+// at least every WINDOW_RECHECK so edits to the schedule take effect.
+func (qc *QueueController) stopAtWindowEnd(dc *DownloadController, stop context.CancelCauseFunc, done <-chan struct{}) {
 	for {
-		qc.mutex.Lock()
-		activeCount := 0
-		for _, download := range qc.DownloadControllers {
-			if download.Status == ONGOING {
-				activeCount++
-			}
+		now := time.Now()
+		start, end, ok := qc.NextWindow(now)
+		if !ok || start.After(now) {
+			break
 		}
-		if activeCount < qc.ConcurrentDownloadLimit {
-			qc.mutex.Unlock()
-			return
+		wait := WINDOW_RECHECK
+		if !end.IsZero() && end.Sub(now) < wait {
+			wait = end.Sub(now)
 		}
-		qc.mutex.Unlock()
-
-		// Wait a bit before checking again
-		time.Sleep(500 * time.Millisecond)
-
-		// Check if we're already past end time
-		if !qc.EndTime.IsZero() && time.Now().After(qc.EndTime) {
-			logs.Log(fmt.Sprintf("Download %s skipped while waiting for slot as current time is past the end time", dc.ID))
+		timer := time.NewTimer(wait)
+		select {
+		case <-done:
+			timer.Stop()
 			return
+		case <-timer.C:
 		}
 	}
+
+	if dc.pauseFor(PAUSE_WINDOW_CLOSED) {
+		logs.Log(fmt.Sprintf("Time window of queue %s closed, pausing download %s", qc.QueueID, dc.ID))
+		stop(errWindowClosed)
+	}
 }
 
 // PauseAll pauses all active downloads in the queue
 func (qc *QueueController) PauseAll() {
 	logs.Log(fmt.Sprintf("Pausing all downloads in queue %s", qc.QueueID))
-	for _, dc := range qc.DownloadControllers {
+	for _, dc := range qc.Downloads() {
 		dc.Pause()
 	}
 }
@@ -249,14 +417,16 @@ func (qc *QueueController) PauseAll() {
 // ResumeAll resumes all paused downloads in the queue
 func (qc *QueueController) ResumeAll() {
 	logs.Log(fmt.Sprintf("Resuming all downloads in queue %s", qc.QueueID))
-	for _, dc := range qc.DownloadControllers {
-		dc.Resume()
+	for _, dc := range qc.Downloads() {
+		if err := qc.resume(dc); err != nil {
+			logs.Log(fmt.Sprintf("Failed to resume download %s: %v", dc.ID, err))
+		}
 	}
 }
 
 // PauseDownload pauses a specific download in the queue
 func (qc *QueueController) PauseDownload(downloadID string) error {
-	for _, dc := range qc.DownloadControllers {
+	for _, dc := range qc.Downloads() {
 		if dc.ID == downloadID {
 			dc.Pause()
 			return nil
@@ -267,24 +437,48 @@ func (qc *QueueController) PauseDownload(downloadID string) error {
 
 // ResumeDownload resumes a specific download in the queue
 func (qc *QueueController) ResumeDownload(downloadID string) error {
-	for _, dc := range qc.DownloadControllers {
+	for _, dc := range qc.Downloads() {
 		if dc.ID == downloadID {
-			dc.Resume()
-			return nil
+			return qc.resume(dc)
 		}
 	}
 	return fmt.Errorf("download %s not found in queue", downloadID)
 }
 
+// resume starts a paused download again straight away, as downloads resumed
+// by hand always run. Its chunk files are first checked against its recorded
+// progress, however long it was paused, and each unfinished chunk is then
+// requested from where its file ends.
+func (qc *QueueController) resume(dc *DownloadController) error {
+	if dc.GetStatus() != PAUSED {
+		return nil
+	}
+	if err := dc.ReconcileProgress(qc.TempPath); err != nil {
+		return err
+	}
+	return qc.StartDownload(dc.ID)
+}
+
+// Downloads returns a copy of the queue's downloads in their stored order,
+// which stays valid while downloads are added or removed.
+func (qc *QueueController) Downloads() []*DownloadController {
+	qc.mutex.Lock()
+	defer qc.mutex.Unlock()
+	return slices.Clone(qc.DownloadControllers)
+}
+
 // AddDownload adds a new download to the queue
 func (qc *QueueController) AddDownload(dc *DownloadController) {
 	qc.mutex.Lock()
 	defer qc.mutex.Unlock()
 
 	// Set the queue ID on the download controller to maintain the relationship
+	dc.Mutex.Lock()
 	dc.QueueID = qc.QueueID
+	dc.Mutex.Unlock()
 
 	qc.DownloadControllers = append(qc.DownloadControllers, dc)
+	scheduler.reorder(qc)
 	logs.Log(fmt.Sprintf("Added download %s to queue %s", dc.ID, qc.QueueID))
 }
 
@@ -300,6 +494,7 @@ func (qc *QueueController) RemoveDownload(downloadID string) error {
 				qc.DownloadControllers[:i],
 				qc.DownloadControllers[i+1:]...,
 			)
+			scheduler.reorder(qc)
 			logs.Log(fmt.Sprintf("Removed download %s from queue %s", downloadID, qc.QueueID))
 			return nil
 		}
@@ -308,12 +503,183 @@ func (qc *QueueController) RemoveDownload(downloadID string) error {
 	return fmt.Errorf("download %s not found in queue", downloadID)
 }
 
+// MoveDownload moves a download to index in the queue's order, clamped to the
+// queue's length. Waiting downloads of equal priority start in this order.
+func (qc *QueueController) MoveDownload(downloadID string, index int) error {
+	qc.mutex.Lock()
+	defer qc.mutex.Unlock()
+
+	from := slices.IndexFunc(qc.DownloadControllers, func(dc *DownloadController) bool { return dc.ID == downloadID })
+	if from < 0 {
+		return fmt.Errorf("download %s not found in queue", downloadID)
+	}
+	index = max(0, min(index, len(qc.DownloadControllers)-1))
+	dc := qc.DownloadControllers[from]
+	qc.DownloadControllers = slices.Insert(slices.Delete(qc.DownloadControllers, from, from+1), index, dc)
+	scheduler.reorder(qc)
+	logs.Log(fmt.Sprintf("Moved download %s to position %d in queue %s", downloadID, index+1, qc.QueueID))
+	return nil
+}
+
+// SetPriority sets a download's priority. Waiting downloads with a higher
+// priority start first, across all queues.
+func (qc *QueueController) SetPriority(downloadID string, priority int) error {
+	qc.mutex.Lock()
+	defer qc.mutex.Unlock()
+
+	for _, dc := range qc.DownloadControllers {
+		if dc.ID == downloadID {
+			dc.Mutex.Lock()
+			dc.Priority = priority
+			dc.Mutex.Unlock()
+			dc.checkpoint()
+			scheduler.reorder(qc)
+			logs.Log(fmt.Sprintf("Set priority of download %s to %d", downloadID, priority))
+			return nil
+		}
+	}
+	return fmt.Errorf("download %s not found in queue", downloadID)
+}
+
+// MoveDownloadTo moves a download to the end of target, keeping its progress
+// and moving its chunk files to target's temp directory. A download running
+// in this process is stopped and continues in target, within target's time
+// window and limits. One paused because this queue's window closed goes back
+// to pending; others keep their status. A pending download is processed by
+// target if target was started, and otherwise waits for it to be.
+func (qc *QueueController) MoveDownloadTo(downloadID string, target *QueueController) error {
+	if target == qc {
+		return nil
+	}
+	var dc *DownloadController
+	qc.mutex.Lock()
+	for _, d := range qc.DownloadControllers {
+		if d.ID == downloadID {
+			dc = d
+		}
+	}
+	qc.mutex.Unlock()
+	if dc == nil {
+		return fmt.Errorf("download %s not found in queue", downloadID)
+	}
+
+	running := dc.GetStatus() == ONGOING
+	stopped := dc.stopTransfer(errDownloadMoved)
+	if err := dc.MoveTmpFiles(qc.TempPath, target.TempPath); err != nil {
+		// The download stays, and carries on if it was stopped
+		if stopped && running {
+			qc.enqueue(dc)
+		}
+		return err
+	}
+
+	if err := qc.RemoveDownload(downloadID); err != nil {
+		return err
+	}
+	target.AddDownload(dc)
+	moved := "moved to queue " + target.QueueName
+	if stopped && running || dc.PausedFor(PAUSE_WINDOW_CLOSED) {
+		dc.setStatus(NOT_STARTED, moved)
+	}
+	// Requests still waiting in this queue give up
+	scheduler.wake()
+
+	target.mutex.Lock()
+	started := target.started
+	target.mutex.Unlock()
+	if stopped && running || started && dc.GetStatus() == NOT_STARTED {
+		target.enqueue(dc)
+	}
+	logs.Log(fmt.Sprintf("Moved download %s from queue %s to queue %s", downloadID, qc.QueueID, target.QueueID))
+	return nil
+}
+
+// RestartDownload discards a download's progress and partial files so it is
+// fetched again from the start. A download running in this process starts
+// over straight away, within the queue's window and limits; others go back
+// to pending.
+func (qc *QueueController) RestartDownload(downloadID string) error {
+	var dc *DownloadController
+	qc.mutex.Lock()
+	for _, d := range qc.DownloadControllers {
+		if d.ID == downloadID {
+			dc = d
+		}
+	}
+	qc.mutex.Unlock()
+	if dc == nil {
+		return fmt.Errorf("download %s not found in queue", downloadID)
+	}
+
+	running := dc.GetStatus() == ONGOING
+	stopped := dc.stopTransfer(errRestarted)
+	if err := dc.CleanupTmpFiles(qc.TempPath); err != nil {
+		return err
+	}
+
+	dc.Mutex.Lock()
+	dc.CompletedBytes = make([]int, len(dc.Chunks))
+	dc.CancelFuncs = nil
+	dc.ctx = nil
+	dc.Mutex.Unlock()
+	if err := dc.setStatus(NOT_STARTED, "restart"); err != nil {
+		return err
+	}
+
+	if stopped && running {
+		qc.enqueue(dc)
+	}
+	logs.Log(fmt.Sprintf("Restarted download %s in queue %s", downloadID, qc.QueueID))
+	return nil
+}
+
+// enqueue processes one download of the queue in the background, as Start
+// does for each of them.
+func (qc *QueueController) enqueue(dc *DownloadController) {
+	qc.wg.Add(1)
+	go func() {
+		defer qc.wg.Done()
+		qc.processDownload(dc)
+	}()
+}
+
+// SetOrder changes the order the queue starts its waiting downloads in.
+func (qc *QueueController) SetOrder(policy OrderPolicy) {
+	qc.mutex.Lock()
+	defer qc.mutex.Unlock()
+
+	qc.Order = policy
+	scheduler.reorder(qc)
+	logs.Log(fmt.Sprintf("Updated order of queue %s to %s", qc.QueueID, policy))
+}
+
+// SetName renames the queue.
+func (qc *QueueController) SetName(name string) {
+	qc.mutex.Lock()
+	defer qc.mutex.Unlock()
+
+	qc.QueueName = name
+	logs.Log(fmt.Sprintf("Renamed queue %s to %s", qc.QueueID, name))
+}
+
+// SetSpeedLimit sets the speed limit given to downloads added to the queue
+// without one of their own.
+func (qc *QueueController) SetSpeedLimit(limit int) {
+	qc.mutex.Lock()
+	defer qc.mutex.Unlock()
+
+	qc.SpeedLimit = limit
+	logs.Log(fmt.Sprintf("Updated speed limit to %d for queue %s", limit, qc.QueueID))
+}
+
 // SetConcurrentLimit updates the concurrent download limit
 func (qc *QueueController) SetConcurrentLimit(limit int) {
 	qc.mutex.Lock()
 	defer qc.mutex.Unlock()
 
+	limit = validLimit(qc.QueueID, limit)
 	qc.ConcurrentDownloadLimit = limit
+	scheduler.setLimit(qc.QueueID, limit)
 	logs.Log(fmt.Sprintf("Updated concurrent download limit to %d for queue %s", limit, qc.QueueID))
 }
 
@@ -328,12 +694,44 @@ func (qc *QueueController) SetTimeWindow(startTime, endTime time.Time) {
 		qc.QueueID, startTime.Format(time.RFC3339), endTime.Format(time.RFC3339)))
 }
 
+// SetSchedule replaces the queue's recurring schedule; nil returns it to its
+// one-off StartTime/EndTime window.
+func (qc *QueueController) SetSchedule(schedule *Schedule) {
+	qc.mutex.Lock()
+	defer qc.mutex.Unlock()
+
+	qc.Schedule = schedule
+	logs.Log(fmt.Sprintf("Updated schedule for queue %s: %v", qc.QueueID, schedule))
+}
+
+// NextWindow returns the time window open at t, or else the next one to
+// open, from the queue's Schedule or its StartTime/EndTime. A zero end means
+// the window does not close. It reports false when no window is left.
+func (qc *QueueController) NextWindow(t time.Time) (start, end time.Time, ok bool) {
+	qc.mutex.Lock()
+	defer qc.mutex.Unlock()
+
+	if qc.Schedule != nil {
+		return qc.Schedule.NextWindow(t)
+	}
+	if !qc.EndTime.IsZero() && !t.Before(qc.EndTime) {
+		return time.Time{}, time.Time{}, false
+	}
+	return qc.StartTime, qc.EndTime, true
+}
+
+// inWindow reports whether the queue may run downloads at t.
+func (qc *QueueController) inWindow(t time.Time) bool {
+	start, _, ok := qc.NextWindow(t)
+	return ok && !start.After(t)
+}
+
 func (qc *QueueController) SetPaths(tempPath, savePath string) error {
 	qc.mutex.Lock()
 	defer qc.mutex.Unlock()
 
 	for _, dc := range qc.DownloadControllers {
-		if dc.GetStatus() == ONGOING {
+		if status := dc.GetStatus(); status == ONGOING || status == VERIFYING {
 			return fmt.Errorf("cannot change paths while downloads are ongoing")
 		}
 	}
@@ -351,28 +749,36 @@ func (qc *QueueController) SetPaths(tempPath, savePath string) error {
 	return nil
 }
 
+// CancelDownload cancels a download of the queue. Cancel waits for the
+// transfer to return, so the queue is not kept locked meanwhile.
 func (qc *QueueController) CancelDownload(downloadID string) error {
+	var dc *DownloadController
 	qc.mutex.Lock()
-	defer qc.mutex.Unlock()
-
-	for _, dc := range qc.DownloadControllers {
-		if dc.ID == downloadID {
-			dc.Cancel(qc.TempPath)
-			return nil
+	for _, d := range qc.DownloadControllers {
+		if d.ID == downloadID {
+			dc = d
 		}
 	}
+	tempPath := qc.TempPath
+	qc.mutex.Unlock()
+	if dc == nil {
+		return fmt.Errorf("download %s not found in queue", downloadID)
+	}
 
-	return fmt.Errorf("download %s not found in queue", downloadID)
+	dc.Cancel(tempPath)
+	return nil
 }
 
 // CancelAll cancels all downloads in the queue
 func (qc *QueueController) CancelAll() error {
 	qc.mutex.Lock()
-	defer qc.mutex.Unlock()
+	downloads := slices.Clone(qc.DownloadControllers)
+	tempPath := qc.TempPath
+	qc.mutex.Unlock()
 
 	logs.Log(fmt.Sprintf("Cancelling all downloads in queue %s", qc.QueueID))
-	for _, dc := range qc.DownloadControllers {
-		dc.Cancel(qc.TempPath)
+	for _, dc := range downloads {
+		dc.Cancel(tempPath)
 	}
 
 	logs.Log(fmt.Sprintf("Successfully cancelled all downloads in queue %s", qc.QueueID))
@@ -381,17 +787,21 @@ func (qc *QueueController) CancelAll() error {
 
 // StartDownload immediately starts a specific download
 func (qc *QueueController) StartDownload(downloadID string) error {
+	qc.mutex.Lock()
+	tempPath, savePath, speedLimit := qc.TempPath, qc.SavePath, qc.SpeedLimit
+	qc.mutex.Unlock()
+
 	// Check if temp and save directories exist, create if not
-	if err := os.MkdirAll(qc.TempPath, 0755); err != nil {
+	if err := os.MkdirAll(tempPath, 0755); err != nil {
 		return fmt.Errorf("failed to create temp directory: %w", err)
 	}
-	if err := os.MkdirAll(qc.SavePath, 0755); err != nil {
+	if err := os.MkdirAll(savePath, 0755); err != nil {
 		return fmt.Errorf("failed to create save directory: %w", err)
 	}
 
 	// Look for the specified download
 	var targetDC *DownloadController
-	for _, dc := range qc.DownloadControllers {
+	for _, dc := range qc.Downloads() {
 		if dc.ID == downloadID {
 			targetDC = dc
 			break
@@ -402,12 +812,16 @@ func (qc *QueueController) StartDownload(downloadID string) error {
 		return fmt.Errorf("download %s not found in queue", downloadID)
 	}
 
-	// Set status to ONGOING
-	targetDC.SetStatus(ONGOING)
+	// Set status to ONGOING, taking a slot whatever the limits
+	if err := targetDC.SetStatus(ONGOING); err != nil {
+		return err
+	}
+	scheduler.take(targetDC)
 
+	targetDC.Mutex.Lock()
 	// Set speed limit from queue if not set individually
 	if targetDC.SpeedLimit == 0 {
-		targetDC.SpeedLimit = qc.SpeedLimit
+		targetDC.SpeedLimit = speedLimit
 	}
 
 	// Ensure the QueueID is set
@@ -415,7 +829,7 @@ func (qc *QueueController) StartDownload(downloadID string) error {
 
 	// Initialize HttpClient if it's nil
 	if targetDC.HttpClient == nil {
-		targetDC.HttpClient = &client.HTTPClient{}
+		targetDC.HttpClient = client.NewHTTPClient()
 	}
 
 	// If FileName is empty, extract it from the URL
@@ -431,20 +845,16 @@ func (qc *QueueController) StartDownload(downloadID string) error {
 		}
 		logs.Log(fmt.Sprintf("Set filename to %s for download %s", targetDC.FileName, targetDC.ID))
 	}
+	targetDC.Mutex.Unlock()
 
 	// Start the download in a goroutine
+	qc.wg.Add(1)
 	go func() {
-		qc.wg.Add(1)
 		defer qc.wg.Done()
-
-		logs.Log(fmt.Sprintf("Starting download %s in queue %s", targetDC.ID, qc.QueueID))
-
-		// Create context for this download
-		ctx, cancel := context.WithCancel(context.Background())
-		targetDC.CancelFuncs = append(targetDC.CancelFuncs, cancel)
-		targetDC.ctx = ctx
+		defer scheduler.release(targetDC)
 
 		// Split file into chunks if needed and not already done
+		targetDC.Mutex.Lock()
 		if targetDC.Chunks == nil || len(targetDC.Chunks) == 0 {
 			// Use default chunk size (same as in Download method)
 			chunkSize := targetDC.TotalSize
@@ -456,57 +866,11 @@ func (qc *QueueController) StartDownload(downloadID string) error {
 			targetDC.Chunks = targetDC.SplitIntoChunks(workers, chunkSize)
 			targetDC.CompletedBytes = make([]int, len(targetDC.Chunks))
 		}
+		targetDC.Mutex.Unlock()
 
-		// Download each chunk
-		var downloadErr error
-		var chunkWg sync.WaitGroup
-
-		for i, chunk := range targetDC.Chunks {
-			chunkWg.Add(1)
-			go func(idx int, byteChunk [2]int) {
-				defer chunkWg.Done()
-				if targetDC.GetStatus() != ONGOING { // Check status before proceeding
-					logs.Log(fmt.Sprintf("Chunk %d for %s skipped: download not ONGOING", idx, targetDC.ID))
-					return
-				}
-				err := targetDC.Download(idx, byteChunk, qc.TempPath, ctx)
-				if err != nil {
-					logs.Log(fmt.Sprintf("Error downloading chunk %d for %s: %v", idx, targetDC.FileName, err))
-					if errors.Is(err, context.Canceled) {
-						targetDC.SetStatus(CANCELED)
-					} else {
-						targetDC.SetStatus(FAILED)
-					}
-					downloadErr = err
-				}
-			}(i, chunk)
-		}
-
-		// Wait for all chunks to complete
-		chunkWg.Wait()
-
-		// Check for errors
-		if downloadErr != nil {
-			logs.Log(fmt.Sprintf("Download %s had errors: %v", targetDC.ID, downloadErr))
-			return
-		}
-
-		// If all chunks completed successfully and we're still in ONGOING state, merge them
-		if targetDC.GetStatus() == ONGOING {
-			if err := targetDC.MergeDownloads(qc.TempPath, qc.SavePath); err != nil {
-				logs.Log(fmt.Sprintf("Error merging download %s: %v", targetDC.ID, err))
-				targetDC.SetStatus(FAILED)
-				return
-			}
-
-			// Clean up temp files
-			if err := targetDC.CleanupTmpFiles(qc.TempPath); err != nil {
-				logs.Log(fmt.Sprintf("Warning: failed to clean up temp files for %s: %v", targetDC.ID, err))
-			}
-
-			// Mark as completed
-			targetDC.SetStatus(COMPLETED)
-			logs.Log(fmt.Sprintf("Download %s completed successfully", targetDC.ID))
+		// Started by hand, it runs outside the queue's window
+		if qc.transfer(targetDC, false) && targetDC.PausedFor(PAUSE_DISK_FULL) {
+			qc.continueWhenRoom(targetDC)
 		}
 	}()
 
@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/mjghr/tech-download-manager/client"
+	"github.com/mjghr/tech-download-manager/ui/logs"
+)
+
+// PAUSE_INTERRUPTED is the PauseReason of a download that was running when
+// the process running it exited. Queues with AutoResume continue it on the
+// next launch; otherwise it waits to be resumed by hand.
+const PAUSE_INTERRUPTED = "interrupted"
+
+// Rehydrate rebuilds the runtime state of a download loaded from the state
+// store: its HTTP client and per-chunk progress. Nothing runs it yet, so one
+// persisted as ONGOING or VERIFYING was cut off and is paused as
+// PAUSE_INTERRUPTED instead. It reports whether it was.
+func (d *DownloadController) Rehydrate() bool {
+	d.Mutex.Lock()
+	defer d.Mutex.Unlock()
+
+	d.CancelFuncs = nil
+	d.ctx = nil
+	d.stop, d.transferDone = nil, nil
+	if d.HttpClient == nil {
+		d.HttpClient = client.NewHTTPClient()
+	}
+	if len(d.CompletedBytes) != len(d.Chunks) {
+		d.CompletedBytes = make([]int, len(d.Chunks))
+	}
+
+	if d.Status != ONGOING && d.Status != VERIFYING {
+		return false
+	}
+	d.moveTo(PAUSED, PAUSE_INTERRUPTED)
+	logs.Log(fmt.Sprintf("Download %s was interrupted, it is paused", d.ID))
+	return true
+}
+
+// stranded reports whether the download was paused by a process that is gone:
+// interrupted, or waiting for a time window or disk space that process would
+// have resumed it at.
+func (d *DownloadController) stranded() bool {
+	d.Mutex.Lock()
+	defer d.Mutex.Unlock()
+	if d.Status != PAUSED || (d.ctx != nil && d.ctx.Err() == nil) {
+		return false
+	}
+	switch d.PauseReason {
+	case PAUSE_INTERRUPTED, PAUSE_WINDOW_CLOSED, PAUSE_DISK_FULL:
+		return true
+	}
+	return false
+}
+
+// SetAutoResume sets whether the downloads of the queue left interrupted by
+// the previous process continue when the manager starts.
+func (qc *QueueController) SetAutoResume(autoResume bool) {
+	qc.mutex.Lock()
+	defer qc.mutex.Unlock()
+
+	qc.AutoResume = autoResume
+	logs.Log(fmt.Sprintf("Updated auto-resume of queue %s to %v", qc.QueueID, autoResume))
+}
+
+// ResumeInterrupted continues the queue's downloads that a previous process
+// left paused, within the queue's window and limits, if the queue has
+// AutoResume. It returns how many it continued.
+func (qc *QueueController) ResumeInterrupted() int {
+	qc.mutex.Lock()
+	autoResume := qc.AutoResume
+	downloads := qc.ordered()
+	qc.mutex.Unlock()
+	if !autoResume {
+		return 0
+	}
+
+	resumed := 0
+	for _, dc := range downloads {
+		if !dc.stranded() {
+			continue
+		}
+		dc.setStatus(NOT_STARTED, "resumed on startup")
+		qc.enqueue(dc)
+		resumed++
+	}
+	if resumed > 0 {
+		logs.Log(fmt.Sprintf("Resumed %d interrupted downloads in queue %s", resumed, qc.QueueID))
+	}
+	return resumed
+}
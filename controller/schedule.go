@@ -0,0 +1,304 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mjghr/tech-download-manager/util"
+)
+
+// SCHEDULE_HORIZON is how far ahead NextWindow looks for a window.
+const SCHEDULE_HORIZON = 5 * 366 * 24 * time.Hour
+
+// Schedule is a recurring time window for a queue. Downloads run during
+// any of the daily Windows, and for Duration after each time Cron fires, on
+// the Weekdays given and outside the Blackout dates. Without Windows or Cron
+// the whole of every allowed day is open. Times are read in TimeZone, an
+// IANA name such as "Europe/Berlin", or in local time when it is empty.
+type Schedule struct {
+	// Windows are daily ranges such as "01:00-07:00"; one that ends before
+	// it starts runs past midnight.
+	Windows []string `json:"windows,omitempty"`
+	// Weekdays limits the days windows start on, e.g. "mon-fri" or "sat,sun".
+	Weekdays string `json:"weekdays,omitempty"`
+	Cron     string `json:"cron,omitempty"`
+	// Duration is how long a window opened by Cron lasts, e.g. "6h".
+	Duration string `json:"duration,omitempty"`
+	// Blackout dates, as 2006-01-02, are closed all day.
+	Blackout []string `json:"blackout,omitempty"`
+	TimeZone string   `json:"timeZone,omitempty"`
+}
+
+// compiledSchedule is a Schedule with its fields parsed.
+type compiledSchedule struct {
+	loc      *time.Location
+	windows  [][2]int // minutes since midnight
+	weekdays [7]bool
+	cron     *util.Cron
+	duration time.Duration
+	blackout map[string]bool
+}
+
+// ParseSchedule reads the compact form used on the command line and in the
+// NewQueue tab, clauses separated by semicolons:
+//
+//	01:00-07:00, 22:00-23:30; mon-fri; tz=Europe/Berlin; except=2026-12-25,2027-01-01
+//	cron=0 1 * * 1-5; for=6h
+//
+// "always", as String writes a schedule without clauses, opens every day.
+func ParseSchedule(spec string) (*Schedule, error) {
+	s := &Schedule{}
+	for _, clause := range strings.Split(spec, ";") {
+		clause = strings.TrimSpace(clause)
+		if clause == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(clause, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch {
+		case clause == "always":
+			// What String returns for a schedule without restrictions
+		case !ok && clause[0] >= '0' && clause[0] <= '9':
+			for _, window := range strings.Split(clause, ",") {
+				s.Windows = append(s.Windows, strings.TrimSpace(window))
+			}
+		case !ok:
+			s.Weekdays = clause
+		case key == "tz":
+			s.TimeZone = value
+		case key == "except":
+			for _, date := range strings.Split(value, ",") {
+				s.Blackout = append(s.Blackout, strings.TrimSpace(date))
+			}
+		case key == "cron":
+			s.Cron = value
+		case key == "for":
+			s.Duration = value
+		default:
+			return nil, fmt.Errorf("unknown schedule clause %q", clause)
+		}
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// String returns the schedule in the form ParseSchedule reads.
+func (s *Schedule) String() string {
+	var clauses []string
+	if len(s.Windows) > 0 {
+		clauses = append(clauses, strings.Join(s.Windows, ", "))
+	}
+	if s.Weekdays != "" {
+		clauses = append(clauses, s.Weekdays)
+	}
+	if s.Cron != "" {
+		clauses = append(clauses, "cron="+s.Cron, "for="+s.Duration)
+	}
+	if s.TimeZone != "" {
+		clauses = append(clauses, "tz="+s.TimeZone)
+	}
+	if len(s.Blackout) > 0 {
+		clauses = append(clauses, "except="+strings.Join(s.Blackout, ","))
+	}
+	if len(clauses) == 0 {
+		return "always"
+	}
+	return strings.Join(clauses, "; ")
+}
+
+// Validate reports the first problem with the schedule's fields.
+func (s *Schedule) Validate() error {
+	_, err := s.compile()
+	return err
+}
+
+func (s *Schedule) compile() (*compiledSchedule, error) {
+	c := &compiledSchedule{loc: time.Local, blackout: map[string]bool{}}
+	if s.TimeZone != "" {
+		loc, err := time.LoadLocation(s.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("unknown time zone %q", s.TimeZone)
+		}
+		c.loc = loc
+	}
+
+	for _, window := range s.Windows {
+		startText, endText, ok := strings.Cut(window, "-")
+		start, err1 := parseClock(startText)
+		end, err2 := parseClock(endText)
+		if !ok || err1 != nil || err2 != nil || start == end || start == 24*60 {
+			return nil, fmt.Errorf("window %q must look like 01:00-07:00", window)
+		}
+		c.windows = append(c.windows, [2]int{start, end})
+	}
+
+	if s.Weekdays == "" || s.Weekdays == "daily" {
+		c.weekdays = [7]bool{true, true, true, true, true, true, true}
+	} else {
+		for _, part := range strings.Split(s.Weekdays, ",") {
+			from, to, isRange := strings.Cut(strings.TrimSpace(part), "-")
+			first, last := weekdayIndex(from), weekdayIndex(to)
+			if !isRange {
+				last = first
+			}
+			if first < 0 || last < 0 {
+				return nil, fmt.Errorf("weekdays %q must look like mon-fri or sat,sun", s.Weekdays)
+			}
+			// Ranges may wrap, as in fri-mon
+			for d := first; ; d = (d + 1) % 7 {
+				c.weekdays[d] = true
+				if d == last {
+					break
+				}
+			}
+		}
+	}
+
+	if s.Cron != "" {
+		cron, err := util.ParseCron(s.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression: %w", err)
+		}
+		c.cron = cron
+		if c.duration, err = time.ParseDuration(s.Duration); err != nil || c.duration <= 0 {
+			return nil, fmt.Errorf("a cron schedule needs a positive duration such as 6h, got %q", s.Duration)
+		}
+	} else if s.Duration != "" {
+		return nil, errors.New("a duration is only used with a cron expression")
+	}
+
+	for _, date := range s.Blackout {
+		if _, err := time.ParseInLocation(time.DateOnly, date, c.loc); err != nil {
+			return nil, fmt.Errorf("blackout date %q must look like 2006-01-02", date)
+		}
+		c.blackout[date] = true
+	}
+	return c, nil
+}
+
+// Active reports whether t falls in one of the schedule's windows. An
+// invalid schedule is never active.
+func (s *Schedule) Active(t time.Time) bool {
+	start, _, ok := s.NextWindow(t)
+	return ok && !start.After(t)
+}
+
+// NextWindow returns the window open at t, or else the next one to open.
+// It reports false when there is none within SCHEDULE_HORIZON.
+func (s *Schedule) NextWindow(t time.Time) (start, end time.Time, ok bool) {
+	c, err := s.compile()
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	const step = 7 * 24 * time.Hour
+	for from := t; from.Sub(t) < SCHEDULE_HORIZON; from = from.Add(step) {
+		for _, window := range c.windowsBetween(from, from.Add(step)) {
+			if window[1].After(t) {
+				return window[0], window[1], true
+			}
+		}
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// windowsBetween returns the merged windows that start on the days from the
+// day before from up to the day after to, with blackout days cut out.
+func (c *compiledSchedule) windowsBetween(from, to time.Time) [][2]time.Time {
+	first := from.In(c.loc)
+	first = time.Date(first.Year(), first.Month(), first.Day()-1, 0, 0, 0, 0, c.loc)
+	last := to.In(c.loc)
+	last = time.Date(last.Year(), last.Month(), last.Day()+1, 0, 0, 0, 0, c.loc)
+
+	var windows [][2]time.Time
+	for day := first; !day.After(last); day = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, c.loc) {
+		if !c.weekdays[day.Weekday()] {
+			continue
+		}
+		y, m, d := day.Date()
+		for _, w := range c.windows {
+			endDay := d
+			if w[1] <= w[0] {
+				endDay++
+			}
+			windows = append(windows, [2]time.Time{
+				time.Date(y, m, d, w[0]/60, w[0]%60, 0, 0, c.loc),
+				time.Date(y, m, endDay, w[1]/60, w[1]%60, 0, 0, c.loc),
+			})
+		}
+		if c.cron != nil {
+			for _, fire := range c.cron.Times(day) {
+				windows = append(windows, [2]time.Time{fire, fire.Add(c.duration)})
+			}
+		}
+		if len(c.windows) == 0 && c.cron == nil {
+			windows = append(windows, [2]time.Time{day, time.Date(y, m, d+1, 0, 0, 0, 0, c.loc)})
+		}
+	}
+
+	sort.Slice(windows, func(i, j int) bool { return windows[i][0].Before(windows[j][0]) })
+	var merged [][2]time.Time
+	for _, w := range windows {
+		if n := len(merged); n > 0 && !w[0].After(merged[n-1][1]) {
+			if w[1].After(merged[n-1][1]) {
+				merged[n-1][1] = w[1]
+			}
+			continue
+		}
+		merged = append(merged, w)
+	}
+	return c.cutBlackout(merged)
+}
+
+// cutBlackout removes the blackout days from sorted windows.
+func (c *compiledSchedule) cutBlackout(windows [][2]time.Time) [][2]time.Time {
+	if len(c.blackout) == 0 {
+		return windows
+	}
+	var result [][2]time.Time
+	for _, w := range windows {
+		start := w[0]
+		for day := start.In(c.loc); day.Before(w[1]); {
+			y, m, d := day.Date()
+			midnight := time.Date(y, m, d, 0, 0, 0, 0, c.loc)
+			next := time.Date(y, m, d+1, 0, 0, 0, 0, c.loc)
+			if c.blackout[midnight.Format(time.DateOnly)] {
+				if start.Before(day) {
+					result = append(result, [2]time.Time{start, day})
+				}
+				start = next
+			}
+			day = next
+		}
+		if start.Before(w[1]) {
+			result = append(result, [2]time.Time{start, w[1]})
+		}
+	}
+	return result
+}
+
+// parseClock parses "HH:MM" into minutes since midnight. "24:00" is allowed
+// as the end of a window.
+func parseClock(s string) (int, error) {
+	if strings.TrimSpace(s) == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func weekdayIndex(name string) int {
+	for i, day := range util.WEEKDAY_NAMES {
+		if strings.EqualFold(strings.TrimSpace(name), day) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestScheduleNextWindow(t *testing.T) {
+	const layout = "2006-01-02 15:04"
+	tests := []struct {
+		spec       string
+		at         string
+		start, end string
+	}{
+		// Windows past midnight, from either side of it
+		{"22:00-02:00", "2026-06-01 23:00", "2026-06-01 22:00", "2026-06-02 02:00"},
+		{"22:00-02:00", "2026-06-02 01:00", "2026-06-01 22:00", "2026-06-02 02:00"},
+		{"22:00-02:00", "2026-06-02 03:00", "2026-06-02 22:00", "2026-06-03 02:00"},
+		{"01:00-03:00, 02:00-04:00", "2026-06-01 00:00", "2026-06-01 01:00", "2026-06-01 04:00"},
+		// Weekday ranges that wrap around the week
+		{"09:00-17:00; fri-mon", "2026-06-02 10:00", "2026-06-05 09:00", "2026-06-05 17:00"},
+		{"09:00-17:00; fri-mon", "2026-06-07 18:00", "2026-06-08 09:00", "2026-06-08 17:00"},
+		{"sat,sun", "2026-06-03 12:00", "2026-06-06 00:00", "2026-06-08 00:00"},
+		{"cron=0 1 * * 1-5; for=6h", "2026-06-06 12:00", "2026-06-08 01:00", "2026-06-08 07:00"},
+		// Blackout days split windows at midnight
+		{"sat,sun; except=2026-06-07", "2026-06-06 10:00", "2026-06-06 00:00", "2026-06-07 00:00"},
+		{"sat,sun; except=2026-06-07", "2026-06-07 05:00", "2026-06-13 00:00", "2026-06-15 00:00"},
+		{"22:00-02:00; except=2026-06-02", "2026-06-01 21:00", "2026-06-01 22:00", "2026-06-02 00:00"},
+		{"22:00-02:00; except=2026-06-02", "2026-06-02 00:30", "2026-06-03 00:00", "2026-06-03 02:00"},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.spec + "; tz=UTC")
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.spec, err)
+			continue
+		}
+		at, _ := time.ParseInLocation(layout, tt.at, time.UTC)
+		start, end, ok := s.NextWindow(at)
+		if !ok {
+			t.Errorf("%q at %s has no window", tt.spec, tt.at)
+			continue
+		}
+		got := [2]string{start.UTC().Format(layout), end.UTC().Format(layout)}
+		if want := [2]string{tt.start, tt.end}; got != want {
+			t.Errorf("%q at %s: window %v, want %v", tt.spec, tt.at, got, want)
+		}
+	}
+}
+
+func TestScheduleStringRoundTrip(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"always",
+		"01:00-07:00, 22:00-23:30; mon-fri; tz=Europe/Berlin; except=2026-12-25,2027-01-01",
+		"cron=0 1 * * 1-5; for=6h",
+		"sat,sun; tz=UTC",
+	} {
+		s, err := ParseSchedule(spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", spec, err)
+			continue
+		}
+		again, err := ParseSchedule(s.String())
+		if err != nil {
+			t.Errorf("ParseSchedule(%q) of %q: %v", s.String(), spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(again, s) {
+			t.Errorf("%q read back from %q as %+v, want %+v", s.String(), spec, again, s)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"01:00",
+		"01:00-01:00",
+		"25:00-02:00",
+		"mon-funday",
+		"cron=0 1 * * *",
+		"for=6h",
+		"except=2026-13-01",
+		"tz=Nowhere/City",
+		"speed=fast",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded, want an error", spec)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/mjghr/tech-download-manager/config"
+	"github.com/mjghr/tech-download-manager/ui/logs"
+)
+
+// Scheduler decides when queued downloads may start. Requests are admitted
+// by priority, then by their download's place in its queue's order policy,
+// then in the order they were made, while the download's queue is under its
+// concurrent download limit and the totals across all queues stay within
+// download.max_active_downloads and download.max_connections. A request
+// held back only by its own queue's limit does not hold up other queues.
+// Slots are given back as soon as a download finishes, pauses or is
+// canceled.
+//
+// The scheduler's mutex is taken after a QueueController's and before a
+// DownloadController's, so a DownloadController's mutex must not be held
+// when calling into it.
+type Scheduler struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	waiting []*slotRequest
+	active  map[*DownloadController]slot
+	seq     int
+}
+
+// slotRequest is a download waiting for a slot.
+type slotRequest struct {
+	dc          *DownloadController
+	queueID     string
+	limit       int // the queue's concurrent download limit
+	connections int
+	priority    int
+	position    int // the download's place in its queue's order
+	seq         int
+	admitted    bool
+}
+
+// slot is a download holding a slot.
+type slot struct {
+	queueID     string
+	connections int
+}
+
+// scheduler admits the downloads of every queue in this process.
+var scheduler = NewScheduler()
+
+func NewScheduler() *Scheduler {
+	s := &Scheduler{active: make(map[*DownloadController]slot)}
+	s.cond = sync.NewCond(&s.mutex)
+	return s
+}
+
+// acquire blocks until dc is given a slot, and returns true. It gives up and
+// returns false as soon as abandoned does, which is checked whenever a
+// download changes status.
+func (s *Scheduler) acquire(qc *QueueController, dc *DownloadController, abandoned func() bool) bool {
+	qc.mutex.Lock()
+	limit := qc.ConcurrentDownloadLimit
+	priority, position := qc.rank(qc.ordered(), dc)
+	qc.mutex.Unlock()
+	dc.Mutex.Lock()
+	connections := hostLimiter.connections(dc.Url, dc.pendingChunks())
+	dc.Mutex.Unlock()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.seq++
+	request := &slotRequest{dc: dc, queueID: qc.QueueID, limit: limit, connections: connections,
+		priority: priority, position: position, seq: s.seq}
+	s.waiting = append(s.waiting, request)
+	s.admit()
+	if !request.admitted {
+		logs.Log(fmt.Sprintf("Download %s waiting for a slot in queue %s", dc.ID, qc.QueueID))
+	}
+	for !request.admitted {
+		if abandoned() {
+			s.dropRequest(request)
+			s.admit()
+			return false
+		}
+		s.cond.Wait()
+	}
+	return true
+}
+
+// take gives dc a slot right away, whatever the limits, for downloads the
+// user starts or resumes by hand.
+func (s *Scheduler) take(dc *DownloadController) {
+	dc.Mutex.Lock()
+	queueID, connections := dc.QueueID, hostLimiter.connections(dc.Url, dc.pendingChunks())
+	dc.Mutex.Unlock()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.active[dc] = slot{queueID: queueID, connections: connections}
+}
+
+// release gives back the slot of dc, if it holds one, and lets the next
+// requests in.
+func (s *Scheduler) release(dc *DownloadController) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.active, dc)
+	s.admit()
+	// Waiters check whether they were abandoned
+	s.cond.Broadcast()
+}
+
+// setLimit applies a queue's new concurrent download limit to its waiting
+// requests.
+func (s *Scheduler) setLimit(queueID string, limit int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, request := range s.waiting {
+		if request.queueID == queueID {
+			request.limit = limit
+		}
+	}
+	s.admit()
+}
+
+// reorder updates the waiting requests of a queue's downloads after they
+// were moved, added or removed, their priorities changed or the queue's order
+// policy did. The queue's mutex must be held.
+func (s *Scheduler) reorder(qc *QueueController) {
+	order := qc.ordered()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, request := range s.waiting {
+		if request.queueID == qc.QueueID {
+			request.priority, request.position = qc.rank(order, request.dc)
+		}
+	}
+	s.admit()
+}
+
+// admit hands out free slots in request order. It must be called with the
+// mutex held.
+func (s *Scheduler) admit() {
+	cfg := config.Get().Download
+	connections := 0
+	perQueue := make(map[string]int)
+	for _, slot := range s.active {
+		connections += slot.connections
+		perQueue[slot.queueID]++
+	}
+
+	slices.SortStableFunc(s.waiting, func(a, b *slotRequest) int {
+		if a.priority != b.priority {
+			return cmp.Compare(b.priority, a.priority)
+		}
+		if a.position != b.position {
+			return cmp.Compare(a.position, b.position)
+		}
+		return cmp.Compare(a.seq, b.seq)
+	})
+
+	admitted := false
+	blocked := false
+	waiting := s.waiting[:0]
+	for _, request := range s.waiting {
+		if blocked || perQueue[request.queueID] >= request.limit {
+			waiting = append(waiting, request)
+			continue
+		}
+		// A download wanting more connections than allowed in total still
+		// runs once it has them all to itself
+		if (cfg.MaxActiveDownloads > 0 && len(s.active) >= cfg.MaxActiveDownloads) ||
+			(cfg.MaxConnections > 0 && connections > 0 && connections+request.connections > cfg.MaxConnections) {
+			// Later requests must not overtake this one
+			blocked = true
+			waiting = append(waiting, request)
+			continue
+		}
+
+		request.admitted = true
+		s.active[request.dc] = slot{queueID: request.queueID, connections: request.connections}
+		connections += request.connections
+		perQueue[request.queueID]++
+		admitted = true
+	}
+	clear(s.waiting[len(waiting):])
+	s.waiting = waiting
+
+	if admitted {
+		s.cond.Broadcast()
+	}
+}
+
+// dropRequest removes a request that gave up. It must be called with the
+// mutex held.
+func (s *Scheduler) dropRequest(request *slotRequest) {
+	for i, r := range s.waiting {
+		if r == request {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// wake lets waiting requests check whether they were abandoned.
+func (s *Scheduler) wake() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cond.Broadcast()
+}
@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// STATE_VERSION is the schema version written by this build. Bump it and
+// append to stateMigrations whenever the persisted shape of QueueController or
+// DownloadController changes.
+const STATE_VERSION = 2
+
+// stateFile is the versioned envelope persisted to the state file.
+type stateFile struct {
+	Version int                `json:"version"`
+	Queues  []*QueueController `json:"queues"`
+}
+
+// stateMigration upgrades a generic JSON document by exactly one version.
+type stateMigration func(doc any) (any, error)
+
+// stateMigrations[v] migrates a version v document to version v+1.
+var stateMigrations = []stateMigration{
+	migrateV0ToV1,
+	migrateV1ToV2,
+}
+
+// UnsupportedVersionError is returned when the state file was written by a
+// newer build than this one.
+type UnsupportedVersionError struct {
+	Path    string
+	Version int
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("state file %s has schema version %d, but this build only supports up to version %d; please upgrade",
+		e.Path, e.Version, STATE_VERSION)
+}
+
+// encodeState encodes queues for the state file. Each download is read under
+// its lock and keeps only the progress that is on disk, see encodeRecord.
+func encodeState(queues []*QueueController) ([]byte, error) {
+	state := make([]json.RawMessage, len(queues))
+	for i, queue := range queues {
+		var err error
+		if state[i], err = encodeQueue(queue, (*DownloadController).encodeRecord); err != nil {
+			return nil, err
+		}
+	}
+	return json.MarshalIndent(map[string]any{"version": STATE_VERSION, "queues": state}, "", "  ")
+}
+
+// decodeState parses a state document of any known version, running it
+// through the migration chain up to STATE_VERSION.
+func decodeState(filename string, data []byte) ([]*QueueController, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", filename, err)
+	}
+
+	version, err := stateVersion(doc)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", filename, err)
+	}
+	if version > STATE_VERSION {
+		return nil, &UnsupportedVersionError{Path: filename, Version: version}
+	}
+
+	for v := version; v < STATE_VERSION; v++ {
+		doc, err = stateMigrations[v](doc)
+		if err != nil {
+			return nil, fmt.Errorf("could not migrate %s from version %d to %d: %w", filename, v, v+1, err)
+		}
+	}
+
+	migrated, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var state stateFile
+	if err := json.Unmarshal(migrated, &state); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", filename, err)
+	}
+	for _, queue := range state.Queues {
+		queue.ConcurrentDownloadLimit = validLimit(queue.QueueID, queue.ConcurrentDownloadLimit)
+	}
+	return state.Queues, nil
+}
+
+// stateVersion reports the schema version of a generic document. Files from
+// before versioning are a bare array of queues and count as version 0.
+func stateVersion(doc any) (int, error) {
+	switch d := doc.(type) {
+	case []any:
+		return 0, nil
+	case map[string]any:
+		n, ok := d["version"].(json.Number)
+		if !ok {
+			return 0, fmt.Errorf("missing or invalid schema version")
+		}
+		version, err := n.Int64()
+		if err != nil || version < 0 {
+			return 0, fmt.Errorf("invalid schema version %s", n)
+		}
+		return int(version), nil
+	default:
+		return 0, fmt.Errorf("unexpected top-level JSON value")
+	}
+}
+
+// migrateV0ToV1 wraps the bare queue array into the versioned envelope and
+// drops the "httpClient" objects that used to be serialized per download.
+func migrateV0ToV1(doc any) (any, error) {
+	queues, ok := doc.([]any)
+	if !ok {
+		return nil, fmt.Errorf("expected an array of queues")
+	}
+	for _, q := range queues {
+		queue, ok := q.(map[string]any)
+		if !ok {
+			continue
+		}
+		downloads, _ := queue["downloadControllers"].([]any)
+		for _, d := range downloads {
+			if download, ok := d.(map[string]any); ok {
+				delete(download, "httpClient")
+			}
+		}
+	}
+	return map[string]any{"version": 1, "queues": queues}, nil
+}
+
+// migrateV1ToV2 brings in schedules, order policies, priorities, pause
+// reasons, status history and per-download work directories. Version 1
+// queues started their downloads in the order they were added, so they get
+// the fifo policy rather than the priority default. Their downloads start with
+// no priority and an empty history, a missing pause reason reads as a pause by
+// the user, and their flat chunk files are moved into work directories when
+// they next run, see checkWorkDir.
+func migrateV1ToV2(doc any) (any, error) {
+	envelope, ok := doc.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected a versioned state document")
+	}
+	queues, ok := envelope["queues"].([]any)
+	if !ok {
+		return nil, fmt.Errorf("expected an array of queues")
+	}
+	for _, q := range queues {
+		queue, ok := q.(map[string]any)
+		if !ok {
+			continue
+		}
+		if _, ok := queue["order"]; !ok {
+			queue["order"] = string(ORDER_FIFO)
+		}
+		downloads, _ := queue["downloadControllers"].([]any)
+		for _, d := range downloads {
+			download, ok := d.(map[string]any)
+			if !ok {
+				continue
+			}
+			if _, ok := download["priority"]; !ok {
+				download["priority"] = 0
+			}
+			if _, ok := download["history"]; !ok {
+				download["history"] = []any{}
+			}
+		}
+	}
+	envelope["version"] = 2
+	return envelope, nil
+}
+
+// snapshotQueue shadows the queue's downloads with their pre-encoded form.
+type snapshotQueue struct {
+	*QueueController
+	DownloadControllers []json.RawMessage `json:"downloadControllers"`
+}
+
+// encodeQueue encodes qc in the state file format, reading it under its
+// mutex so queue edits are not seen half done, and each of its downloads
+// with encode.
+func encodeQueue(qc *QueueController, encode func(*DownloadController) ([]byte, error)) (json.RawMessage, error) {
+	qc.mutex.Lock()
+	defer qc.mutex.Unlock()
+	snapshot := snapshotQueue{QueueController: qc, DownloadControllers: make([]json.RawMessage, 0, len(qc.DownloadControllers))}
+	for _, dc := range qc.DownloadControllers {
+		data, err := encode(dc)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.DownloadControllers = append(snapshot.DownloadControllers, data)
+	}
+	return json.Marshal(snapshot)
+}
+
+// EncodeSnapshot encodes queues in the state file format while they are in
+// use, locking each queue and download as it is read so running transfers
+// can be observed safely.
+func EncodeSnapshot(queues []*QueueController) ([]byte, error) {
+	snapshot := make([]json.RawMessage, len(queues))
+	for i, queue := range queues {
+		var err error
+		snapshot[i], err = encodeQueue(queue, func(dc *DownloadController) ([]byte, error) {
+			dc.Mutex.Lock()
+			defer dc.Mutex.Unlock()
+			return json.Marshal(dc)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(map[string]any{"version": STATE_VERSION, "queues": snapshot})
+}
+
+// DecodeSnapshot parses a document produced by EncodeSnapshot.
+func DecodeSnapshot(data []byte) ([]*QueueController, error) {
+	return decodeState("snapshot", data)
+}
@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const v0State = `[
+  {
+    "queueId": "queue-1",
+    "name": "Default Queue",
+    "concurrentDownloadLimit": 2,
+    "downloadControllers": [
+      {"id": "dc-1", "url": "http://host/a.bin", "status": 1, "httpClient": {"Timeout": 30}},
+      {"id": "dc-2", "url": "http://host/b.bin", "status": 3}
+    ]
+  }
+]`
+
+func TestMigrateV0ToV1(t *testing.T) {
+	var doc any
+	if err := json.Unmarshal([]byte(v0State), &doc); err != nil {
+		t.Fatal(err)
+	}
+	migrated, err := migrateV0ToV1(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope := migrated.(map[string]any)
+	if envelope["version"] != 1 {
+		t.Errorf("version = %v, want 1", envelope["version"])
+	}
+	queues := envelope["queues"].([]any)
+	if len(queues) != 1 {
+		t.Fatalf("got %d queues, want 1", len(queues))
+	}
+	for _, d := range queues[0].(map[string]any)["downloadControllers"].([]any) {
+		download := d.(map[string]any)
+		if _, ok := download["httpClient"]; ok {
+			t.Errorf("download %v still has its httpClient", download["id"])
+		}
+		if download["url"] == nil {
+			t.Errorf("download %v lost its url", download["id"])
+		}
+	}
+
+	for _, bad := range []any{map[string]any{}, "queues"} {
+		if _, err := migrateV0ToV1(bad); err == nil {
+			t.Errorf("migrateV0ToV1(%v) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestMigrateV1ToV2(t *testing.T) {
+	var doc any
+	data := `{"version": 1, "queues": [
+	  {"queueId": "queue-1", "downloadControllers": [{"id": "dc-1", "status": 1}]},
+	  {"queueId": "queue-2", "order": "largest-first", "downloadControllers": [{"id": "dc-2", "priority": 5}]}
+	]}`
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		t.Fatal(err)
+	}
+	migrated, err := migrateV1ToV2(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope := migrated.(map[string]any)
+	if envelope["version"] != 2 {
+		t.Errorf("version = %v, want 2", envelope["version"])
+	}
+	queues := envelope["queues"].([]any)
+	for i, want := range []struct {
+		order    string
+		priority any
+	}{{"fifo", 0}, {"largest-first", 5.0}} {
+		queue := queues[i].(map[string]any)
+		if queue["order"] != want.order {
+			t.Errorf("queue %d: order %v, want %s", i, queue["order"], want.order)
+		}
+		download := queue["downloadControllers"].([]any)[0].(map[string]any)
+		if download["priority"] != want.priority {
+			t.Errorf("queue %d: priority %v, want %v", i, download["priority"], want.priority)
+		}
+		if _, ok := download["history"].([]any); !ok {
+			t.Errorf("queue %d: history %v, want an empty list", i, download["history"])
+		}
+	}
+
+	for _, bad := range []any{[]any{}, map[string]any{"version": 1}} {
+		if _, err := migrateV1ToV2(bad); err == nil {
+			t.Errorf("migrateV1ToV2(%v) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestDecodeState(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		downloads int
+		limit     int
+		order     OrderPolicy
+	}{
+		{"v0", v0State, 2, 2, ORDER_FIFO},
+		{"v1", `{"version": 1, "queues": [{"queueId": "queue-1", "concurrentDownloadLimit": 3, "downloadControllers": [{"id": "dc-1"}]}]}`, 1, 3, ORDER_FIFO},
+		{"v1 with an invalid limit", `{"version": 1, "queues": [{"queueId": "queue-1", "concurrentDownloadLimit": -1, "downloadControllers": []}]}`, 0, 1, ORDER_FIFO},
+		{"v2", `{"version": 2, "queues": [{"queueId": "queue-1", "concurrentDownloadLimit": 2, "downloadControllers": [{"id": "dc-1"}]}]}`, 1, 2, ""},
+	}
+	for _, tt := range tests {
+		queues, err := decodeState(tt.name, []byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(queues) != 1 || queues[0].QueueID != "queue-1" {
+			t.Errorf("%s: got queues %v, want queue-1", tt.name, queues)
+			continue
+		}
+		if n := len(queues[0].DownloadControllers); n != tt.downloads {
+			t.Errorf("%s: got %d downloads, want %d", tt.name, n, tt.downloads)
+		}
+		if limit := queues[0].ConcurrentDownloadLimit; limit != tt.limit {
+			t.Errorf("%s: concurrent limit %d, want %d", tt.name, limit, tt.limit)
+		}
+		if order := queues[0].Order; order != tt.order {
+			t.Errorf("%s: order %q, want %q", tt.name, order, tt.order)
+		}
+	}
+}
+
+func TestDecodeStateErrors(t *testing.T) {
+	var tooNew *UnsupportedVersionError
+	if _, err := decodeState("new", []byte(`{"version": 99, "queues": []}`)); !errors.As(err, &tooNew) || tooNew.Version != 99 {
+		t.Errorf("version 99: error %v, want an *UnsupportedVersionError", err)
+	}
+	for _, data := range []string{`{"queues": []}`, `{"version": -1}`, `"queues"`, `[`} {
+		if _, err := decodeState("bad", []byte(data)); err == nil {
+			t.Errorf("decodeState(%s) succeeded, want an error", data)
+		}
+	}
+}
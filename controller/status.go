@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/mjghr/tech-download-manager/ui/logs"
+)
+
+// Status is the state of a download. The values are persisted, so new ones
+// are added at the end.
+type Status int
+
+const (
+	NOT_STARTED Status = iota // queued, waiting for a slot
+	PAUSED
+	FAILED
+	COMPLETED
+	ONGOING // downloading its chunks
+	CANCELED
+	PROBING   // its URL is asked for the file's size and name
+	VERIFYING // its chunks are merged and the file checked
+)
+
+func (s Status) String() string {
+	switch s {
+	case NOT_STARTED:
+		return "not_started"
+	case PAUSED:
+		return "paused"
+	case FAILED:
+		return "failed"
+	case COMPLETED:
+		return "completed"
+	case ONGOING:
+		return "ongoing"
+	case CANCELED:
+		return "canceled"
+	case PROBING:
+		return "probing"
+	case VERIFYING:
+		return "verifying"
+	default:
+		return fmt.Sprintf("status(%d)", int(s))
+	}
+}
+
+// transitions lists the statuses a download may go to from each status.
+// Going back to NOT_STARTED restarts or retries it; the files it keeps are
+// up to the caller.
+var transitions = map[Status][]Status{
+	PROBING:     {NOT_STARTED, FAILED},
+	NOT_STARTED: {ONGOING, PAUSED, COMPLETED, CANCELED},
+	ONGOING:     {VERIFYING, PAUSED, FAILED, CANCELED, NOT_STARTED},
+	PAUSED:      {ONGOING, NOT_STARTED, CANCELED},
+	VERIFYING:   {COMPLETED, FAILED, PAUSED},
+	COMPLETED:   {NOT_STARTED},
+	FAILED:      {NOT_STARTED},
+	CANCELED:    {NOT_STARTED},
+}
+
+// CanTransition reports whether a download may go from s to status to.
+func (s Status) CanTransition(to Status) bool {
+	return s == to || slices.Contains(transitions[s], to)
+}
+
+// MAX_HISTORY is how many transitions a download keeps; older ones are
+// dropped.
+const MAX_HISTORY = 50
+
+// Transition is a status change in a download's history.
+type Transition struct {
+	From   Status    `json:"from"`
+	To     Status    `json:"to"`
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// TransitionError reports a status change the state machine does not allow.
+type TransitionError struct {
+	ID       string
+	From, To Status
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("download %s cannot go from %s to %s", e.ID, e.From, e.To)
+}
+
+// moveTo changes the status to to and records it in the history, with reason
+// as the PauseReason of a pause. Staying in the same status changes nothing.
+// It must be called with the mutex held.
+func (d *DownloadController) moveTo(to Status, reason string) error {
+	from := d.Status
+	if from == to {
+		return nil
+	}
+	if !from.CanTransition(to) {
+		return &TransitionError{ID: d.ID, From: from, To: to}
+	}
+
+	d.Status = to
+	d.PauseReason = ""
+	if to == PAUSED {
+		d.PauseReason = reason
+	}
+	d.History = append(d.History, Transition{From: from, To: to, At: time.Now(), Reason: reason})
+	if len(d.History) > MAX_HISTORY {
+		d.History = slices.Clone(d.History[len(d.History)-MAX_HISTORY:])
+	}
+	return nil
+}
+
+// StatusHistory returns the download's status changes, oldest first.
+func (d *DownloadController) StatusHistory() []Transition {
+	d.Mutex.Lock()
+	defer d.Mutex.Unlock()
+	return slices.Clone(d.History)
+}
+
+// Probed ends the probe of a new download, which is then queued, or failed
+// with err when its URL cannot be downloaded.
+func (d *DownloadController) Probed(err error) {
+	d.Mutex.Lock()
+	defer d.Mutex.Unlock()
+	if err != nil {
+		d.moveTo(FAILED, err.Error())
+		return
+	}
+	d.moveTo(NOT_STARTED, "")
+}
+
+// fail marks the download failed because of err.
+func (d *DownloadController) fail(err error) {
+	logs.Log(fmt.Sprintf("Download %s failed: %v", d.ID, err))
+	d.setStatus(FAILED, err.Error())
+}
@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mjghr/tech-download-manager/config"
+)
+
+// StateStore persists queues and their downloads. Save replaces everything;
+// the per-record methods let backends that support it write incrementally.
+type StateStore interface {
+	// Load returns every persisted queue. Like LoadQueueControllers it may
+	// return usable queues together with a *BackupRestoredError.
+	Load() ([]*QueueController, error)
+	// Save replaces the whole persisted state with queues.
+	Save(queues []*QueueController) error
+	// SaveQueue upserts a queue's settings and download order, not its downloads.
+	SaveQueue(qc *QueueController) error
+	// SaveDownload upserts a single download record.
+	SaveDownload(dc *DownloadController) error
+	// DeleteQueue removes a queue and its downloads.
+	DeleteQueue(queueID string) error
+	// DeleteDownload removes a single download record.
+	DeleteDownload(downloadID string) error
+	Close() error
+}
+
+// OpenStateStore opens the backend selected in the config.
+func OpenStateStore(paths config.PathsConfig) (StateStore, error) {
+	switch paths.StateBackend {
+	case config.STATE_BACKEND_JSON:
+		return NewJSONStore(paths.StateFile), nil
+	case config.STATE_BACKEND_BOLT:
+		return OpenBoltStore(paths.StateDB)
+	default:
+		return nil, fmt.Errorf("unknown state backend %q", paths.StateBackend)
+	}
+}
+
+// CopyState replaces the contents of dst with the contents of src. It is used
+// to export and import state between backends.
+func CopyState(dst, src StateStore) error {
+	queues, err := src.Load()
+	if err != nil && queues == nil {
+		return fmt.Errorf("could not read source state: %w", err)
+	}
+	if err := dst.Save(queues); err != nil {
+		return fmt.Errorf("could not write destination state: %w", err)
+	}
+	return nil
+}
+
+// JSONStore keeps the whole state in one JSON file, rewriting it on every
+// change. It tracks the queues it has seen so incremental calls can rewrite
+// the file without the caller passing the full list.
+type JSONStore struct {
+	path   string
+	mutex  sync.Mutex
+	queues []*QueueController
+}
+
+func NewJSONStore(path string) *JSONStore {
+	return &JSONStore{path: path}
+}
+
+func (s *JSONStore) Load() ([]*QueueController, error) {
+	queues, err := LoadQueueControllers(s.path)
+	if queues != nil {
+		s.mutex.Lock()
+		s.queues = append([]*QueueController(nil), queues...)
+		s.mutex.Unlock()
+	}
+	return queues, err
+}
+
+func (s *JSONStore) Save(queues []*QueueController) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.queues = append([]*QueueController(nil), queues...)
+	return SaveQueueControllers(s.path, s.queues)
+}
+
+func (s *JSONStore) SaveQueue(qc *QueueController) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	found := false
+	for _, q := range s.queues {
+		if q.QueueID == qc.QueueID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		s.queues = append(s.queues, qc)
+	}
+	return SaveQueueControllers(s.path, s.queues)
+}
+
+// SaveDownload rewrites the whole file, as every JSONStore method does; dc is
+// written along with the rest of the queues it tracks.
+func (s *JSONStore) SaveDownload(dc *DownloadController) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return SaveQueueControllers(s.path, s.queues)
+}
+
+func (s *JSONStore) DeleteQueue(queueID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, q := range s.queues {
+		if q.QueueID == queueID {
+			s.queues = append(s.queues[:i:i], s.queues[i+1:]...)
+			break
+		}
+	}
+	return SaveQueueControllers(s.path, s.queues)
+}
+
+// DeleteDownload rewrites the whole file from the tracked queues, which no
+// longer hold the download.
+func (s *JSONStore) DeleteDownload(downloadID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return SaveQueueControllers(s.path, s.queues)
+}
+
+func (s *JSONStore) Close() error {
+	return nil
+}
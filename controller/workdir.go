@@ -0,0 +1,185 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/mjghr/tech-download-manager/config"
+	"github.com/mjghr/tech-download-manager/ui/logs"
+)
+
+// WORK_MANIFEST is the name of the manifest inside a download's work directory.
+const WORK_MANIFEST = "manifest.json"
+
+// WorkManifest describes the download a work directory belongs to. It tells
+// the directory apart from stray files in the temp directory, and its chunk
+// files apart from those of an earlier layout of the same download.
+type WorkManifest struct {
+	ID           string   `json:"id"`
+	URL          string   `json:"url"`
+	FileName     string   `json:"fileName"`
+	TotalSize    int      `json:"totalSize"`
+	ETag         string   `json:"etag,omitempty"`
+	LastModified string   `json:"lastModified,omitempty"`
+	Chunks       [][2]int `json:"chunks"`
+}
+
+// WorkDir returns the directory in tmpPath holding the download's chunk files
+// and manifest, named by its ID so downloads of the same file name never
+// share chunk files.
+func (d *DownloadController) WorkDir(tmpPath string) string {
+	return filepath.Join(tmpPath, d.ID)
+}
+
+// chunkFile returns the name of the file holding chunk idx.
+func (d *DownloadController) chunkFile(tmpPath string, idx int) string {
+	return filepath.Join(d.WorkDir(tmpPath), fmt.Sprintf("%s-%d.tmp", config.Get().Download.TmpFilePrefix, idx))
+}
+
+// legacyChunkFile returns where chunk idx was kept before downloads had work
+// directories.
+func (d *DownloadController) legacyChunkFile(tmpPath string, idx int) string {
+	return fmt.Sprintf("%s/%s-%s-%d.tmp", tmpPath, config.Get().Download.TmpFilePrefix, d.FileName, idx)
+}
+
+// prepareWorkDir readies the download's work directory in tmpPath for a
+// transfer, see checkWorkDir, and writes its manifest.
+func (d *DownloadController) prepareWorkDir(tmpPath string) error {
+	d.Mutex.Lock()
+	err := d.checkWorkDir(tmpPath)
+	d.Mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.WorkDir(tmpPath), 0755); err != nil {
+		return fmt.Errorf("failed to create work directory: %w", err)
+	}
+	return d.writeManifest(tmpPath)
+}
+
+// writeManifest replaces the manifest in the download's work directory.
+func (d *DownloadController) writeManifest(tmpPath string) error {
+	d.Mutex.Lock()
+	manifest := WorkManifest{
+		ID:           d.ID,
+		URL:          d.Url,
+		FileName:     d.FileName,
+		TotalSize:    d.TotalSize,
+		ETag:         d.ETag,
+		LastModified: d.LastModified,
+		Chunks:       slices.Clone(d.Chunks),
+	}
+	d.Mutex.Unlock()
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(d.WorkDir(tmpPath), WORK_MANIFEST)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write manifest %s: %w", path, err)
+	}
+	return nil
+}
+
+// ReadWorkManifest reads the manifest of the work directory dir.
+func ReadWorkManifest(dir string) (*WorkManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, WORK_MANIFEST))
+	if err != nil {
+		return nil, err
+	}
+	var manifest WorkManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest in %s: %w", dir, err)
+	}
+	return &manifest, nil
+}
+
+// checkWorkDir makes sure the chunk files in the download's work directory
+// belong to its current layout. Files left for another size or chunk layout
+// are removed, and chunk files of the flat layout older versions used are
+// moved in when there is no work directory yet. It must be called with the
+// mutex held.
+func (d *DownloadController) checkWorkDir(tmpPath string) error {
+	dir := d.WorkDir(tmpPath)
+	manifest, err := ReadWorkManifest(dir)
+	switch {
+	case err == nil:
+		if manifest.TotalSize == d.TotalSize && slices.Equal(manifest.Chunks, d.Chunks) {
+			return nil
+		}
+		logs.Log(fmt.Sprintf("Work directory %s is for another layout of %s, starting over", dir, d.ID))
+		return os.RemoveAll(dir)
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	for idx := range d.Chunks {
+		legacy := d.legacyChunkFile(tmpPath, idx)
+		if _, err := os.Stat(legacy); err != nil {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create work directory: %w", err)
+		}
+		if err := os.Rename(legacy, d.chunkFile(tmpPath, idx)); err != nil {
+			return fmt.Errorf("failed to move chunk file %s: %w", legacy, err)
+		}
+		logs.Log(fmt.Sprintf("Moved chunk file %s into work directory %s", legacy, dir))
+	}
+	return nil
+}
+
+// CollectWorkDirs removes the work directories in tmpPaths whose download is
+// not in known, and returns how many it removed. Only directories with a
+// manifest are touched, so other files in a temp directory are safe.
+func CollectWorkDirs(tmpPaths []string, known map[string]bool) (int, error) {
+	removed := 0
+	var errs []error
+	seen := make(map[string]bool)
+	for _, tmpPath := range tmpPaths {
+		tmpPath = filepath.Clean(tmpPath)
+		if seen[tmpPath] {
+			continue
+		}
+		seen[tmpPath] = true
+
+		entries, err := os.ReadDir(tmpPath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || known[entry.Name()] {
+				continue
+			}
+			dir := filepath.Join(tmpPath, entry.Name())
+			manifest, err := ReadWorkManifest(dir)
+			if err != nil || manifest.ID != entry.Name() {
+				continue
+			}
+			if err := os.RemoveAll(dir); err != nil {
+				errs = append(errs, fmt.Errorf("failed to remove orphaned work directory %s: %w", dir, err))
+				continue
+			}
+			logs.Log(fmt.Sprintf("Removed orphaned work directory %s of %s", dir, manifest.URL))
+			removed++
+		}
+	}
+	return removed, errors.Join(errs...)
+}
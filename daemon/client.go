@@ -0,0 +1,344 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mjghr/tech-download-manager/cli"
+	"github.com/mjghr/tech-download-manager/controller"
+	"github.com/mjghr/tech-download-manager/manager"
+	"github.com/mjghr/tech-download-manager/ui/logs"
+)
+
+// ErrDisconnected is returned for calls that were pending when the
+// connection to the daemon was lost.
+var ErrDisconnected = errors.New("disconnected from daemon")
+
+// Client is a connection to a running daemon. It implements manager.Service,
+// so the TUI can attach to the daemon instead of running downloads itself.
+type Client struct {
+	conn       net.Conn
+	writeMutex sync.Mutex
+
+	mutex   sync.Mutex
+	nextID  int
+	pending map[int]chan message
+	closed  bool
+	queues  []*controller.QueueController
+}
+
+var _ manager.Service = (*Client)(nil)
+
+// Dial connects to the daemon listening on socketPath.
+func Dial(socketPath string) (*Client, error) {
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{conn: conn, pending: make(map[int]chan message)}
+	go c.readLoop()
+	return c, nil
+}
+
+// Close detaches from the daemon. Downloads keep running there.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// readLoop routes every incoming message to the call it belongs to.
+func (c *Client) readLoop() {
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var msg message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			logs.Log(fmt.Sprintf("Ignoring invalid message from daemon: %v", err))
+			continue
+		}
+		c.mutex.Lock()
+		ch := c.pending[msg.ID]
+		c.mutex.Unlock()
+		if ch != nil {
+			ch <- msg
+		}
+	}
+
+	c.mutex.Lock()
+	c.closed = true
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.mutex.Unlock()
+}
+
+// call sends a request and waits for its response, passing any events
+// streamed before it to onEvent.
+func (c *Client) call(method string, params any, onEvent func(message)) (json.RawMessage, error) {
+	req := request{Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+		req.Params = data
+	}
+
+	ch := make(chan message, 16)
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil, ErrDisconnected
+	}
+	c.nextID++
+	req.ID = c.nextID
+	c.pending[req.ID] = ch
+	c.mutex.Unlock()
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	c.writeMutex.Lock()
+	_, err = c.conn.Write(append(data, '\n'))
+	c.writeMutex.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDisconnected, err)
+	}
+
+	for msg := range ch {
+		if msg.Event != "" {
+			if onEvent != nil {
+				onEvent(msg)
+			}
+			continue
+		}
+		c.mutex.Lock()
+		delete(c.pending, req.ID)
+		c.mutex.Unlock()
+		if msg.Error != "" {
+			return nil, errors.New(msg.Error)
+		}
+		return msg.Result, nil
+	}
+	return nil, ErrDisconnected
+}
+
+func (c *Client) setSnapshot(data json.RawMessage) error {
+	queues, err := controller.DecodeSnapshot(data)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.queues = queues
+	c.mutex.Unlock()
+	return nil
+}
+
+// refresh replaces the cached queues with a fresh snapshot.
+func (c *Client) refresh() error {
+	data, err := c.call(METHOD_SNAPSHOT, nil, nil)
+	if err != nil {
+		return err
+	}
+	return c.setSnapshot(data)
+}
+
+// LoadQueues fetches the daemon's queues and keeps them up to date in the
+// background for as long as the client is connected.
+func (c *Client) LoadQueues() error {
+	if err := c.refresh(); err != nil {
+		return err
+	}
+	go func() {
+		_, err := c.call(METHOD_WATCH, nil, func(msg message) {
+			if err := c.setSnapshot(msg.Result); err != nil {
+				logs.Log(fmt.Sprintf("Ignoring invalid snapshot from daemon: %v", err))
+			}
+		})
+		logs.Log(fmt.Sprintf("Stopped watching daemon: %v", err))
+	}()
+	return nil
+}
+
+// Queues returns the latest snapshot of the daemon's queues.
+func (c *Client) Queues() []*controller.QueueController {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.queues
+}
+
+func (c *Client) CreateQueue(queue *controller.QueueController) error {
+	if _, err := c.call(METHOD_CREATE_QUEUE, queue, nil); err != nil {
+		return err
+	}
+	return c.refresh()
+}
+
+func (c *Client) AddDownload(queueID string, u *url.URL, onDuplicate controller.DuplicateAction) (*controller.DownloadController, error) {
+	data, err := c.call(METHOD_ADD_DOWNLOAD, addDownloadParams{QueueID: queueID, URL: u.String(), OnDuplicate: onDuplicate}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var result addDownloadResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	if err := c.refresh(); err != nil {
+		return result.Download, err
+	}
+	if result.Duplicate != nil {
+		return result.Download, result.Duplicate
+	}
+	return result.Download, nil
+}
+
+func (c *Client) ImportDownloads(queueID string, entries []manager.BatchEntry) (int, error) {
+	data, err := c.call(METHOD_IMPORT, importParams{QueueID: queueID, Entries: entries}, nil)
+	if err != nil {
+		return 0, err
+	}
+	var result importResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return 0, err
+	}
+	if err := c.refresh(); err != nil {
+		return result.Added, err
+	}
+	if result.Failed != "" {
+		return result.Added, errors.New(result.Failed)
+	}
+	return result.Added, nil
+}
+
+func (c *Client) ImportQueueBundle(bundle *controller.QueueBundle, saveDir string) (*controller.QueueController, error) {
+	data, err := c.call(METHOD_IMPORT_QUEUE, importQueueParams{Bundle: bundle, SaveDir: saveDir}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var result importQueueResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	if err := c.refresh(); err != nil {
+		return result.Queue, err
+	}
+	if result.Failed != "" {
+		return result.Queue, errors.New(result.Failed)
+	}
+	return result.Queue, nil
+}
+
+func (c *Client) queueAction(method, queueID string) error {
+	if _, err := c.call(method, queueParams{QueueID: queueID}, nil); err != nil {
+		return err
+	}
+	return c.refresh()
+}
+
+func (c *Client) StartQueue(queueID string) error {
+	return c.queueAction(METHOD_START_QUEUE, queueID)
+}
+
+func (c *Client) PauseQueue(queueID string) error {
+	return c.queueAction(METHOD_PAUSE_QUEUE, queueID)
+}
+
+// ResumeInterrupted does nothing: the daemon resumed its interrupted
+// downloads when it started.
+func (c *Client) ResumeInterrupted() error {
+	return nil
+}
+
+func (c *Client) ResumeQueue(queueID string) error {
+	return c.queueAction(METHOD_RESUME_QUEUE, queueID)
+}
+
+func (c *Client) CancelQueue(queueID string) error {
+	return c.queueAction(METHOD_CANCEL_QUEUE, queueID)
+}
+
+func (c *Client) downloadAction(method string, params downloadParams) error {
+	if _, err := c.call(method, params, nil); err != nil {
+		return err
+	}
+	return c.refresh()
+}
+
+func (c *Client) MoveDownload(downloadID string, index int) error {
+	return c.downloadAction(METHOD_MOVE, downloadParams{DownloadID: downloadID, Index: index})
+}
+
+func (c *Client) SetDownloadPriority(downloadID string, priority int) error {
+	return c.downloadAction(METHOD_SET_PRIORITY, downloadParams{DownloadID: downloadID, Priority: priority})
+}
+
+func (c *Client) MoveDownloadToQueue(downloadID, queueID string) error {
+	return c.downloadAction(METHOD_MOVE_TO, downloadParams{DownloadID: downloadID, QueueID: queueID})
+}
+
+// SaveQueues asks the daemon to persist its state.
+func (c *Client) SaveQueues() error {
+	_, err := c.call(METHOD_SAVE, nil, nil)
+	return err
+}
+
+// Shutdown stops the daemon after it has saved its state.
+func (c *Client) Shutdown() error {
+	_, err := c.call(METHOD_SHUTDOWN, nil, nil)
+	return err
+}
+
+// Exec runs a CLI command in the daemon, copying its output to stdout and
+// stderr, and returns its exit code. When ctx ends first the client detaches
+// and the command's downloads keep running in the daemon.
+func (c *Client) Exec(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	dir, _ := os.Getwd()
+
+	type outcome struct {
+		data json.RawMessage
+		err  error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		data, err := c.call(METHOD_EXEC, execParams{Args: args, Dir: dir}, func(msg message) {
+			var text string
+			if json.Unmarshal(msg.Result, &text) != nil {
+				return
+			}
+			if msg.Event == EVENT_STDERR {
+				io.WriteString(stderr, text)
+			} else {
+				io.WriteString(stdout, text)
+			}
+		})
+		done <- outcome{data, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		c.Close()
+		fmt.Fprintln(stderr, "Detached, downloads continue in the daemon")
+		return cli.EXIT_INTERRUPTED
+	case out := <-done:
+		if out.err != nil {
+			fmt.Fprintf(stderr, "error: %v\n", out.err)
+			return cli.EXIT_FAILURE
+		}
+		var result execResult
+		if err := json.Unmarshal(out.data, &result); err != nil {
+			fmt.Fprintf(stderr, "error: invalid response from daemon: %v\n", err)
+			return cli.EXIT_FAILURE
+		}
+		return result.Code
+	}
+}
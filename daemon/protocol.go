@@ -0,0 +1,117 @@
+// Package daemon runs the download manager in the background and exposes it
+// to the TUI and CLI over a Unix domain socket.
+//
+// The protocol is newline-delimited JSON. A client sends requests carrying an
+// ID of its choosing; the server answers each with exactly one response with
+// the same ID. Long-running methods (watch, exec) first stream any number of
+// events with that ID. Requests on one connection are served concurrently.
+package daemon
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/mjghr/tech-download-manager/controller"
+	"github.com/mjghr/tech-download-manager/manager"
+)
+
+// Methods understood by the server.
+const (
+	METHOD_SNAPSHOT     = "snapshot"
+	METHOD_WATCH        = "watch"
+	METHOD_CREATE_QUEUE = "createQueue"
+	METHOD_ADD_DOWNLOAD = "addDownload"
+	METHOD_IMPORT       = "import"
+	METHOD_IMPORT_QUEUE = "importQueue"
+	METHOD_START_QUEUE  = "startQueue"
+	METHOD_PAUSE_QUEUE  = "pauseQueue"
+	METHOD_RESUME_QUEUE = "resumeQueue"
+	METHOD_CANCEL_QUEUE = "cancelQueue"
+	METHOD_MOVE         = "moveDownload"
+	METHOD_SET_PRIORITY = "setPriority"
+	METHOD_MOVE_TO      = "moveToQueue"
+	METHOD_SAVE         = "save"
+	METHOD_EXEC         = "exec"
+	METHOD_SHUTDOWN     = "shutdown"
+)
+
+// Events streamed before the final response.
+const (
+	EVENT_SNAPSHOT = "snapshot"
+	EVENT_STDOUT   = "stdout"
+	EVENT_STDERR   = "stderr"
+)
+
+// WATCH_INTERVAL is how often watchers are sent a fresh snapshot.
+const WATCH_INTERVAL = 500 * time.Millisecond
+
+type request struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// message is either an event or the final response to a request.
+type message struct {
+	ID     int             `json:"id"`
+	Event  string          `json:"event,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+type queueParams struct {
+	QueueID string `json:"queueId"`
+}
+
+type addDownloadParams struct {
+	QueueID     string                     `json:"queueId"`
+	URL         string                     `json:"url"`
+	OnDuplicate controller.DuplicateAction `json:"onDuplicate,omitempty"`
+}
+
+// addDownloadResult carries the download that was added or reused, or the
+// duplicate that kept it from being added.
+type addDownloadResult struct {
+	Download  *controller.DownloadController `json:"download,omitempty"`
+	Duplicate *controller.DuplicateError     `json:"duplicate,omitempty"`
+}
+
+// downloadParams names a download and, depending on the method, its new
+// index, priority or queue.
+type downloadParams struct {
+	DownloadID string `json:"downloadId"`
+	Index      int    `json:"index,omitempty"`
+	Priority   int    `json:"priority,omitempty"`
+	QueueID    string `json:"queueId,omitempty"`
+}
+
+type importParams struct {
+	QueueID string               `json:"queueId"`
+	Entries []manager.BatchEntry `json:"entries"`
+}
+
+// importResult carries the entries that could not be added as text, next to
+// the count of those that were.
+type importResult struct {
+	Added  int    `json:"added"`
+	Failed string `json:"failed,omitempty"`
+}
+
+type importQueueParams struct {
+	Bundle  *controller.QueueBundle `json:"bundle"`
+	SaveDir string                  `json:"saveDir,omitempty"`
+}
+
+type importQueueResult struct {
+	Queue  *controller.QueueController `json:"queue"`
+	Failed string                      `json:"failed,omitempty"`
+}
+
+type execParams struct {
+	Args []string `json:"args"`
+	Dir  string   `json:"dir"`
+}
+
+type execResult struct {
+	Code int `json:"code"`
+}
@@ -0,0 +1,460 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mjghr/tech-download-manager/cli"
+	"github.com/mjghr/tech-download-manager/controller"
+	"github.com/mjghr/tech-download-manager/manager"
+	"github.com/mjghr/tech-download-manager/ui/logs"
+)
+
+// ErrAlreadyRunning is returned by Listen when another daemon owns the socket.
+var ErrAlreadyRunning = errors.New("a daemon is already running")
+
+// Server owns a DownloadManager and serves it to clients.
+type Server struct {
+	dm       *manager.DownloadManager
+	path     string
+	listener net.Listener
+
+	// mutex serializes every use of dm by clients.
+	mutex sync.Mutex
+
+	connMutex sync.Mutex
+	conns     map[net.Conn]struct{}
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+func NewServer(dm *manager.DownloadManager, socketPath string) *Server {
+	return &Server{
+		dm:    dm,
+		path:  socketPath,
+		conns: make(map[net.Conn]struct{}),
+		stop:  make(chan struct{}),
+	}
+}
+
+// Serve accepts clients until ctx ends or a client asks the daemon to shut
+// down, then saves the state. Running downloads are left to the caller, which
+// should close the manager to checkpoint them.
+func (s *Server) Serve(ctx context.Context) error {
+	if s.listener == nil {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+	}
+	listener := s.listener
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.track(conn, true)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer s.track(conn, false)
+				s.handle(ctx, conn)
+			}()
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+	case <-s.stop:
+	}
+
+	// Stop accepting, then hang up on every client
+	listener.Close()
+	os.Remove(s.path)
+	cancel()
+	s.connMutex.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.connMutex.Unlock()
+	wg.Wait()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.dm.SaveQueues(); err != nil {
+		return fmt.Errorf("could not save state: %w", err)
+	}
+	logs.Log("Daemon stopped")
+	return nil
+}
+
+// Locker returns the lock guarding the manager, for other front ends the
+// daemon serves it to.
+func (s *Server) Locker() sync.Locker {
+	return &s.mutex
+}
+
+// Shutdown makes Serve return.
+func (s *Server) Shutdown() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// Listen claims the socket, replacing a stale one left by a daemon that did
+// not exit cleanly. Call it before loading any state: a second daemon must not
+// touch the partial files of the one already running.
+func (s *Server) Listen() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("could not create socket directory: %w", err)
+	}
+	if conn, err := net.DialTimeout("unix", s.path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%w on %s", ErrAlreadyRunning, s.path)
+	}
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not remove stale socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", s.path)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %w", s.path, err)
+	}
+	// Anyone who can connect controls the daemon
+	if err := os.Chmod(s.path, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("could not restrict socket permissions: %w", err)
+	}
+	s.listener = listener
+	logs.Log(fmt.Sprintf("Daemon listening on %s", s.path))
+	return nil
+}
+
+// Close releases the socket of a server that will not Serve.
+func (s *Server) Close() error {
+	if s.listener == nil {
+		return nil
+	}
+	err := s.listener.Close()
+	os.Remove(s.path)
+	return err
+}
+
+func (s *Server) track(conn net.Conn, add bool) {
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+	if add {
+		s.conns[conn] = struct{}{}
+	} else {
+		delete(s.conns, conn)
+	}
+}
+
+// conn is one client connection. Responses and events from concurrently
+// served requests are interleaved line by line.
+type conn struct {
+	net.Conn
+	writeMutex sync.Mutex
+}
+
+func (c *conn) send(msg message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	_, err = c.Write(append(data, '\n'))
+	return err
+}
+
+// reply sends the final response to a request.
+func (c *conn) reply(id int, result any, err error) {
+	msg := message{ID: id}
+	if err != nil {
+		msg.Error = err.Error()
+	} else if result != nil {
+		data, marshalErr := json.Marshal(result)
+		if marshalErr != nil {
+			msg.Error = marshalErr.Error()
+		}
+		msg.Result = data
+	}
+	c.send(msg)
+}
+
+func (s *Server) handle(ctx context.Context, netConn net.Conn) {
+	defer netConn.Close()
+	c := &conn{Conn: netConn}
+
+	// Requests end with the connection
+	ctx, cancel := context.WithCancel(ctx)
+	var requests sync.WaitGroup
+	defer requests.Wait()
+	defer cancel()
+
+	scanner := bufio.NewScanner(netConn)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var req request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			c.reply(0, nil, fmt.Errorf("invalid request: %w", err))
+			continue
+		}
+		requests.Add(1)
+		go func() {
+			defer requests.Done()
+			result, err := s.call(ctx, c, req)
+			c.reply(req.ID, result, err)
+		}()
+	}
+}
+
+func (s *Server) call(ctx context.Context, c *conn, req request) (any, error) {
+	switch req.Method {
+	case METHOD_SNAPSHOT:
+		return s.snapshot()
+	case METHOD_WATCH:
+		return nil, s.watch(ctx, c, req.ID)
+	case METHOD_CREATE_QUEUE:
+		var queue controller.QueueController
+		if err := json.Unmarshal(req.Params, &queue); err != nil {
+			return nil, fmt.Errorf("invalid queue: %w", err)
+		}
+		return nil, s.createQueue(&queue)
+	case METHOD_ADD_DOWNLOAD:
+		var params addDownloadParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, fmt.Errorf("invalid parameters: %w", err)
+		}
+		return s.addDownload(params)
+	case METHOD_IMPORT:
+		var params importParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, fmt.Errorf("invalid parameters: %w", err)
+		}
+		return s.importDownloads(params)
+	case METHOD_IMPORT_QUEUE:
+		var params importQueueParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, fmt.Errorf("invalid parameters: %w", err)
+		}
+		if params.Bundle == nil {
+			return nil, fmt.Errorf("invalid parameters: missing bundle")
+		}
+		return s.importQueue(params)
+	case METHOD_START_QUEUE, METHOD_PAUSE_QUEUE, METHOD_RESUME_QUEUE, METHOD_CANCEL_QUEUE:
+		var params queueParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, fmt.Errorf("invalid parameters: %w", err)
+		}
+		return nil, s.queueAction(req.Method, params.QueueID)
+	case METHOD_MOVE, METHOD_SET_PRIORITY, METHOD_MOVE_TO:
+		var params downloadParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, fmt.Errorf("invalid parameters: %w", err)
+		}
+		return nil, s.downloadAction(req.Method, params)
+	case METHOD_SAVE:
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return nil, s.dm.SaveQueues()
+	case METHOD_EXEC:
+		var params execParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, fmt.Errorf("invalid parameters: %w", err)
+		}
+		return s.exec(ctx, c, req.ID, params), nil
+	case METHOD_SHUTDOWN:
+		s.Shutdown()
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown method %q", req.Method)
+	}
+}
+
+func (s *Server) snapshot() (json.RawMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return controller.EncodeSnapshot(s.dm.QueueList)
+}
+
+// watch streams a snapshot every WATCH_INTERVAL until the client goes away.
+func (s *Server) watch(ctx context.Context, c *conn, id int) error {
+	ticker := time.NewTicker(WATCH_INTERVAL)
+	defer ticker.Stop()
+	for {
+		snapshot, err := s.snapshot()
+		if err != nil {
+			return err
+		}
+		if err := c.send(message{ID: id, Event: EVENT_SNAPSHOT, Result: snapshot}); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) createQueue(queue *controller.QueueController) error {
+	if queue.QueueID == "" || queue.QueueName == "" {
+		return errors.New("queue needs an ID and a name")
+	}
+	if queue.DownloadControllers == nil {
+		queue.DownloadControllers = make([]*controller.DownloadController, 0)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, err := s.dm.FindQueue(queue.QueueID); err == nil {
+		return fmt.Errorf("queue %s already exists", queue.QueueID)
+	}
+	return s.dm.CreateQueue(queue)
+}
+
+// importDownloads probes a URL list outside the lock and adds what it can.
+func (s *Server) importDownloads(params importParams) (json.RawMessage, error) {
+	dcs, probeErr := s.dm.ProbeBatch(params.Entries)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	queue, err := s.dm.FindQueue(params.QueueID)
+	if err != nil {
+		return nil, err
+	}
+	added, skipped, err := s.dm.AddDownloads(queue, dcs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := importResult{Added: len(added)}
+	if err := errors.Join(probeErr, skipped); err != nil {
+		result.Failed = err.Error()
+	}
+	return json.Marshal(result)
+}
+
+func (s *Server) importQueue(params importQueueParams) (json.RawMessage, error) {
+	queue, dcs, probeErr := s.dm.ProbeBundle(params.Bundle, params.SaveDir)
+	if queue == nil {
+		return nil, probeErr
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, skipped, err := s.dm.AddImportedQueue(queue, dcs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := importQueueResult{Queue: queue}
+	if err := errors.Join(probeErr, skipped); err != nil {
+		result.Failed = err.Error()
+	}
+	return json.Marshal(result)
+}
+
+func (s *Server) addDownload(params addDownloadParams) (json.RawMessage, error) {
+	u, err := url.Parse(params.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	if params.OnDuplicate == "" {
+		params.OnDuplicate = controller.DefaultDuplicateAction()
+	}
+
+	// Probe the URL without holding up other clients
+	dc := s.dm.NewDownloadController(u)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	queue, err := s.dm.FindQueue(params.QueueID)
+	if err != nil {
+		return nil, err
+	}
+	var result addDownloadResult
+	result.Download, err = s.dm.AddProbed(queue, dc, params.OnDuplicate)
+	if !errors.As(err, &result.Duplicate) && err != nil {
+		return nil, err
+	}
+
+	// Encode under the download's lock; it may already be picked up
+	if result.Download != nil {
+		result.Download.Mutex.Lock()
+		defer result.Download.Mutex.Unlock()
+	}
+	return json.Marshal(result)
+}
+
+func (s *Server) queueAction(method, queueID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch method {
+	case METHOD_START_QUEUE:
+		return s.dm.StartQueue(queueID)
+	case METHOD_PAUSE_QUEUE:
+		return s.dm.PauseQueue(queueID)
+	case METHOD_RESUME_QUEUE:
+		return s.dm.ResumeQueue(queueID)
+	default:
+		return s.dm.CancelQueue(queueID)
+	}
+}
+
+func (s *Server) downloadAction(method string, params downloadParams) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch method {
+	case METHOD_MOVE:
+		return s.dm.MoveDownload(params.DownloadID, params.Index)
+	case METHOD_SET_PRIORITY:
+		return s.dm.SetDownloadPriority(params.DownloadID, params.Priority)
+	default:
+		return s.dm.MoveDownloadToQueue(params.DownloadID, params.QueueID)
+	}
+}
+
+// eventWriter streams a command's output to the client as events.
+type eventWriter struct {
+	c     *conn
+	id    int
+	event string
+}
+
+func (w eventWriter) Write(p []byte) (int, error) {
+	data, err := json.Marshal(string(p))
+	if err != nil {
+		return 0, err
+	}
+	if err := w.c.send(message{ID: w.id, Event: w.event, Result: data}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// exec runs a CLI command against the daemon's manager.
+func (s *Server) exec(ctx context.Context, c *conn, id int, params execParams) execResult {
+	session := &cli.Session{Lock: &s.mutex, Dir: params.Dir}
+	code := cli.Execute(ctx, s.dm, session, params.Args,
+		eventWriter{c: c, id: id, event: EVENT_STDOUT},
+		eventWriter{c: c, id: id, event: EVENT_STDERR})
+	return execResult{Code: code}
+}
@@ -3,9 +3,11 @@ module github.com/mjghr/tech-download-manager
 go 1.23.4
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/charmbracelet/bubbles v0.20.0
 	github.com/charmbracelet/bubbletea v1.3.4
 	github.com/charmbracelet/lipgloss v1.0.0
+	go.etcd.io/bbolt v1.4.3
 )
 
 require (
@@ -0,0 +1,294 @@
+package manager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mjghr/tech-download-manager/controller"
+	"github.com/mjghr/tech-download-manager/util"
+)
+
+// BATCH_PROBE_WORKERS is how many URLs of a batch are probed at once.
+const BATCH_PROBE_WORKERS = 8
+
+// BatchEntry is one download of a URL list, after pattern expansion.
+type BatchEntry struct {
+	Line     int               `json:"line"`
+	URL      string            `json:"url"`
+	FileName string            `json:"fileName,omitempty"`
+	Checksum string            `json:"checksum,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	// Dir is where a partial download of a session entry is looked for.
+	Dir string `json:"dir,omitempty"`
+}
+
+// ParseURLList reads a list of URLs, one per line, in the style of an aria2
+// input file. Blank lines and lines starting with # are skipped. Indented
+// lines below a URL set options for it:
+//
+//	https://example.com/img[001-250].jpg
+//	  out=image-#1.jpg
+//	  header=Referer: https://example.com/
+//	https://example.com/{a,b}.iso
+//	  checksum=sha-256=<hex digest>
+//
+// URLs are expanded with util.ExpandPattern and "out" may refer to the glob
+// values with #1, #2, ... Every problem in the list is reported and nothing
+// is returned if there is any.
+func ParseURLList(r io.Reader) ([]BatchEntry, error) {
+	return parseList(r, false)
+}
+
+// ParseSession reads an aria2 session or input file, or a plain list of URLs
+// as given to wget -i. URLs are taken literally. Besides the options of a URL
+// list, "dir" names the directory holding a partial download of the URL; the
+// many other aria2 options are ignored.
+func ParseSession(r io.Reader) ([]BatchEntry, error) {
+	return parseList(r, true)
+}
+
+func parseList(r io.Reader, session bool) ([]BatchEntry, error) {
+	type block struct {
+		line    int
+		pattern string
+		options BatchEntry
+	}
+	var blocks []*block
+	var errs []lineError
+	fail := func(line int, err error) {
+		errs = append(errs, lineError{line, err})
+	}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if text[0] != ' ' && text[0] != '\t' {
+			// aria2 lists mirrors separated by tabs; only the first is used
+			pattern, _, _ := strings.Cut(trimmed, "\t")
+			blocks = append(blocks, &block{line: line, pattern: pattern})
+			continue
+		}
+		if len(blocks) == 0 {
+			fail(line, errors.New("option without a URL"))
+			continue
+		}
+
+		current := &blocks[len(blocks)-1].options
+		key, value, ok := strings.Cut(trimmed, "=")
+		if !ok {
+			fail(line, fmt.Errorf("option %q must look like name=value", trimmed))
+			continue
+		}
+		switch key {
+		case "out":
+			current.FileName = value
+		case "checksum":
+			if _, _, err := controller.ParseChecksum(value); err != nil {
+				fail(line, err)
+			}
+			current.Checksum = value
+		case "dir":
+			if !session {
+				fail(line, fmt.Errorf("unsupported option %q", key))
+				continue
+			}
+			current.Dir = value
+		case "header":
+			name, headerValue, ok := strings.Cut(value, ":")
+			if !ok || strings.TrimSpace(name) == "" {
+				fail(line, fmt.Errorf("header %q must look like Name: value", value))
+				continue
+			}
+			if current.Headers == nil {
+				current.Headers = map[string]string{}
+			}
+			current.Headers[http.CanonicalHeaderKey(strings.TrimSpace(name))] = strings.TrimSpace(headerValue)
+		default:
+			if !session {
+				fail(line, fmt.Errorf("unsupported option %q", key))
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var entries []BatchEntry
+	for _, b := range blocks {
+		expansions := []util.Expansion{{Value: b.pattern}}
+		if !session {
+			var err error
+			if expansions, err = util.ExpandPattern(b.pattern); err != nil {
+				fail(b.line, err)
+				continue
+			}
+		}
+		if len(expansions) > 1 && b.options.FileName != "" && !strings.Contains(b.options.FileName, "#") {
+			fail(b.line, errors.New("out must use #1, #2, ... when the URL expands to several files"))
+			continue
+		}
+		if len(expansions) > 1 && b.options.Checksum != "" {
+			fail(b.line, errors.New("a checksum needs a URL that names a single file"))
+			continue
+		}
+
+		for _, expansion := range expansions {
+			u, err := url.Parse(expansion.Value)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				fail(b.line, fmt.Errorf("invalid URL %q", expansion.Value))
+				break
+			}
+			entry := b.options
+			entry.Line = b.line
+			entry.URL = expansion.Value
+			if entry.FileName != "" {
+				if entry.FileName, err = util.ReplaceGlobRefs(entry.FileName, expansion.Values); err != nil {
+					fail(b.line, err)
+					break
+				}
+				if entry.FileName != filepath.Base(entry.FileName) {
+					fail(b.line, fmt.Errorf("out must be a file name, got %q", entry.FileName))
+					break
+				}
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool { return errs[i].line < errs[j].line })
+		joined := make([]error, len(errs))
+		for i, e := range errs {
+			joined[i] = e
+		}
+		return nil, errors.Join(joined...)
+	}
+	return entries, nil
+}
+
+// lineError is a problem found on a line of a URL list.
+type lineError struct {
+	line int
+	err  error
+}
+
+func (e lineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.line, e.err)
+}
+
+func (e lineError) Unwrap() error {
+	return e.err
+}
+
+// ProbeBatch probes the entries a few at a time and returns a download for
+// each that can be fetched, in list order. Entries that cannot be fetched
+// are reported in the error, by line.
+func (d *DownloadManager) ProbeBatch(entries []BatchEntry) ([]*controller.DownloadController, error) {
+	var dcs []*controller.DownloadController
+	var errs []error
+	for i, dc := range d.probeEntries(entries) {
+		if dc.Status == controller.FAILED {
+			errs = append(errs, lineError{entries[i].Line, fmt.Errorf("could not get file details for %s", entries[i].URL)})
+			continue
+		}
+		dcs = append(dcs, dc)
+	}
+	return dcs, errors.Join(errs...)
+}
+
+// ProbeSession probes session entries like ProbeBatch and adopts the partial
+// file each one left in its Dir, with chunk files written to tmpPath. A
+// partial file that cannot be adopted is reported and its download starts
+// over. Entries whose URL is in known, as returned by KnownURLs, duplicate a
+// download and leave their partial file alone. It also returns the number of
+// bytes adopted.
+func (d *DownloadManager) ProbeSession(entries []BatchEntry, tmpPath string, known map[string]bool) ([]*controller.DownloadController, int, error) {
+	var dcs []*controller.DownloadController
+	var errs []error
+	adopted := 0
+	for i, dc := range d.probeEntries(entries) {
+		if dc.Status == controller.FAILED {
+			errs = append(errs, lineError{entries[i].Line, fmt.Errorf("could not get file details for %s", entries[i].URL)})
+			continue
+		}
+		if !known[normalizeURL(dc.Url)] {
+			n, err := dc.AdoptPartial(filepath.Join(entries[i].Dir, dc.FileName), tmpPath)
+			if err != nil {
+				errs = append(errs, lineError{entries[i].Line, fmt.Errorf("starting %s over: %w", dc.FileName, err)})
+			}
+			adopted += n
+		}
+		dcs = append(dcs, dc)
+	}
+	return dcs, adopted, errors.Join(errs...)
+}
+
+// probeEntries creates a download for every entry, failed ones included, in
+// list order.
+func (d *DownloadManager) probeEntries(entries []BatchEntry) []*controller.DownloadController {
+	results := make([]*controller.DownloadController, len(entries))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(BATCH_PROBE_WORKERS, len(entries)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				u, _ := url.Parse(entries[i].URL)
+				dc := d.NewDownloadControllerWithHeaders(u, entries[i].Headers)
+				if entries[i].FileName != "" {
+					dc.FileName = entries[i].FileName
+				}
+				dc.Checksum = entries[i].Checksum
+				results[i] = dc
+			}
+		}()
+	}
+	for i := range entries {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// AddDownloads appends probed downloads to a queue and persists them. Each
+// is first checked for duplicates as AddProbed does, and handled as
+// download.on_duplicate says; with "ask" duplicates are skipped, as there is
+// no one to ask. It returns the downloads added or reused, and reports the
+// skipped ones in skipped, one error each. err is set when the queue could
+// not be saved.
+func (d *DownloadManager) AddDownloads(queue *controller.QueueController, dcs []*controller.DownloadController) (added []*controller.DownloadController, skipped error, err error) {
+	onDuplicate := controller.DefaultDuplicateAction()
+	var errs []error
+	for _, dc := range dcs {
+		existing, handled, dupErr := d.resolveDuplicate(queue, dc, onDuplicate)
+		if handled {
+			if dupErr != nil {
+				errs = append(errs, fmt.Errorf("skipped %s: %w", dc.FileName, dupErr))
+			} else {
+				added = append(added, existing)
+			}
+			continue
+		}
+		queue.AddDownload(dc)
+		if err := d.SaveDownload(dc); err != nil {
+			return added, errors.Join(errs...), err
+		}
+		added = append(added, dc)
+	}
+	return added, errors.Join(errs...), d.SaveQueue(queue)
+}
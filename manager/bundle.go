@@ -0,0 +1,63 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mjghr/tech-download-manager/controller"
+)
+
+// ProbeBundle creates the queue of a bundle and probes its downloads without
+// adding anything to the manager. Downloads that cannot be fetched are
+// reported in the error, numbered as in the bundle.
+func (d *DownloadManager) ProbeBundle(bundle *controller.QueueBundle, saveDir string) (*controller.QueueController, []*controller.DownloadController, error) {
+	queue, err := bundle.NewQueue(saveDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries := make([]BatchEntry, len(bundle.Downloads))
+	for i, download := range bundle.Downloads {
+		entries[i] = BatchEntry{
+			URL:      download.URL,
+			FileName: download.FileName,
+			Checksum: download.Checksum,
+			Headers:  download.Headers,
+		}
+	}
+
+	var dcs []*controller.DownloadController
+	var errs []error
+	for i, dc := range d.probeEntries(entries) {
+		if dc.Status == controller.FAILED {
+			errs = append(errs, fmt.Errorf("download %d: could not get file details for %s", i+1, entries[i].URL))
+			continue
+		}
+		dcs = append(dcs, dc)
+	}
+	return queue, dcs, errors.Join(errs...)
+}
+
+// AddImportedQueue adds a probed bundle queue and its downloads and persists
+// them, with duplicates handled as AddDownloads does. The queue is renamed
+// "Name (2)", "Name (3)", ... if its name is taken.
+func (d *DownloadManager) AddImportedQueue(queue *controller.QueueController, dcs []*controller.DownloadController) (added []*controller.DownloadController, skipped error, err error) {
+	queue.QueueName = d.UniqueQueueName(queue.QueueName)
+	if err := d.CreateQueue(queue); err != nil {
+		return nil, nil, err
+	}
+	return d.AddDownloads(queue, dcs)
+}
+
+// ImportQueueBundle creates a queue from a bundle, with new IDs throughout,
+// and adds the downloads that can be fetched.
+func (d *DownloadManager) ImportQueueBundle(bundle *controller.QueueBundle, saveDir string) (*controller.QueueController, error) {
+	queue, dcs, probeErr := d.ProbeBundle(bundle, saveDir)
+	if queue == nil {
+		return nil, probeErr
+	}
+	_, skipped, err := d.AddImportedQueue(queue, dcs)
+	if err != nil {
+		return nil, err
+	}
+	return queue, errors.Join(probeErr, skipped)
+}
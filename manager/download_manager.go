@@ -3,9 +3,8 @@ package manager
 import (
 	"fmt"
 	"net/url"
-	"os"
 	"strconv"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mjghr/tech-download-manager/client"
@@ -15,59 +14,238 @@ import (
 	"github.com/mjghr/tech-download-manager/util"
 )
 
+// lastDownloadID is the timestamp of the most recent download ID.
+var lastDownloadID atomic.Int64
+
+// newDownloadID returns a "dc-<nanoseconds>" ID, bumped past the previous one
+// so downloads probed concurrently never share an ID.
+func newDownloadID() string {
+	for {
+		last := lastDownloadID.Load()
+		next := max(time.Now().UnixNano(), last+1)
+		if lastDownloadID.CompareAndSwap(last, next) {
+			return fmt.Sprintf("dc-%d", next)
+		}
+	}
+}
+
 type DownloadManager struct {
-	QueueList []*controller.QueueController
+	QueueList    []*controller.QueueController
+	Store        controller.StateStore
+	checkpointer *controller.Checkpointer
+}
+
+// NewDownloadManager creates a manager persisting to store and starts
+// checkpointing download progress to it. A nil store falls back to the JSON
+// state file from the config.
+func NewDownloadManager(store controller.StateStore) *DownloadManager {
+	if store == nil {
+		store = controller.NewJSONStore(config.Get().Paths.StateFile)
+	}
+	checkpointer := controller.NewCheckpointer(store, config.Get().Download.CheckpointInterval)
+	controller.SetCheckpointer(checkpointer)
+	checkpointer.Start()
+	return &DownloadManager{Store: store, checkpointer: checkpointer}
+}
+
+// Close stops checkpointing and writes any progress not yet persisted.
+func (d *DownloadManager) Close() error {
+	controller.SetCheckpointer(nil)
+	return d.checkpointer.Stop()
 }
 
 func (d *DownloadManager) AddQueue(queue *controller.QueueController) {
 	d.QueueList = append(d.QueueList, queue)
 }
 
-func (d *DownloadManager) SaveQueues() {
-	controller.SaveQueueControllers(config.JSON_ADDRESS, d.QueueList)
+// FindQueue returns the queue with the given ID or, failing that, name.
+func (d *DownloadManager) FindQueue(idOrName string) (*controller.QueueController, error) {
+	for _, queue := range d.QueueList {
+		if queue.QueueID == idOrName {
+			return queue, nil
+		}
+	}
+	for _, queue := range d.QueueList {
+		if queue.QueueName == idOrName {
+			return queue, nil
+		}
+	}
+	return nil, fmt.Errorf("queue %s not found", idOrName)
+}
+
+// UniqueQueueName returns name, or "name (2)", "name (3)", ... if a queue
+// already has it.
+func (d *DownloadManager) UniqueQueueName(name string) string {
+	unique := name
+	for n := 2; ; n++ {
+		if _, err := d.FindQueue(unique); err != nil {
+			return unique
+		}
+		unique = fmt.Sprintf("%s (%d)", name, n)
+	}
+}
+
+// FindDownload returns the download with the given ID and the queue holding it.
+func (d *DownloadManager) FindDownload(downloadID string) (*controller.QueueController, *controller.DownloadController, error) {
+	for _, queue := range d.QueueList {
+		for _, dc := range queue.Downloads() {
+			if dc.ID == downloadID {
+				return queue, dc, nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("download %s not found", downloadID)
+}
+
+// RemoveQueue removes a queue and its downloads from the manager and the store.
+func (d *DownloadManager) RemoveQueue(queueID string) error {
+	for i, queue := range d.QueueList {
+		if queue.QueueID == queueID {
+			d.QueueList = append(d.QueueList[:i], d.QueueList[i+1:]...)
+			logs.Log(fmt.Sprintf("Removed queue %s", queueID))
+			return d.Store.DeleteQueue(queueID)
+		}
+	}
+	return fmt.Errorf("queue %s not found", queueID)
+}
+
+// RemoveDownload cancels a download, removes its temp files and deletes it
+// from its queue and the store.
+func (d *DownloadManager) RemoveDownload(downloadID string) error {
+	queue, _, err := d.FindDownload(downloadID)
+	if err != nil {
+		return err
+	}
+	if err := queue.CancelDownload(downloadID); err != nil {
+		return err
+	}
+	if err := queue.RemoveDownload(downloadID); err != nil {
+		return err
+	}
+	if err := d.Store.DeleteDownload(downloadID); err != nil {
+		return err
+	}
+	return d.SaveQueue(queue)
+}
+
+// LoadQueues appends every persisted queue to QueueList. Queues restored from
+// a backup are added and the *controller.BackupRestoredError is returned.
+func (d *DownloadManager) LoadQueues() error {
+	queues, err := d.Store.Load()
+	for _, queue := range queues {
+		// Trust partial chunk files only as far as the last checkpoint
+		for _, dc := range queue.Downloads() {
+			dc.Rehydrate()
+			if dc.GetStatus() == controller.COMPLETED {
+				continue
+			}
+			if reconcileErr := dc.ReconcileProgress(queue.TempPath); reconcileErr != nil {
+				logs.Log(fmt.Sprintf("Warning: failed to reconcile progress of %s: %v", dc.ID, reconcileErr))
+			}
+		}
+		d.AddQueue(queue)
+	}
+	// A backup may lack downloads whose work directories are still wanted
+	if err == nil {
+		if _, gcErr := d.CollectWorkDirs(); gcErr != nil {
+			logs.Log(fmt.Sprintf("Warning: failed to collect work directories: %v", gcErr))
+		}
+	}
+	return err
+}
+
+// ResumeInterrupted continues, in queues with AutoResume, the downloads the
+// previous process left interrupted, and persists their new status.
+func (d *DownloadManager) ResumeInterrupted() error {
+	for _, queue := range d.QueueList {
+		if queue.ResumeInterrupted() == 0 {
+			continue
+		}
+		for _, dc := range queue.Downloads() {
+			if err := d.SaveDownload(dc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CollectWorkDirs removes the work directories left in the temp directories
+// by downloads that no longer exist, and returns how many it removed.
+func (d *DownloadManager) CollectWorkDirs() (int, error) {
+	tmpPaths := []string{config.Get().Paths.TempDir}
+	known := make(map[string]bool)
+	for _, queue := range d.QueueList {
+		tmpPaths = append(tmpPaths, queue.TempPath)
+		for _, dc := range queue.Downloads() {
+			known[dc.ID] = true
+		}
+	}
+	return controller.CollectWorkDirs(tmpPaths, known)
+}
+
+// SaveQueues rewrites the whole persisted state.
+func (d *DownloadManager) SaveQueues() error {
+	return d.Store.Save(d.QueueList)
+}
+
+// SaveQueue persists a queue's settings and download order.
+func (d *DownloadManager) SaveQueue(queue *controller.QueueController) error {
+	return d.Store.SaveQueue(queue)
+}
+
+// SaveDownload persists a single download record.
+func (d *DownloadManager) SaveDownload(dc *controller.DownloadController) error {
+	return d.Store.SaveDownload(dc)
 }
 
 func (d *DownloadManager) NewDownloadController(urlPtr *url.URL) *controller.DownloadController {
+	return d.NewDownloadControllerWithHeaders(urlPtr, nil)
+}
+
+// NewDownloadControllerWithHeaders probes urlPtr sending the given headers,
+// which the download keeps for its own requests.
+func (d *DownloadManager) NewDownloadControllerWithHeaders(urlPtr *url.URL, headers map[string]string) *controller.DownloadController {
 	logs.Log(fmt.Sprintf("Creating new download controller for URL: %s", urlPtr.String()))
 
 	// Initialize HTTP client early to use for HEAD request
 	httpClient := client.NewHTTPClient()
+	downloadController := &controller.DownloadController{
+		ID:         newDownloadID(),
+		Url:        urlPtr.String(),
+		Status:     controller.PROBING,
+		HttpClient: httpClient,
+		Headers:    headers,
+	}
 
 	// Get file details with HEAD request
-	resp, err := httpClient.SendRequest("HEAD", urlPtr.String(), map[string]string{
-		"User-Agent": "tech-idm",
-	})
+	defer controller.AcquireHost(urlPtr.String())()
+	resp, err := httpClient.SendRequest("HEAD", urlPtr.String(), controller.RequestHeaders(headers))
 	if err != nil {
 		logs.Log(fmt.Sprintf("Warning: Failed to get file size: %v", err))
-		return &controller.DownloadController{
-			Status: controller.FAILED,
-			Url:    urlPtr.String(),
-			ID:     fmt.Sprintf("dc-%d", time.Now().UnixNano()),
-		}
+		downloadController.Probed(err)
+		return downloadController
 	}
 	defer resp.Body.Close()
 
+	// An error page has a length too, but it is not the file
+	if resp.StatusCode >= 400 {
+		logs.Log(fmt.Sprintf("Warning: Failed to get file details: status code %d", resp.StatusCode))
+		downloadController.Probed(fmt.Errorf("status code %d", resp.StatusCode))
+		return downloadController
+	}
+
 	// Parse Content-Length
 	contentLength := resp.Header.Get("Content-Length")
 	totalSize, err := strconv.Atoi(contentLength)
 	if err != nil || totalSize <= 0 {
 		logs.Log(fmt.Sprintf("Warning: Invalid Content-Length '%s': %v", contentLength, err))
-		return &controller.DownloadController{
-			Status: controller.FAILED,
-			Url:    urlPtr.String(),
-			ID:     fmt.Sprintf("dc-%d", time.Now().UnixNano()),
-		}
+		downloadController.Probed(fmt.Errorf("invalid Content-Length %q", contentLength))
+		return downloadController
 	}
 
-	// Get speed limit from environment
-	speedLimitStr := os.Getenv("SPEED_LIMIT_KB")
-	speedLimit, err := strconv.Atoi(speedLimitStr)
-	if err != nil {
-		logs.Log(fmt.Sprintf("Invalid SPEED_LIMIT_KB value '%s', defaulting to 0: %v", speedLimitStr, err))
-		speedLimit = 0
-	} else {
-		speedLimit = speedLimit * 1024 // Convert KB/s to bytes/s
-	}
+	// Get speed limit from config
+	speedLimit := config.Get().Download.SpeedLimitKB * 1024 // Convert KB/s to bytes/s
 
 	// Extract filename from URL
 	fileName, err := util.ExtractFileName(urlPtr.String())
@@ -76,21 +254,16 @@ func (d *DownloadManager) NewDownloadController(urlPtr *url.URL) *controller.Dow
 		fileName = fmt.Sprintf("download-%d", time.Now().UnixNano())
 	}
 
-	downloadController := &controller.DownloadController{
-		ID:         fmt.Sprintf("dc-%d", time.Now().UnixNano()),
-		Url:        urlPtr.String(),
-		Status:     controller.NOT_STARTED,
-		FileName:   fileName,
-		TotalSize:  totalSize,
-		HttpClient: httpClient,
-		SpeedLimit: speedLimit,
-		Mutex:      sync.Mutex{},
-		ResumeChan: make(chan bool),
-		PauseChan:  make(chan bool),
-	}
+	downloadController.FileName = fileName
+	downloadController.TotalSize = totalSize
+	downloadController.SpeedLimit = speedLimit
+	// Kept in the work directory's manifest to tell which version of the
+	// file its chunks are from
+	downloadController.ETag = resp.Header.Get("ETag")
+	downloadController.LastModified = resp.Header.Get("Last-Modified")
 
 	// Calculate optimal chunks
-	workers, chunkSize := util.CalculateOptimalWorkersAndChunkSize(totalSize)
+	workers, chunkSize := util.CalculateOptimalWorkersAndChunkSize(totalSize, config.Get().Download.MaxWorkers)
 	downloadController.Chunks = downloadController.SplitIntoChunks(workers, chunkSize)
 	downloadController.CompletedBytes = make([]int, len(downloadController.Chunks))
 
@@ -101,5 +274,6 @@ func (d *DownloadManager) NewDownloadController(urlPtr *url.URL) *controller.Dow
 		len(downloadController.Chunks),
 		downloadController.SpeedLimit))
 
+	downloadController.Probed(nil)
 	return downloadController
 }
@@ -0,0 +1,141 @@
+package manager
+
+import (
+	"fmt"
+
+	"github.com/mjghr/tech-download-manager/controller"
+	"github.com/mjghr/tech-download-manager/ui/logs"
+	"github.com/mjghr/tech-download-manager/util"
+)
+
+// FindByURL returns the download of rawURL in any queue, with URLs compared
+// after util.NormalizeURL, and the queue holding it. Queues and downloads are
+// read under their locks, as transfers may be changing them.
+func (d *DownloadManager) FindByURL(rawURL string) (*controller.QueueController, *controller.DownloadController) {
+	want := normalizeURL(rawURL)
+	for _, queue := range d.QueueList {
+		for _, dc := range queue.Downloads() {
+			if normalizeURL(dc.URL()) == want {
+				return queue, dc
+			}
+		}
+	}
+	return nil, nil
+}
+
+// KnownURLs returns the URLs of every download, normalized as FindByURL
+// compares them, for checks made while the manager may not be used.
+func (d *DownloadManager) KnownURLs() map[string]bool {
+	known := make(map[string]bool)
+	for _, queue := range d.QueueList {
+		for _, dc := range queue.Downloads() {
+			known[normalizeURL(dc.URL())] = true
+		}
+	}
+	return known
+}
+
+// normalizeURL returns rawURL after util.NormalizeURL, or as is if it cannot
+// be parsed.
+func normalizeURL(rawURL string) string {
+	normalized, err := util.NormalizeURL(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return normalized
+}
+
+// AddProbed adds a probed download to queue and persists it, unless it is a
+// duplicate: of a download of the same URL in any queue, or of a file at its
+// destination in queue's save directory. onDuplicate decides what happens to
+// a duplicate, see controller.DuplicateAction. It returns the download that
+// was added or reused, which for an existing download is not dc.
+func (d *DownloadManager) AddProbed(queue *controller.QueueController, dc *controller.DownloadController, onDuplicate controller.DuplicateAction) (*controller.DownloadController, error) {
+	if existing, handled, err := d.resolveDuplicate(queue, dc, onDuplicate); handled {
+		return existing, err
+	}
+
+	queue.AddDownload(dc)
+	if err := d.SaveDownload(dc); err != nil {
+		return dc, err
+	}
+	return dc, d.SaveQueue(queue)
+}
+
+// resolveDuplicate handles dc as onDuplicate says if it duplicates a download
+// of the same URL or a file at its destination in queue's save directory. It
+// reports false when dc is to be added, which for a file at its destination
+// it may have been prepared to continue.
+func (d *DownloadManager) resolveDuplicate(queue *controller.QueueController, dc *controller.DownloadController, onDuplicate controller.DuplicateAction) (*controller.DownloadController, bool, error) {
+	if existing, handled, err := d.resolveDuplicateURL(dc.Url, onDuplicate); handled {
+		return existing, true, err
+	}
+
+	// A download whose probe failed has no size to compare
+	if dc.Status == controller.FAILED {
+		return nil, false, nil
+	}
+	file, err := dc.FindExistingFile(queue.SavePath)
+	if err != nil {
+		return nil, true, err
+	}
+	if file != nil {
+		switch onDuplicate {
+		case controller.DUPLICATE_REDOWNLOAD:
+			logs.Log(fmt.Sprintf("Download %s will replace %s", dc.ID, file.Path))
+		case controller.DUPLICATE_RESUME:
+			if err := dc.AttachExisting(file, queue.TempPath); err != nil {
+				return nil, true, err
+			}
+		default:
+			return nil, true, &controller.DuplicateError{URL: dc.Url, File: file}
+		}
+	}
+	return nil, false, nil
+}
+
+// resolveDuplicateURL handles an existing download of rawURL as onDuplicate
+// says. It reports false when there is none.
+func (d *DownloadManager) resolveDuplicateURL(rawURL string, onDuplicate controller.DuplicateAction) (*controller.DownloadController, bool, error) {
+	queue, existing := d.FindByURL(rawURL)
+	if existing == nil {
+		return nil, false, nil
+	}
+
+	var err error
+	switch onDuplicate {
+	case controller.DUPLICATE_REDOWNLOAD:
+		err = queue.RestartDownload(existing.ID)
+	case controller.DUPLICATE_RESUME:
+		err = continueDownload(queue, existing)
+	default:
+		return existing, true, &controller.DuplicateError{
+			URL:        rawURL,
+			DownloadID: existing.ID,
+			QueueID:    queue.QueueID,
+			Queue:      queue.QueueName,
+			Status:     existing.GetStatus().String(),
+		}
+	}
+	if err != nil {
+		return existing, true, err
+	}
+	logs.Log(fmt.Sprintf("Reused download %s for %s (%s)", existing.ID, rawURL, onDuplicate))
+	return existing, true, d.SaveDownload(existing)
+}
+
+// continueDownload runs a paused, failed or canceled download again straight
+// away from its partial files, as resuming or retrying it by hand does.
+// Pending, running and finished downloads are left alone.
+func continueDownload(queue *controller.QueueController, dc *controller.DownloadController) error {
+	switch dc.GetStatus() {
+	case controller.PAUSED:
+		return queue.ResumeDownload(dc.ID)
+	case controller.FAILED, controller.CANCELED:
+		if err := dc.PrepareRetry(queue.TempPath); err != nil {
+			return err
+		}
+		return queue.StartDownload(dc.ID)
+	}
+	return nil
+}
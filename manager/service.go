@@ -0,0 +1,171 @@
+package manager
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/mjghr/tech-download-manager/controller"
+)
+
+// Service is what the UI needs from a download manager. DownloadManager
+// implements it in this process and daemon.Client over the daemon's control
+// socket.
+type Service interface {
+	// LoadQueues makes the persisted queues available through Queues.
+	LoadQueues() error
+	// ResumeInterrupted continues, in queues with AutoResume, the downloads
+	// the previous process left interrupted. It is called once, after
+	// LoadQueues, by whatever runs the downloads.
+	ResumeInterrupted() error
+	// Queues returns the current queues. Callers must treat them as read-only
+	// and go through the other methods to change them.
+	Queues() []*controller.QueueController
+	// CreateQueue adds a configured queue and persists it.
+	CreateQueue(queue *controller.QueueController) error
+	// AddDownload probes u and appends the resulting download to a queue.
+	// A duplicate of another download or of a file at its destination is
+	// handled as onDuplicate says; unless it is reused, a
+	// *controller.DuplicateError describes it.
+	AddDownload(queueID string, u *url.URL, onDuplicate controller.DuplicateAction) (*controller.DownloadController, error)
+	// ImportDownloads probes the entries of a URL list and appends those that
+	// can be fetched to a queue, duplicates handled as AddDownloads does. It
+	// reports how many were added along with any entries that failed or were
+	// skipped.
+	ImportDownloads(queueID string, entries []BatchEntry) (int, error)
+	// ImportQueueBundle creates a queue from a shared bundle with new IDs and
+	// adds the downloads that can be fetched. saveDir overrides the bundle's
+	// save directory. Downloads that failed are reported in the error.
+	ImportQueueBundle(bundle *controller.QueueBundle, saveDir string) (*controller.QueueController, error)
+	StartQueue(queueID string) error
+	PauseQueue(queueID string) error
+	ResumeQueue(queueID string) error
+	CancelQueue(queueID string) error
+	// MoveDownload moves a download to index in its queue's order.
+	MoveDownload(downloadID string, index int) error
+	// SetDownloadPriority changes which waiting downloads start first.
+	SetDownloadPriority(downloadID string, priority int) error
+	// MoveDownloadToQueue moves a download to the end of another queue,
+	// keeping its progress and partial files.
+	MoveDownloadToQueue(downloadID, queueID string) error
+	// SaveQueues rewrites the whole persisted state.
+	SaveQueues() error
+}
+
+var _ Service = (*DownloadManager)(nil)
+
+// Queues returns the queues held by the manager.
+func (d *DownloadManager) Queues() []*controller.QueueController {
+	return d.QueueList
+}
+
+// CreateQueue adds a queue to the manager and persists it.
+func (d *DownloadManager) CreateQueue(queue *controller.QueueController) error {
+	d.AddQueue(queue)
+	return d.SaveQueue(queue)
+}
+
+// AddDownload creates a download for u, appends it to the queue and persists
+// both. Downloads whose probe failed are still added, marked FAILED.
+func (d *DownloadManager) AddDownload(queueID string, u *url.URL, onDuplicate controller.DuplicateAction) (*controller.DownloadController, error) {
+	queue, err := d.FindQueue(queueID)
+	if err != nil {
+		return nil, err
+	}
+
+	// A known URL needs no probe
+	if existing, handled, err := d.resolveDuplicateURL(u.String(), onDuplicate); handled {
+		return existing, err
+	}
+	return d.AddProbed(queue, d.NewDownloadController(u), onDuplicate)
+}
+
+func (d *DownloadManager) ImportDownloads(queueID string, entries []BatchEntry) (int, error) {
+	queue, err := d.FindQueue(queueID)
+	if err != nil {
+		return 0, err
+	}
+
+	dcs, probeErr := d.ProbeBatch(entries)
+	added, skipped, err := d.AddDownloads(queue, dcs)
+	if err != nil {
+		return len(added), err
+	}
+	return len(added), errors.Join(probeErr, skipped)
+}
+
+func (d *DownloadManager) StartQueue(queueID string) error {
+	queue, err := d.FindQueue(queueID)
+	if err != nil {
+		return err
+	}
+	return queue.Start()
+}
+
+func (d *DownloadManager) PauseQueue(queueID string) error {
+	queue, err := d.FindQueue(queueID)
+	if err != nil {
+		return err
+	}
+	queue.PauseAll()
+	return nil
+}
+
+func (d *DownloadManager) ResumeQueue(queueID string) error {
+	queue, err := d.FindQueue(queueID)
+	if err != nil {
+		return err
+	}
+	queue.ResumeAll()
+	return nil
+}
+
+func (d *DownloadManager) CancelQueue(queueID string) error {
+	queue, err := d.FindQueue(queueID)
+	if err != nil {
+		return err
+	}
+	return queue.CancelAll()
+}
+
+func (d *DownloadManager) MoveDownload(downloadID string, index int) error {
+	queue, _, err := d.FindDownload(downloadID)
+	if err != nil {
+		return err
+	}
+	if err := queue.MoveDownload(downloadID, index); err != nil {
+		return err
+	}
+	return d.SaveQueue(queue)
+}
+
+func (d *DownloadManager) SetDownloadPriority(downloadID string, priority int) error {
+	queue, dc, err := d.FindDownload(downloadID)
+	if err != nil {
+		return err
+	}
+	if err := queue.SetPriority(downloadID, priority); err != nil {
+		return err
+	}
+	return d.SaveDownload(dc)
+}
+
+func (d *DownloadManager) MoveDownloadToQueue(downloadID, queueID string) error {
+	source, dc, err := d.FindDownload(downloadID)
+	if err != nil {
+		return err
+	}
+	target, err := d.FindQueue(queueID)
+	if err != nil {
+		return err
+	}
+	if err := source.MoveDownloadTo(downloadID, target); err != nil {
+		return err
+	}
+	if err := d.SaveDownload(dc); err != nil {
+		return err
+	}
+	if err := d.SaveQueue(source); err != nil {
+		return err
+	}
+	return d.SaveQueue(target)
+}
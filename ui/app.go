@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"time"
@@ -16,12 +17,14 @@ import (
 	"github.com/mjghr/tech-download-manager/ui/newDownloads"
 	"github.com/mjghr/tech-download-manager/ui/newQueue"
 	"github.com/mjghr/tech-download-manager/ui/queues"
-	"github.com/mjghr/tech-download-manager/util"
 )
 
 // Add these new types near the top of the file
 type tickMsg time.Time
 
+// stateWarningMsg carries a warning about the persisted state to show in the UI.
+type stateWarningMsg string
+
 func tick() tea.Cmd {
 	return tea.Tick(100*time.Millisecond, func(t time.Time) tea.Msg {
 		return tickMsg(t)
@@ -35,7 +38,8 @@ type AppModel struct {
 	width           int
 	height          int
 	footerText      string
-	downloadManager *manager.DownloadManager
+	warning         string
+	downloadManager manager.Service
 	ready           bool
 
 	// Sub-models (each tab)
@@ -46,9 +50,9 @@ type AppModel struct {
 	downloadsListModel downloads.Model
 }
 
-// NewAppModel initializes the root model with default values.
-func NewAppModel() AppModel {
-	dm := &manager.DownloadManager{}
+// NewAppModel initializes the root model with default values. dm is either an
+// in-process manager or a client of a running daemon.
+func NewAppModel(dm manager.Service) AppModel {
 
 	return AppModel{
 		tabs:            []string{"NewDownload", "NewQueue", "Queues", "Downloads", "Guide"},
@@ -58,7 +62,7 @@ func NewAppModel() AppModel {
 		ready:           false,
 
 		// Create each sub-model
-		queuesModel:        queues.NewModel(),
+		queuesModel:        queues.NewModel(dm),
 		guideModel:         guide.NewModel(),
 		newDownloadModel:   newDownloads.NewModel(dm),
 		newQueueModel:      newQueue.NewModel(dm),
@@ -68,22 +72,31 @@ func NewAppModel() AppModel {
 
 // Init implements tea.Model. We can start in alt screen mode, etc.
 func (m AppModel) Init() tea.Cmd {
-	config.LoadEnv()
-	logs.Log("Welcome to Download Manager")
-
+	logs.Log(config.Get().General.WelcomeMessage)
+	var warningCmd tea.Cmd
+
+	// Load existing queues from the state store
+	err := m.downloadManager.LoadQueues()
+	loadedQueues := m.downloadManager.Queues()
+
+	var restored *controller.BackupRestoredError
+	if errors.As(err, &restored) {
+		logs.Log(fmt.Sprintf("Warning: %v", err))
+		warningCmd = func() tea.Msg { return stateWarningMsg(restored.Error()) }
+		err = nil
+	}
 
-	// Load existing queues from the JSON file
-	filename := "queues.json"
-	loadedQueues, err := controller.LoadQueueControllers(filename)
+	var tooNew *controller.UnsupportedVersionError
+	if errors.As(err, &tooNew) {
+		warningCmd = func() tea.Msg { return stateWarningMsg(tooNew.Error()) }
+	}
 
 	if err != nil {
 		logs.Log(fmt.Sprintf("Error loading queues: %v", err))
 	} else if len(loadedQueues) > 0 {
-		logs.Log(fmt.Sprintf("Loaded %d queues from %s", len(loadedQueues), filename))
-
-		// Add loaded queues to download manager
-		for _, queue := range loadedQueues {
-			m.downloadManager.AddQueue(queue)
+		logs.Log(fmt.Sprintf("Loaded %d queues", len(loadedQueues)))
+		if err := m.downloadManager.ResumeInterrupted(); err != nil {
+			logs.Log(fmt.Sprintf("Error resuming interrupted downloads: %v", err))
 		}
 	} else {
 		logs.Log("No existing queues found, creating a default one")
@@ -93,40 +106,37 @@ func (m AppModel) Init() tea.Cmd {
 		url2, err2 := url.Parse("https://upload.wikimedia.org/wikipedia/commons/thumb/3/31/David_-_Napoleon_crossing_the_Alps_-_Malmaison1.jpg/640px-David_-_Napoleon_crossing_the_Alps_-_Malmaison1.jpg")
 
 		if err1 == nil && err2 == nil {
-			// Create download controllers for test URLs
-			dc1 := m.downloadManager.NewDownloadController(url1)
-			dc2 := m.downloadManager.NewDownloadController(url2)
-
 			// Set up an example queue controller
-			savePath := util.GiveDefaultSavePath()
+			savePath := config.Get().Paths.SaveDir
 			queueCtrl := controller.NewQueueController("Example Queue")
 			queueCtrl.UpdateQueueController(
 				savePath,
 				2,        // concurrent download limit
 				100*1024, // speed limit (100KB/s)
 				time.Now(),
-				time.Now().Add(time.Hour*24),
+				time.Now().Add(config.Get().Queue.Window),
 			)
 
-			// Add queue to download manager and add downloads to the queue
-			m.downloadManager.AddQueue(queueCtrl)
-			queueCtrl.AddDownload(dc1)
-			queueCtrl.AddDownload(dc2)
-
-			// Save the example queue to disk
-			if err := controller.SaveQueueControllers(filename, m.downloadManager.QueueList); err != nil {
+			// Add queue to download manager and add the test downloads to it
+			if err := m.downloadManager.CreateQueue(queueCtrl); err != nil {
 				logs.Log(fmt.Sprintf("Error saving initial queues: %v", err))
 			}
+			for _, u := range []*url.URL{url1, url2} {
+				if _, err := m.downloadManager.AddDownload(queueCtrl.QueueID, u, controller.DUPLICATE_SKIP); err != nil {
+					logs.Log(fmt.Sprintf("Error adding example download: %v", err))
+				}
+			}
 		} else {
 			logs.Log(fmt.Sprintf("Error creating example URLs: %v, %v", err1, err2))
 		}
 	}
 
 	// Update all models with current queue state
-	logs.Log(fmt.Sprintf("Initializing models with %d queues", len(m.downloadManager.QueueList)))
+	logs.Log(fmt.Sprintf("Initializing models with %d queues", len(m.downloadManager.Queues())))
 	m.updateModels()
 
 	return tea.Batch(
+		warningCmd,         // Surface a restored-from-backup warning, if any
 		tick(),             // Start the ticker
 		tea.EnterAltScreen, // Enter alternative screen mode
 		tea.ClearScreen,    // Clear the screen immediately
@@ -141,13 +151,13 @@ func (m AppModel) Init() tea.Cmd {
 // Update all models with current queue information
 func (m *AppModel) updateModels() {
 	// Log current queue count for debugging
-	queueCount := len(m.downloadManager.QueueList)
-	logs.Log(fmt.Sprintf("Updating models with %d queues", queueCount))
+	queueList := m.downloadManager.Queues()
+	logs.Log(fmt.Sprintf("Updating models with %d queues", len(queueList)))
 
 	// Update all models that need the queue list
-	m.queuesModel.UpdateQueues(m.downloadManager.QueueList)
-	m.newDownloadModel.UpdateQueues(m.downloadManager.QueueList)
-	m.downloadsListModel.UpdateDownloads(m.downloadManager.QueueList)
+	m.queuesModel.UpdateQueues(queueList)
+	m.newDownloadModel.UpdateQueues(queueList)
+	m.downloadsListModel.UpdateDownloads(queueList)
 }
 
 // Update implements tea.Model and handles incoming messages.
@@ -169,6 +179,9 @@ func (m AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.newDownloadModel.SetSize(m.width, m.height)
 		m.downloadsListModel.SetSize(m.width, m.height)
 
+	case stateWarningMsg:
+		m.warning = string(msg)
+
 	case tickMsg:
 		// Update all models with current queue state every tick
 		m.updateModels()
@@ -196,7 +209,7 @@ func (m AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, tea.Batch(cmds...)
 		case "ctrl+c":
 			// Save queues before quitting
-			if err := controller.SaveQueueControllers("queues.json", m.downloadManager.QueueList); err != nil {
+			if err := m.downloadManager.SaveQueues(); err != nil {
 				logs.Log(fmt.Sprintf("Error saving queues: %v", err))
 			}
 			return m, tea.Sequence(
@@ -325,6 +338,9 @@ func (m AppModel) View() string {
 	// 3. Render the footer with tab-specific text
 	footerText := m.getFooterText()
 	footer := FooterStyle.Render(footerText)
+	if m.warning != "" {
+		footer = WarningStyle.Render("⚠ "+m.warning) + "\n" + footer
+	}
 
 	// Return the complete view
 	return BaseStyle.Render(
@@ -16,9 +16,10 @@ import (
 
 // KeyMap defines the keybindings for the downloads list
 type KeyMap struct {
-	Up     key.Binding
-	Down   key.Binding
-	Escape key.Binding
+	Up      key.Binding
+	Down    key.Binding
+	History key.Binding
+	Escape  key.Binding
 }
 
 // DefaultKeyMap returns the default keybindings
@@ -32,6 +33,10 @@ func DefaultKeyMap() KeyMap {
 			key.WithKeys("down", "j"),
 			key.WithHelp("↓/j", "down"),
 		),
+		History: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "toggle history"),
+		),
 		Escape: key.NewBinding(
 			key.WithKeys("esc"),
 			key.WithHelp("esc", "toggle focus"),
@@ -52,8 +57,12 @@ type Model struct {
 	showStatus    bool
 	statusExpiry  time.Time
 	queues        []*controller.QueueController
+	showHistory   bool // show the status changes of the selected download
 }
 
+// HISTORY_LINES is how many of the latest status changes the history shows.
+const HISTORY_LINES = 8
+
 // NewModel creates a new model for the downloads list
 func NewModel() Model {
 	keymap := DefaultKeyMap()
@@ -124,6 +133,8 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 			// Pass navigation keys to the table
 			case key.Matches(msg, m.keymap.Up), key.Matches(msg, m.keymap.Down):
 				m.table, cmd = m.table.Update(msg)
+			case key.Matches(msg, m.keymap.History):
+				m.showHistory = !m.showHistory
 			}
 		}
 	}
@@ -148,6 +159,10 @@ func formatStatus(status controller.Status) string {
 		return "⬇️ Downloading"
 	case controller.CANCELED:
 		return "🚫 Canceled"
+	case controller.PROBING:
+		return "🔍 Probing"
+	case controller.VERIFYING:
+		return "🔎 Verifying"
 	default:
 		return "Unknown"
 	}
@@ -203,6 +218,7 @@ func (m Model) View() string {
 			Render("Downloads List"),
 		"\n",
 		m.table.View(),
+		m.historyView(),
 		"\n",
 		statusView,
 		"\n",
@@ -210,6 +226,36 @@ func (m Model) View() string {
 	)
 }
 
+// historyView lists the latest status changes of the selected download,
+// newest first, when the history is shown.
+func (m Model) historyView() string {
+	cursor := m.table.Cursor()
+	if !m.showHistory || cursor < 0 || cursor >= len(m.allDownloads) {
+		return ""
+	}
+	download := m.allDownloads[cursor]
+
+	lines := []string{lipgloss.NewStyle().Bold(true).Render("History of " + download.FileName)}
+	history := download.StatusHistory()
+	if len(history) == 0 {
+		lines = append(lines, "No status changes recorded")
+	}
+	for i := len(history) - 1; i >= 0 && i >= len(history)-HISTORY_LINES; i-- {
+		t := history[i]
+		line := fmt.Sprintf("%s  %s → %s", t.At.Format(time.DateTime), formatStatus(t.From), formatStatus(t.To))
+		if t.Reason != "" {
+			line += " (" + t.Reason + ")"
+		}
+		lines = append(lines, line)
+	}
+
+	return lipgloss.NewStyle().
+		Padding(0, 1).
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(lipgloss.Color("240")).
+		Render(strings.Join(lines, "\n"))
+}
+
 // helpView returns the help text showing keyboard shortcuts
 func (m Model) helpView() string {
 	var helpEntries []string
@@ -222,6 +268,7 @@ func (m Model) helpView() string {
 		// When focused, show only navigation commands
 		helpEntries = []string{
 			"↑/↓: navigate",
+			"enter: toggle history",
 			"esc: toggle focus",
 		}
 	} else {
@@ -297,10 +344,15 @@ func (m *Model) UpdateDownloads(queues []*controller.QueueController) {
 			}
 		}
 
+		status := formatStatus(download.Status)
+		if download.Status == controller.PAUSED && download.PauseReason != "" {
+			status += " (" + download.PauseReason + ")"
+		}
+
 		row := table.Row{
 			displayUrl,
 			queueName,
-			formatStatus(download.Status),
+			status,
 			fmt.Sprintf("%.1f%%", progress),
 			fmt.Sprintf("%.1f KB/s", speedKBps),
 		}
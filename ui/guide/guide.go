@@ -21,6 +21,7 @@ func NewModel() Model {
 	rows := []table.Row{
 		{"Tab", "Switch to next tab"},
 		{"ESC", "Toggle focus on the current tab’s table"},
+		{"Enter", "Show the status history of a download (Downloads)"},
 		{"Q", "Quit the application"},
 		{"Ctrl+C", "Quit the application"},
 	}
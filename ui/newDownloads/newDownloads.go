@@ -1,8 +1,11 @@
 package newDownloads
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/textinput"
@@ -65,16 +68,28 @@ type NewDownloadModel struct {
 	focused            bool
 	activeInput        int
 	urlError           bool
-	downloadManager    *manager.DownloadManager
+	downloadManager    manager.Service
 	successMessage     string
 	showSuccessMessage bool
 	messageTimer       int
+
+	// duplicate is the download waiting for the user to choose what to do
+	// with it, after adding it found a duplicate.
+	duplicate *pendingDuplicate
+}
+
+// pendingDuplicate is a URL that duplicates a download or a file, and where
+// it was being added.
+type pendingDuplicate struct {
+	url   *url.URL
+	queue *controller.QueueController
+	err   *controller.DuplicateError
 }
 
 // Update NewModel to accept download manager
-func NewModel(dm *manager.DownloadManager) NewDownloadModel {
+func NewModel(dm manager.Service) NewDownloadModel {
 	urlInput := textinput.New()
-	urlInput.Placeholder = "Enter download URL..."
+	urlInput.Placeholder = "Enter download URL, or @FILE to import a URL list..."
 	urlInput.Focus()
 
 	return NewDownloadModel{
@@ -126,7 +141,15 @@ func (m NewDownloadModel) Update(msg tea.Msg) (NewDownloadModel, tea.Cmd) {
 	}
 
 	switch msg := msg.(type) {
+	case importDoneMsg:
+		m.successMessage = msg.String()
+		m.showSuccessMessage = true
+		m.messageTimer = 0
+
 	case tea.KeyMsg:
+		if m.duplicate != nil {
+			return m.resolveDuplicate(msg.String()), cmd
+		}
 		switch msg.String() {
 		case "f5":
 			m.activeInput = (m.activeInput + 1) % 2 // URL input + queue selection
@@ -146,38 +169,14 @@ func (m NewDownloadModel) Update(msg tea.Msg) (NewDownloadModel, tea.Cmd) {
 					return m, cmd
 				}
 
+				if strings.HasPrefix(strings.TrimSpace(m.urlInput.Value()), "@") {
+					return m, m.importList(m.queues[m.selectedQueue])
+				}
+
 				if m.validate() {
 					if urlStr := m.urlInput.Value(); urlStr != "" {
 						if parsedURL, err := url.Parse(urlStr); err == nil {
-							queue := m.queues[m.selectedQueue]
-							logs.Log(fmt.Sprintf("Creating new download controller for URL: %s with Queue ID: %s", parsedURL.String(), queue.QueueID))
-
-							// Create new download controller using manager
-							dc := m.downloadManager.NewDownloadController(parsedURL)
-							if dc != nil {
-								queue.AddDownload(dc)
-								logs.Log(fmt.Sprintf("Added download %s to queue %s", dc.ID, queue.QueueID))
-
-								// Save all queues to queues.json after adding download
-								if err := controller.SaveQueueControllers("queues.json", m.downloadManager.QueueList); err != nil {
-									logs.Log(fmt.Sprintf("Error saving queues: %v", err))
-
-								}
-
-								// Set success message
-								m.successMessage = fmt.Sprintf("Added '%s' to queue '%s'", dc.FileName, queue.QueueName)
-								m.showSuccessMessage = true
-								m.messageTimer = 0
-
-								// Clear input and reset validation
-								m.urlInput.SetValue("")
-								m.urlError = false
-							} else {
-								logs.Log("Failed to create download controller")
-								m.successMessage = "Failed to create download - check URL and try again."
-								m.showSuccessMessage = true
-								m.messageTimer = 0
-							}
+							m.addDownload(parsedURL, m.queues[m.selectedQueue], controller.DUPLICATE_ASK)
 						}
 					}
 				}
@@ -202,6 +201,133 @@ func (m NewDownloadModel) Update(msg tea.Msg) (NewDownloadModel, tea.Cmd) {
 	return m, cmd
 }
 
+// addDownload adds u to queue, or asks what to do when it is a duplicate.
+func (m *NewDownloadModel) addDownload(u *url.URL, queue *controller.QueueController, onDuplicate controller.DuplicateAction) {
+	logs.Log(fmt.Sprintf("Creating new download controller for URL: %s with Queue ID: %s", u.String(), queue.QueueID))
+
+	// Create the download and add it to the queue using the manager
+	dc, err := m.downloadManager.AddDownload(queue.QueueID, u, onDuplicate)
+	m.showSuccessMessage = true
+	m.messageTimer = 0
+
+	var duplicate *controller.DuplicateError
+	switch {
+	case errors.As(err, &duplicate) && onDuplicate == controller.DUPLICATE_ASK:
+		m.duplicate = &pendingDuplicate{url: u, queue: queue, err: duplicate}
+		m.successMessage = duplicate.Error()
+		return
+	case errors.As(err, &duplicate):
+		m.successMessage = "Skipped: " + duplicate.Error()
+	case dc != nil:
+		logs.Log(fmt.Sprintf("Added download %s to queue %s", dc.ID, queue.QueueID))
+		if err != nil {
+			logs.Log(fmt.Sprintf("Error saving download: %v", err))
+		}
+
+		// Set success message
+		switch onDuplicate {
+		case controller.DUPLICATE_REDOWNLOAD:
+			m.successMessage = fmt.Sprintf("Downloading '%s' again", dc.FileName)
+		case controller.DUPLICATE_RESUME:
+			m.successMessage = fmt.Sprintf("Continuing '%s'", dc.FileName)
+		default:
+			m.successMessage = fmt.Sprintf("Added '%s' to queue '%s'", dc.FileName, queue.QueueName)
+		}
+	default:
+		logs.Log(fmt.Sprintf("Failed to create download controller: %v", err))
+		m.successMessage = "Failed to create download - check URL and try again."
+		return
+	}
+
+	// Clear input and reset validation
+	m.urlInput.SetValue("")
+	m.urlError = false
+}
+
+// resolveDuplicate handles a key pressed while a duplicate awaits a choice.
+func (m NewDownloadModel) resolveDuplicate(key string) NewDownloadModel {
+	pending := m.duplicate
+	var action controller.DuplicateAction
+	switch key {
+	case "s", "esc":
+		action = controller.DUPLICATE_SKIP
+	case "r":
+		action = controller.DUPLICATE_REDOWNLOAD
+	case "a":
+		action = controller.DUPLICATE_RESUME
+	default:
+		return m
+	}
+	m.duplicate = nil
+	if action == controller.DUPLICATE_SKIP {
+		logs.Log(fmt.Sprintf("Skipped duplicate download of %s", pending.url))
+		m.successMessage = "Skipped: " + pending.err.Error()
+		m.messageTimer = 0
+		m.urlInput.SetValue("")
+		return m
+	}
+	m.addDownload(pending.url, pending.queue, action)
+	return m
+}
+
+// importDoneMsg reports the outcome of an @FILE import.
+type importDoneMsg struct {
+	path      string
+	queueName string
+	added     int
+	err       error
+}
+
+func (msg importDoneMsg) String() string {
+	if msg.err == nil {
+		return fmt.Sprintf("Imported %d downloads from %s into queue '%s'", msg.added, msg.path, msg.queueName)
+	}
+	// Show the first problem; there may be one per line of the list
+	problems := strings.Split(msg.err.Error(), "\n")
+	text := fmt.Sprintf("Imported %d downloads from %s into queue '%s'; %s", msg.added, msg.path, msg.queueName, problems[0])
+	if len(problems) > 1 {
+		text += fmt.Sprintf(" (and %d more problems)", len(problems)-1)
+	}
+	return text
+}
+
+// importList imports the URL list named after the "@" in the URL input into
+// queue. Reading and probing happen in the background.
+func (m *NewDownloadModel) importList(queue *controller.QueueController) tea.Cmd {
+	path := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(m.urlInput.Value()), "@"))
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, rest)
+		}
+	}
+	logs.Log(fmt.Sprintf("Importing URL list %s into queue %s", path, queue.QueueID))
+
+	m.successMessage = fmt.Sprintf("Importing %s into queue '%s'...", path, queue.QueueName)
+	m.showSuccessMessage = true
+	m.messageTimer = 0
+	m.urlInput.SetValue("")
+	m.urlError = false
+
+	service, queueID := m.downloadManager, queue.QueueID
+	done := importDoneMsg{path: path, queueName: queue.QueueName}
+	return func() tea.Msg {
+		file, err := os.Open(path)
+		if err != nil {
+			done.err = err
+			return done
+		}
+		defer file.Close()
+
+		entries, err := manager.ParseURLList(file)
+		if err != nil {
+			done.err = err
+			return done
+		}
+		done.added, done.err = service.ImportDownloads(queueID, entries)
+		return done
+	}
+}
+
 func (m NewDownloadModel) View() string {
 	// Create a fixed-size container for consistent rendering
 	containerStyle := lipgloss.NewStyle().
@@ -261,8 +387,17 @@ func (m NewDownloadModel) View() string {
 		view.WriteString(queueBox.Render(queueContent.String()) + "\n\n")
 	}
 
-	// Show success message if needed
-	if m.showSuccessMessage {
+	// Ask about a duplicate until it is resolved
+	if m.duplicate != nil {
+		duplicateStyle := lipgloss.NewStyle().
+			Foreground(lipgloss.Color("214")).
+			Bold(true).
+			Padding(1).
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(lipgloss.Color("214"))
+		prompt := m.duplicate.err.Error() + "\n\n[s] skip   [r] re-download   [a] resume / attach"
+		view.WriteString(duplicateStyle.Render(prompt) + "\n\n")
+	} else if m.showSuccessMessage {
 		successStyle := lipgloss.NewStyle().
 			Foreground(lipgloss.Color("42")).
 			Bold(true).
@@ -279,7 +414,7 @@ func (m NewDownloadModel) View() string {
 		Align(lipgloss.Center).
 		Width(m.urlInput.Width + 16)
 
-	hint := "Press Enter to add download | Press F5 to switch input and queue"
+	hint := "Press Enter to add download (@FILE imports a list) | Press F5 to switch input and queue"
 	view.WriteString(hintStyle.Render(hint))
 
 	// Wrap in the container for consistent sizing
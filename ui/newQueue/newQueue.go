@@ -2,16 +2,17 @@ package newQueue
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/mjghr/tech-download-manager/config"
 	"github.com/mjghr/tech-download-manager/controller"
 	"github.com/mjghr/tech-download-manager/manager"
 	"github.com/mjghr/tech-download-manager/ui/logs"
-	"github.com/mjghr/tech-download-manager/util"
 )
 
 // Setup styles for different input states
@@ -51,17 +52,20 @@ type NewQueueModel struct {
 	savePathInput           textinput.Model
 	concurrentDownloadInput textinput.Model
 	speedLimitInput         textinput.Model
+	scheduleInput           textinput.Model
+	orderIndex              int // index into controller.ORDER_POLICIES
 	focused                 bool
 	activeInput             int
 	nameError               bool
-	downloadManager         *manager.DownloadManager
+	scheduleError           string
+	downloadManager         manager.Service
 	successMessage          string
 	showSuccessMessage      bool
 	messageTimer            int
 }
 
 // NewModel initializes a new queue model
-func NewModel(dm *manager.DownloadManager) NewQueueModel {
+func NewModel(dm manager.Service) NewQueueModel {
 	nameInput := textinput.New()
 	nameInput.Placeholder = "Enter queue name..."
 	nameInput.Focus()
@@ -75,11 +79,20 @@ func NewModel(dm *manager.DownloadManager) NewQueueModel {
 	speedLimitInput := textinput.New()
 	speedLimitInput.Placeholder = "Enter speed limit in KB/s (optional)..."
 
+	scheduleInput := textinput.New()
+	scheduleInput.Placeholder = "e.g. 01:00-07:00; mon-fri; tz=Europe/Berlin (optional)..."
+
+	// Start from the configured order, which main has validated
+	order, _ := controller.ParseOrderPolicy(config.Get().Queue.Order)
+	orderIndex := max(slices.Index(controller.ORDER_POLICIES, order), 0)
+
 	return NewQueueModel{
 		nameInput:               nameInput,
 		savePathInput:           savePathInput,
 		concurrentDownloadInput: concurrentDownloadInput,
 		speedLimitInput:         speedLimitInput,
+		scheduleInput:           scheduleInput,
+		orderIndex:              orderIndex,
 		focused:                 true,
 		activeInput:             0,
 		nameError:               false,
@@ -99,6 +112,14 @@ func (m *NewQueueModel) validate() bool {
 		return false
 	}
 	m.nameError = false
+
+	m.scheduleError = ""
+	if spec := m.scheduleInput.Value(); spec != "" {
+		if _, err := controller.ParseSchedule(spec); err != nil {
+			m.scheduleError = err.Error()
+			return false
+		}
+	}
 	return true
 }
 
@@ -118,14 +139,25 @@ func (m NewQueueModel) Update(msg tea.Msg) (NewQueueModel, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		switch msg.String() {
+		case "left", "right":
+			// The order selector has no text to move through
+			if m.activeInput == 5 {
+				step := 1
+				if msg.String() == "left" {
+					step = len(controller.ORDER_POLICIES) - 1
+				}
+				m.orderIndex = (m.orderIndex + step) % len(controller.ORDER_POLICIES)
+			}
+
 		case "f6":
-			// Cycle through inputs: name -> savePath -> concurrentDownload -> speedLimit
-			m.activeInput = (m.activeInput + 1) % 4
+			// Cycle through inputs: name -> savePath -> concurrentDownload -> speedLimit -> schedule -> order
+			m.activeInput = (m.activeInput + 1) % 6
 
 			m.nameInput.Blur()
 			m.savePathInput.Blur()
 			m.concurrentDownloadInput.Blur()
 			m.speedLimitInput.Blur()
+			m.scheduleInput.Blur()
 
 			switch m.activeInput {
 			case 0:
@@ -136,6 +168,8 @@ func (m NewQueueModel) Update(msg tea.Msg) (NewQueueModel, tea.Cmd) {
 				m.concurrentDownloadInput.Focus()
 			case 3:
 				m.speedLimitInput.Focus()
+			case 4:
+				m.scheduleInput.Focus()
 			}
 
 		case "enter":
@@ -147,14 +181,15 @@ func (m NewQueueModel) Update(msg tea.Msg) (NewQueueModel, tea.Cmd) {
 				queueCtrl := controller.NewQueueController(queueName)
 
 				// Get optional inputs
+				cfg := config.Get()
 				savePath := m.savePathInput.Value()
 				if savePath == "" {
-					savePath = util.GiveDefaultSavePath()
+					savePath = cfg.Paths.SaveDir
 				}
 
 				// Default values
-				concurrentLimit := 1
-				speedLimit := 100 * 1024 // 100KB/s
+				concurrentLimit := cfg.Queue.ConcurrentDownloadLimit
+				speedLimit := cfg.Queue.SpeedLimitKB * 1024
 
 				// Parse concurrent download limit if provided
 				if m.concurrentDownloadInput.Value() != "" {
@@ -181,22 +216,22 @@ func (m NewQueueModel) Update(msg tea.Msg) (NewQueueModel, tea.Cmd) {
 					savePath,
 					concurrentLimit,
 					speedLimit,
-					time.Now(),                   // Start time is now
-					time.Now().Add(24*time.Hour), // End time is 24 hours from now
+					time.Now(),                       // Start time is now
+					time.Now().Add(cfg.Queue.Window), // End time is one window from now
 				)
+				if spec := m.scheduleInput.Value(); spec != "" {
+					// Already checked by validate
+					schedule, _ := controller.ParseSchedule(spec)
+					queueCtrl.SetSchedule(schedule)
+				}
+				queueCtrl.SetOrder(controller.ORDER_POLICIES[m.orderIndex])
 
-				// Add the queue to the download manager
-				m.downloadManager.AddQueue(queueCtrl)
-
-				// Log the number of queues in the download manager for debugging
+				// Add the queue to the download manager and persist it
 				logs.Log(fmt.Sprintf("Created new queue: %s with ID: %s", queueName, queueCtrl.QueueID))
-				logs.Log(fmt.Sprintf("Download manager now has %d queues", len(m.downloadManager.QueueList)))
-
-				// Save all queues to queues.json
-				if err := controller.SaveQueueControllers("queues.json", m.downloadManager.QueueList); err != nil {
-					logs.Log(fmt.Sprintf("Error saving queues: %v", err))
+				if err := m.downloadManager.CreateQueue(queueCtrl); err != nil {
+					logs.Log(fmt.Sprintf("Error saving queue: %v", err))
 				} else {
-					logs.Log("Queues saved successfully to queues.json")
+					logs.Log("Queue saved successfully")
 				}
 
 				// Set success message
@@ -209,6 +244,7 @@ func (m NewQueueModel) Update(msg tea.Msg) (NewQueueModel, tea.Cmd) {
 				m.savePathInput.SetValue("")
 				m.concurrentDownloadInput.SetValue("")
 				m.speedLimitInput.SetValue("")
+				m.scheduleInput.SetValue("")
 				m.nameError = false
 			}
 		}
@@ -225,6 +261,8 @@ func (m NewQueueModel) Update(msg tea.Msg) (NewQueueModel, tea.Cmd) {
 			m.concurrentDownloadInput, cmd = m.concurrentDownloadInput.Update(msg)
 		case 3:
 			m.speedLimitInput, cmd = m.speedLimitInput.Update(msg)
+		case 4:
+			m.scheduleInput, cmd = m.scheduleInput.Update(msg)
 		}
 	}
 
@@ -283,6 +321,28 @@ func (m NewQueueModel) View() string {
 	}
 	view.WriteString(speedView + "\n\n")
 
+	// Optional recurring schedule input
+	view.WriteString(labelStyle.Render("Recurring Schedule (optional):") + "\n")
+	scheduleView := m.scheduleInput.View()
+	if m.scheduleError != "" {
+		scheduleView = errorStyle.Render(scheduleView) + "\n" + lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Render(m.scheduleError)
+	} else if m.scheduleInput.Focused() {
+		scheduleView = focusedStyle.Render(scheduleView)
+	} else {
+		scheduleView = blurredStyle.Render(scheduleView)
+	}
+	view.WriteString(scheduleView + "\n\n")
+
+	// Order policy selector
+	view.WriteString(labelStyle.Render("Download Order (←/→ to change):") + "\n")
+	orderView := fmt.Sprintf("< %s >", controller.ORDER_POLICIES[m.orderIndex])
+	if m.focused && m.activeInput == 5 {
+		orderView = focusedStyle.Render(orderView)
+	} else {
+		orderView = blurredStyle.Render(orderView)
+	}
+	view.WriteString(orderView + "\n\n")
+
 	// Show success message if needed
 	if m.showSuccessMessage {
 		successStyle := lipgloss.NewStyle().
@@ -314,6 +374,7 @@ func (m *NewQueueModel) SetSize(width, height int) {
 	m.savePathInput.Width = width - 4
 	m.concurrentDownloadInput.Width = width - 4
 	m.speedLimitInput.Width = width - 4
+	m.scheduleInput.Width = width - 4
 }
 
 // ToggleFocus toggles focus state
@@ -329,11 +390,14 @@ func (m *NewQueueModel) ToggleFocus() {
 			m.concurrentDownloadInput.Focus()
 		case 3:
 			m.speedLimitInput.Focus()
+		case 4:
+			m.scheduleInput.Focus()
 		}
 	} else {
 		m.nameInput.Blur()
 		m.savePathInput.Blur()
 		m.concurrentDownloadInput.Blur()
 		m.speedLimitInput.Blur()
+		m.scheduleInput.Blur()
 	}
 }
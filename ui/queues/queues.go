@@ -2,13 +2,18 @@ package queues
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/charmbracelet/bubbles/table"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/mjghr/tech-download-manager/controller"
+	"github.com/mjghr/tech-download-manager/manager"
 	"github.com/mjghr/tech-download-manager/ui/logs"
 )
 
@@ -23,11 +28,25 @@ type Model struct {
 	statusMessage string
 	showStatus    bool
 	statusExpiry  time.Time
+	service       manager.Service
+
+	// prompt asks for a bundle file name while promptAction is "export" or
+	// "import", and for the queue to move promptDownload to while it is
+	// "move"
+	prompt         textinput.Model
+	promptAction   string
+	promptDownload string
 }
 
 // NewModel creates a new model for the queues tab
-func NewModel() Model {
+func NewModel(service manager.Service) Model {
+	prompt := textinput.New()
+	prompt.CharLimit = 4096
+	prompt.Width = 60
+
 	return Model{
+		prompt:        prompt,
+		service:       service,
 		tables:        make([]table.Model, 0),
 		focused:       true,
 		activeTable:   0,
@@ -52,11 +71,33 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 	}
 
 	switch msg := msg.(type) {
+	case bundleImportDoneMsg:
+		m.statusMessage = msg.String()
+		m.showStatus = true
+		m.statusExpiry = now.Add(5 * time.Second)
+
 	case tea.KeyMsg:
 		// Log key presses for debugging
 		logs.Log(fmt.Sprintf("Key pressed in queues tab: %s, activeTable: %d, queues: %d",
 			msg.String(), m.activeTable, len(m.queues)))
 
+		if m.promptAction != "" {
+			return m.updatePrompt(msg)
+		}
+		if m.focused && (msg.String() == "e" && len(m.queues) > 0 || msg.String() == "i") {
+			m.openPrompt(msg.String())
+			return m, textinput.Blink
+		}
+		if m.focused && m.activeTable < len(m.tables) && m.tables[m.activeTable].SelectedRow() != nil {
+			if msg.String() == "m" {
+				m.openPrompt("m")
+				return m, textinput.Blink
+			}
+			if m.orderAction(msg.String()) {
+				return m, nil
+			}
+		}
+
 		// Handle function keys specially - don't rely on the sub-tables
 		if m.focused && len(m.queues) > 0 && (msg.String() == "f1" || msg.String() == "f2" || msg.String() == "f3" || msg.String() == "f4") {
 			// Make sure activeTable is within bounds
@@ -73,7 +114,7 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 			case "f1":
 				// Start all downloads in the queue
 				logs.Log(fmt.Sprintf("Attempting to start all downloads in queue: %s", queue.QueueName))
-				err := queue.Start()
+				err := m.service.StartQueue(queue.QueueID)
 				if err != nil {
 					logs.Log(fmt.Sprintf("Error starting queue: %v", err))
 					m.statusMessage = fmt.Sprintf("Error: %v", err)
@@ -86,23 +127,31 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 			case "f2":
 				// Pause all downloads in the queue
 				logs.Log(fmt.Sprintf("Attempting to pause all downloads in queue: %s", queue.QueueName))
-				queue.PauseAll()
-				logs.Log(fmt.Sprintf("Paused all downloads in queue: %s", queue.QueueName))
-				m.statusMessage = "Paused all downloads in queue"
+				if err := m.service.PauseQueue(queue.QueueID); err != nil {
+					logs.Log(fmt.Sprintf("Error pausing queue: %v", err))
+					m.statusMessage = fmt.Sprintf("Error: %v", err)
+				} else {
+					logs.Log(fmt.Sprintf("Paused all downloads in queue: %s", queue.QueueName))
+					m.statusMessage = "Paused all downloads in queue"
+				}
 				m.showStatus = true
 				m.statusExpiry = now.Add(3 * time.Second)
 			case "f3":
 				// Resume all downloads in the queue
 				logs.Log(fmt.Sprintf("Attempting to resume all downloads in queue: %s", queue.QueueName))
-				queue.ResumeAll()
-				logs.Log(fmt.Sprintf("Resumed all downloads in queue: %s", queue.QueueName))
-				m.statusMessage = "Resumed all downloads in queue"
+				if err := m.service.ResumeQueue(queue.QueueID); err != nil {
+					logs.Log(fmt.Sprintf("Error resuming queue: %v", err))
+					m.statusMessage = fmt.Sprintf("Error: %v", err)
+				} else {
+					logs.Log(fmt.Sprintf("Resumed all downloads in queue: %s", queue.QueueName))
+					m.statusMessage = "Resumed all downloads in queue"
+				}
 				m.showStatus = true
 				m.statusExpiry = now.Add(3 * time.Second)
 			case "f4":
 				// Cancel all downloads in the queue
 				logs.Log(fmt.Sprintf("Attempting to cancel all downloads in queue: %s", queue.QueueName))
-				err := queue.CancelAll()
+				err := m.service.CancelQueue(queue.QueueID)
 				if err != nil {
 					logs.Log(fmt.Sprintf("Error cancelling queue: %v", err))
 					m.statusMessage = fmt.Sprintf("Error: %v", err)
@@ -142,6 +191,183 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 	return m, cmd
 }
 
+// orderAction moves the selected download or changes its priority. It
+// reports false for keys that do neither.
+func (m *Model) orderAction(key string) bool {
+	queue := m.queues[m.activeTable]
+	id := m.tables[m.activeTable].SelectedRow()[0]
+	index := slices.IndexFunc(queue.DownloadControllers, func(dc *controller.DownloadController) bool { return dc.ID == id })
+	if index < 0 {
+		return false
+	}
+	download := queue.DownloadControllers[index]
+
+	var err error
+	switch key {
+	case "u":
+		err = m.service.MoveDownload(download.ID, index-1)
+	case "d":
+		err = m.service.MoveDownload(download.ID, index+1)
+	case "t":
+		err = m.service.MoveDownload(download.ID, 0)
+	case "b":
+		err = m.service.MoveDownload(download.ID, len(queue.DownloadControllers)-1)
+	case "+":
+		err = m.service.SetDownloadPriority(download.ID, download.Priority+1)
+	case "-":
+		err = m.service.SetDownloadPriority(download.ID, download.Priority-1)
+	default:
+		return false
+	}
+
+	if err != nil {
+		logs.Log(fmt.Sprintf("Error reordering download %s: %v", download.ID, err))
+		m.statusMessage = fmt.Sprintf("Error: %v", err)
+		m.showStatus = true
+		m.statusExpiry = time.Now().Add(3 * time.Second)
+	}
+	// Show the new order right away; the selection follows the download
+	m.UpdateQueues(m.service.Queues())
+	return true
+}
+
+// openPrompt asks for the bundle file to export the active queue to, or to
+// import a queue from, or for the queue to move the selected download to.
+func (m *Model) openPrompt(key string) {
+	m.prompt.Reset()
+	if key == "m" {
+		m.promptAction = "move"
+		m.promptDownload = m.tables[m.activeTable].SelectedRow()[0]
+		m.prompt.Placeholder = "name of the queue to move the download to"
+	} else if key == "e" {
+		m.promptAction = "export"
+		m.prompt.Placeholder = "file to export the queue to"
+		name := m.queues[m.activeTable].QueueName + ".tdmq.json"
+		if dir, err := os.Getwd(); err == nil {
+			name = filepath.Join(dir, name)
+		}
+		m.prompt.SetValue(name)
+	} else {
+		m.promptAction = "import"
+		m.prompt.Placeholder = "queue bundle to import"
+	}
+	m.prompt.Focus()
+}
+
+func (m Model) updatePrompt(msg tea.KeyMsg) (Model, tea.Cmd) {
+	var cmd tea.Cmd
+	switch msg.String() {
+	case "esc":
+		// The app toggled focus away on esc; closing the prompt keeps it
+		m.promptAction = ""
+		m.prompt.Blur()
+		if !m.focused {
+			m.ToggleFocus()
+		}
+	case "enter":
+		value := strings.TrimSpace(m.prompt.Value())
+		if value == "" {
+			return m, nil
+		}
+		action := m.promptAction
+		m.promptAction = ""
+		m.prompt.Blur()
+		m.showStatus = true
+		m.statusExpiry = time.Now().Add(5 * time.Second)
+		if action == "move" {
+			if err := m.service.MoveDownloadToQueue(m.promptDownload, value); err != nil {
+				m.statusMessage = fmt.Sprintf("Error: %v", err)
+			} else {
+				m.statusMessage = fmt.Sprintf("Moved download %s to queue %s", m.promptDownload, value)
+			}
+			return m, nil
+		}
+		path := expandHome(value)
+		if action == "export" {
+			m.statusMessage = exportQueue(m.queues[m.activeTable], path)
+			return m, nil
+		}
+		m.statusMessage = fmt.Sprintf("Importing queue bundle %s...", path)
+		return m, importBundle(m.service, path)
+	default:
+		m.prompt, cmd = m.prompt.Update(msg)
+	}
+	return m, cmd
+}
+
+// exportQueue writes a queue bundle and returns a status message.
+func exportQueue(queue *controller.QueueController, path string) string {
+	file, err := os.Create(path)
+	if err == nil {
+		err = controller.NewQueueBundle(queue).Write(file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		logs.Log(fmt.Sprintf("Error exporting queue %s: %v", queue.QueueID, err))
+		return fmt.Sprintf("Error: %v", err)
+	}
+	logs.Log(fmt.Sprintf("Exported queue %s to %s", queue.QueueID, path))
+	return fmt.Sprintf("Exported queue '%s' to %s", queue.QueueName, path)
+}
+
+// bundleImportDoneMsg reports the outcome of a bundle import.
+type bundleImportDoneMsg struct {
+	path  string
+	queue *controller.QueueController
+	err   error
+}
+
+func (msg bundleImportDoneMsg) String() string {
+	if msg.queue == nil {
+		return fmt.Sprintf("Error: could not import %s: %v", msg.path, msg.err)
+	}
+	text := fmt.Sprintf("Imported queue '%s' with %d downloads", msg.queue.QueueName, len(msg.queue.DownloadControllers))
+	if msg.err != nil {
+		// Show the first problem; there may be one per download
+		problems := strings.Split(msg.err.Error(), "\n")
+		text += "; " + problems[0]
+		if len(problems) > 1 {
+			text += fmt.Sprintf(" (and %d more problems)", len(problems)-1)
+		}
+	}
+	return text
+}
+
+// importBundle reads and imports a queue bundle in the background, as its
+// downloads are probed.
+func importBundle(service manager.Service, path string) tea.Cmd {
+	return func() tea.Msg {
+		done := bundleImportDoneMsg{path: path}
+		file, err := os.Open(path)
+		if err != nil {
+			done.err = err
+			return done
+		}
+		defer file.Close()
+
+		bundle, err := controller.ReadQueueBundle(file)
+		if err != nil {
+			done.err = err
+			return done
+		}
+		done.queue, done.err = service.ImportQueueBundle(bundle, "")
+		logs.Log(fmt.Sprintf("Imported queue bundle %s: %v", path, done.err))
+		return done
+	}
+}
+
+// expandHome replaces a leading "~/" with the user's home directory.
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
 // Add a helper function to create a queue info header
 func createQueueInfoHeader(queue *controller.QueueController) string {
 	return fmt.Sprintf(
@@ -154,6 +380,30 @@ func createQueueInfoHeader(queue *controller.QueueController) string {
 	)
 }
 
+// describeWindow tells when the queue next runs downloads, followed by its
+// recurring schedule if it has one.
+func describeWindow(queue *controller.QueueController, now time.Time) string {
+	const layout = "Mon Jan 2 15:04"
+	var window string
+	start, end, ok := queue.NextWindow(now)
+	switch {
+	case !ok:
+		window = "no upcoming window"
+	case start.After(now) && end.IsZero():
+		window = "opens " + start.Format(layout)
+	case start.After(now):
+		window = "next " + start.Format(layout) + " – " + end.Format(layout)
+	case end.IsZero():
+		window = "open"
+	default:
+		window = "open until " + end.Format(layout)
+	}
+	if queue.Schedule != nil {
+		window += " (" + queue.Schedule.String() + ")"
+	}
+	return window
+}
+
 // Helper function for time formatting
 func formatTime(t time.Time) string {
 	if t.IsZero() {
@@ -184,6 +434,14 @@ var emptyStateStyle = lipgloss.NewStyle().
 func (m *Model) UpdateQueues(queues []*controller.QueueController) {
 	logs.Log(fmt.Sprintf("UpdateQueues called with %d queues", len(queues)))
 
+	// Keep each queue's selection on the same download, wherever it moved
+	selected := make(map[string]string)
+	for i, t := range m.tables {
+		if row := t.SelectedRow(); row != nil && i < len(m.queues) {
+			selected[m.queues[i].QueueID] = row[0]
+		}
+	}
+
 	// Store the queues
 	m.queues = queues
 
@@ -212,6 +470,7 @@ func (m *Model) UpdateQueues(queues []*controller.QueueController) {
 		{Title: "Progress", Width: 15},
 		{Title: "Size", Width: 15},
 		{Title: "Speed", Width: 15},
+		{Title: "Priority", Width: 8},
 	}
 
 	for i, queue := range queues {
@@ -266,6 +525,9 @@ func (m *Model) UpdateQueues(queues []*controller.QueueController) {
 
 			// Format status with more engaging visual display
 			statusText := formatStatus(download.Status)
+			if download.Status == controller.PAUSED && download.PauseReason != "" {
+				statusText += " (" + download.PauseReason + ")"
+			}
 
 			row := table.Row{
 				download.ID,
@@ -274,11 +536,17 @@ func (m *Model) UpdateQueues(queues []*controller.QueueController) {
 				fmt.Sprintf("%.1f%%", progress),
 				fmt.Sprintf("%.2f MB", sizeMB),
 				fmt.Sprintf("%d KB/s", download.SpeedLimit/1024),
+				fmt.Sprintf("%d", download.Priority),
 			}
 			rows = append(rows, row)
 		}
 
 		t.SetRows(rows)
+		for r, download := range queue.DownloadControllers {
+			if download.ID == selected[queue.QueueID] {
+				t.SetCursor(r)
+			}
+		}
 		m.tables[i] = t
 		logs.Log(fmt.Sprintf("Table updated with %d rows for queue: %s (name: %s)",
 			len(rows), queue.QueueID, queue.QueueName))
@@ -301,6 +569,10 @@ func formatStatus(status controller.Status) string {
 		return "➤ Active"
 	case controller.CANCELED:
 		return "⊘ Cancelled"
+	case controller.PROBING:
+		return "… Probing"
+	case controller.VERIFYING:
+		return "◎ Verifying"
 
 	default:
 		return "? Unknown"
@@ -320,7 +592,12 @@ func (m Model) View() string {
 	var sb strings.Builder
 
 	if len(m.queues) == 0 {
-		emptyMessage := "No queues available\n\nUse the NewQueue tab to create a queue first."
+		emptyMessage := "No queues available\n\nUse the NewQueue tab to create a queue first,\nor press i to import a queue bundle."
+		if m.promptAction != "" {
+			emptyMessage = m.promptView()
+		} else if m.showStatus {
+			emptyMessage = m.statusMessage + "\n\n" + emptyMessage
+		}
 		return containerStyle.Render(emptyStateStyle.Render(emptyMessage))
 	}
 
@@ -375,6 +652,11 @@ func (m Model) View() string {
 		sb.WriteString("\n")
 	}
 
+	if m.promptAction != "" {
+		sb.WriteString(m.promptView())
+		sb.WriteString("\n")
+	}
+
 	// Display the selected queue details
 	if m.activeTable < len(m.queues) {
 		queue := m.queues[m.activeTable]
@@ -388,10 +670,12 @@ func (m Model) View() string {
 			Width(m.width - 20)
 
 		queueDetails := fmt.Sprintf(
-			"Speed: %d KB/s • Concurrent: %d • Path: %s",
+			"Speed: %d KB/s • Concurrent: %d • Order: %s • Path: %s • Window: %s",
 			queue.SpeedLimit/1024,
 			queue.ConcurrentDownloadLimit,
+			queue.GetOrder(),
 			queue.SavePath,
+			describeWindow(queue, time.Now()),
 		)
 
 		sb.WriteString(detailsBoxStyle.Render(queueDetails))
@@ -442,6 +726,22 @@ func (m Model) View() string {
 
 }
 
+// promptView renders the bundle file prompt.
+func (m Model) promptView() string {
+	label := "Export queue to:"
+	if m.promptAction == "import" {
+		label = "Import queue bundle from:"
+	} else if m.promptAction == "move" {
+		label = "Move download " + m.promptDownload + " to queue:"
+	}
+	return lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(lipgloss.Color("205")).
+		Padding(0, 1).
+		MarginBottom(1).
+		Render(headerStyle.Render(label) + "\n" + m.prompt.View() + "\n" + helpStyle.Render("Enter to confirm, Esc to cancel"))
+}
+
 // SetSize allows the parent model to pass the new window dimensions on resize.
 func (m *Model) SetSize(width, height int) {
 	m.width = width
@@ -484,4 +784,14 @@ Queue Actions:
   F2: Pause all downloads in queue
   F3: Resume all downloads in queue
   F4: Cancel all downloads in queue
+
+Download Order:
+  u/d: Move download up/down
+  t/b: Move download to top/bottom
+  +/-: Raise/lower download priority
+  m: Move download to another queue
+
+Sharing:
+  e: Export queue to a bundle file
+  i: Import a queue bundle
 `
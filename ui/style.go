@@ -21,6 +21,10 @@ var (
 			BorderForeground(lipgloss.Color("240")).
 			Align(lipgloss.Center)
 
+	WarningStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("214")).
+			Bold(true)
+
 	// Style for active tab name
 	ActiveTabStyle = lipgloss.NewStyle().
 			Bold(true).
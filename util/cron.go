@@ -0,0 +1,156 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed five-field cron expression: minute, hour, day of month,
+// month and day of week.
+type Cron struct {
+	minutes, hours, days, months, weekdays []bool
+	// As in cron, a day matches either field when both are restricted
+	anyDay, anyWeekday bool
+}
+
+var cronMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+var monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+
+// WEEKDAY_NAMES are the three-letter day names used by cron and schedules,
+// indexed by time.Weekday.
+var WEEKDAY_NAMES = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ParseCron parses a cron expression such as "0 1 * * 1-5" or "@daily".
+// Fields may use *, lists, ranges, steps and month or day names.
+func ParseCron(expr string) (*Cron, error) {
+	if macro, ok := cronMacros[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields: minute hour day month weekday", expr)
+	}
+
+	c := &Cron{anyDay: fields[2] == "*", anyWeekday: fields[4] == "*"}
+	var err error
+	if c.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if c.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if c.days, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if c.months, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	// 7 is Sunday too
+	if c.weekdays, err = parseCronField(fields[4], 0, 7, WEEKDAY_NAMES); err != nil {
+		return nil, fmt.Errorf("weekday: %w", err)
+	}
+	c.weekdays[0] = c.weekdays[0] || c.weekdays[7]
+	return c, nil
+}
+
+// Times returns the times the expression fires on the calendar day of day,
+// in day's location.
+func (c *Cron) Times(day time.Time) []time.Time {
+	y, m, d := day.Date()
+	if !c.months[m] {
+		return nil
+	}
+	dayMatch, weekdayMatch := c.days[d], c.weekdays[day.Weekday()]
+	switch {
+	case c.anyDay && c.anyWeekday:
+	case c.anyDay:
+		if !weekdayMatch {
+			return nil
+		}
+	case c.anyWeekday:
+		if !dayMatch {
+			return nil
+		}
+	default:
+		if !dayMatch && !weekdayMatch {
+			return nil
+		}
+	}
+
+	var times []time.Time
+	for hour := range 24 {
+		if !c.hours[hour] {
+			continue
+		}
+		for minute := range 60 {
+			if c.minutes[minute] {
+				times = append(times, time.Date(y, m, d, hour, minute, 0, 0, day.Location()))
+			}
+		}
+	}
+	return times
+}
+
+// parseCronField returns which values in [min, max] a field selects. names,
+// if given, are accepted in place of the numbers starting at min.
+func parseCronField(field string, min, max int, names []string) ([]bool, error) {
+	set := make([]bool, max+1)
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(s, name) {
+				return min + i, nil
+			}
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("%q is not between %d and %d", s, min, max)
+		}
+		return n, nil
+	}
+
+	for _, part := range strings.Split(field, ",") {
+		rangeText, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+
+		from, to := min, max
+		if rangeText != "*" {
+			startText, endText, isRange := strings.Cut(rangeText, "-")
+			var err error
+			if from, err = value(startText); err != nil {
+				return nil, err
+			}
+			to = from
+			if isRange {
+				if to, err = value(endText); err != nil {
+					return nil, err
+				}
+				if to < from {
+					return nil, fmt.Errorf("range %q is backwards", rangeText)
+				}
+			} else if hasStep {
+				to = max
+			}
+		}
+		for n := from; n <= to; n += step {
+			set[n] = true
+		}
+	}
+	return set, nil
+}
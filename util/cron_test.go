@@ -0,0 +1,63 @@
+package util
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCronTimes(t *testing.T) {
+	tests := []struct {
+		expr string
+		day  string
+		want []string
+	}{
+		// With both day fields restricted either one matching is enough
+		{"0 1 13 * 5", "2026-06-13", []string{"01:00"}}, // Saturday the 13th
+		{"0 1 13 * 5", "2026-06-05", []string{"01:00"}}, // Friday the 5th
+		{"0 1 13 * 5", "2026-06-15", nil},               // Monday the 15th
+		// With one restricted only that one counts
+		{"0 1 13 * *", "2026-06-05", nil},
+		{"0 1 13 * *", "2026-06-13", []string{"01:00"}},
+		{"0 1 * * fri", "2026-06-13", nil},
+		{"0 1 * * fri", "2026-06-19", []string{"01:00"}},
+		{"0 0 * * 7", "2026-06-07", []string{"00:00"}},
+		{"0 0 * jan *", "2026-06-07", nil},
+		{"*/30 9-10 * * *", "2026-06-01", []string{"09:00", "09:30", "10:00", "10:30"}},
+		{"15 8,20 * * mon-fri", "2026-06-01", []string{"08:15", "20:15"}},
+		{"@daily", "2026-06-01", []string{"00:00"}},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		day, _ := time.ParseInLocation(time.DateOnly, tt.day, time.UTC)
+		var got []string
+		for _, fire := range c.Times(day) {
+			got = append(got, fire.Format("15:04"))
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q on %s fires at %v, want %v", tt.expr, tt.day, got, tt.want)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"0 1 * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * * funday",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
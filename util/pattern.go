@@ -0,0 +1,190 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MAX_PATTERN_EXPANSION caps how many URLs a single pattern may produce.
+const MAX_PATTERN_EXPANSION = 100000
+
+// Expansion is one string produced by ExpandPattern together with the value
+// each glob took, for #N references.
+type Expansion struct {
+	Value  string
+	Values []string
+}
+
+// ExpandPattern expands curl-style globs: {a,b,c} alternatives and [1-10],
+// [001-250], [a-z] or [0-100:10] ranges. Globs vary from right to left, and
+// a backslash escapes the next character. Without globs the input is
+// returned as is.
+func ExpandPattern(pattern string) ([]Expansion, error) {
+	var literals []string
+	var globs [][]string
+	var literal strings.Builder
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				literal.WriteByte(pattern[i])
+			} else {
+				literal.WriteByte(c)
+			}
+		case '{', '[':
+			closing := byte('}')
+			if c == '[' {
+				closing = ']'
+			}
+			end := strings.IndexByte(pattern[i+1:], closing)
+			if end < 0 {
+				return nil, fmt.Errorf("unmatched %q at position %d", c, i+1)
+			}
+			body := pattern[i+1 : i+1+end]
+			// An IPv6 host such as http://[::1]/ is not a range
+			if c == '[' && strings.HasSuffix(pattern[:i], "://") {
+				literal.WriteString(pattern[i : i+end+2])
+				i += end + 1
+				continue
+			}
+			var values []string
+			var err error
+			if c == '{' {
+				values, err = alternatives(body)
+			} else {
+				values, err = expandRange(body)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid glob at position %d: %w", i+1, err)
+			}
+			literals = append(literals, literal.String())
+			literal.Reset()
+			globs = append(globs, values)
+			i += end + 1
+		case '}', ']':
+			return nil, fmt.Errorf("unmatched %q at position %d", c, i+1)
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	literals = append(literals, literal.String())
+
+	total := 1
+	for _, values := range globs {
+		total *= len(values)
+		if total > MAX_PATTERN_EXPANSION {
+			return nil, fmt.Errorf("pattern expands to more than %d URLs", MAX_PATTERN_EXPANSION)
+		}
+	}
+
+	expansions := make([]Expansion, 0, total)
+	indexes := make([]int, len(globs))
+	for n := 0; n < total; n++ {
+		var b strings.Builder
+		values := make([]string, len(globs))
+		for g, glob := range globs {
+			b.WriteString(literals[g])
+			values[g] = glob[indexes[g]]
+			b.WriteString(values[g])
+		}
+		b.WriteString(literals[len(globs)])
+		expansions = append(expansions, Expansion{Value: b.String(), Values: values})
+
+		// Advance like an odometer, last glob fastest
+		for g := len(globs) - 1; g >= 0; g-- {
+			indexes[g]++
+			if indexes[g] < len(globs[g]) {
+				break
+			}
+			indexes[g] = 0
+		}
+	}
+	return expansions, nil
+}
+
+// ReplaceGlobRefs replaces #1, #2, ... in s with the values of the
+// corresponding globs, as curl does for output file names.
+func ReplaceGlobRefs(s string, values []string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '#' || i+1 >= len(s) || s[i+1] < '0' || s[i+1] > '9' {
+			b.WriteByte(s[i])
+			continue
+		}
+		j := i + 1
+		for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			j++
+		}
+		n, _ := strconv.Atoi(s[i+1 : j])
+		if n < 1 || n > len(values) {
+			return "", fmt.Errorf("#%d does not refer to a glob, the pattern has %d", n, len(values))
+		}
+		b.WriteString(values[n-1])
+		i = j - 1
+	}
+	return b.String(), nil
+}
+
+func alternatives(body string) ([]string, error) {
+	if body == "" {
+		return nil, fmt.Errorf("empty {} set")
+	}
+	return strings.Split(body, ","), nil
+}
+
+// expandRange expands the body of a [start-end:step] range. Numeric ranges
+// keep the width of a zero-padded start.
+func expandRange(body string) ([]string, error) {
+	step := 1
+	if spec, stepText, ok := strings.Cut(body, ":"); ok {
+		var err error
+		if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+			return nil, fmt.Errorf("invalid step %q", stepText)
+		}
+		body = spec
+	}
+	start, end, ok := strings.Cut(body, "-")
+	if !ok || start == "" || end == "" {
+		return nil, fmt.Errorf("range %q must look like [1-10] or [a-z]", body)
+	}
+
+	if len(start) == 1 && len(end) == 1 && !isDigit(start[0]) && !isDigit(end[0]) {
+		from, to := start[0], end[0]
+		if from > to || isLower(from) != isLower(to) || !isLetter(from) || !isLetter(to) {
+			return nil, fmt.Errorf("invalid letter range %q", body)
+		}
+		var values []string
+		for c := int(from); c <= int(to); c += step {
+			values = append(values, string(rune(c)))
+		}
+		return values, nil
+	}
+
+	from, err := strconv.Atoi(start)
+	if err != nil || from < 0 {
+		return nil, fmt.Errorf("invalid range start %q", start)
+	}
+	to, err := strconv.Atoi(end)
+	if err != nil || to < from {
+		return nil, fmt.Errorf("invalid range end %q", end)
+	}
+	if (to-from)/step+1 > MAX_PATTERN_EXPANSION {
+		return nil, fmt.Errorf("range %q is too large", body)
+	}
+	width := 0
+	if len(start) > 1 && start[0] == '0' {
+		width = len(start)
+	}
+	var values []string
+	for n := from; n <= to; n += step {
+		values = append(values, fmt.Sprintf("%0*d", width, n))
+	}
+	return values, nil
+}
+
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+func isLower(c byte) bool  { return c >= 'a' && c <= 'z' }
+func isLetter(c byte) bool { return isLower(c) || (c >= 'A' && c <= 'Z') }
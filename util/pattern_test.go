@@ -0,0 +1,76 @@
+package util
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExpandPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"http://host/file.bin", []string{"http://host/file.bin"}},
+		{"f[1-3].bin", []string{"f1.bin", "f2.bin", "f3.bin"}},
+		{"f[08-11]", []string{"f08", "f09", "f10", "f11"}},
+		{"f[0-10:5]", []string{"f0", "f5", "f10"}},
+		{"[a-c]", []string{"a", "b", "c"}},
+		{"[A-E:2]", []string{"A", "C", "E"}},
+		{"{x,y}.{iso,sha}", []string{"x.iso", "x.sha", "y.iso", "y.sha"}},
+		{"{a,b}[1-2]", []string{"a1", "a2", "b1", "b2"}},
+		{`f\[1-2\].bin`, []string{"f[1-2].bin"}},
+		{"http://[::1]/f[1-2]", []string{"http://[::1]/f1", "http://[::1]/f2"}},
+	}
+	for _, tt := range tests {
+		expansions, err := ExpandPattern(tt.pattern)
+		if err != nil {
+			t.Errorf("ExpandPattern(%q): %v", tt.pattern, err)
+			continue
+		}
+		var got []string
+		for _, e := range expansions {
+			got = append(got, e.Value)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ExpandPattern(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestExpandPatternErrors(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"f[1-3", "unmatched"},
+		{"f1-3]", "unmatched"},
+		{"f{}", "empty"},
+		{"f[3-1]", "invalid range end"},
+		{"f[a-Z]", "invalid letter range"},
+		{"f[1-5:0]", "invalid step"},
+		// Caps on a single range and on the product of several
+		{"f[0-100000]", "too large"},
+		{"[1-1000][1-1000]", "more than"},
+	}
+	for _, tt := range tests {
+		_, err := ExpandPattern(tt.pattern)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ExpandPattern(%q) error = %v, want one containing %q", tt.pattern, err, tt.want)
+		}
+	}
+}
+
+func TestReplaceGlobRefs(t *testing.T) {
+	expansions, err := ExpandPattern("http://host/{a,b}/[01-02].bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReplaceGlobRefs("#1_#2.bin", expansions[1].Values)
+	if err != nil || got != "a_02.bin" {
+		t.Errorf("ReplaceGlobRefs = %q, %v, want a_02.bin", got, err)
+	}
+	if _, err := ReplaceGlobRefs("#3.bin", expansions[1].Values); err == nil {
+		t.Error("ReplaceGlobRefs accepted #3 for a pattern with 2 globs")
+	}
+}
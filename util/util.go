@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"net"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
 )
 
 func ExtractFileName(urlStr string) (string, error) {
@@ -22,7 +24,7 @@ func ExtractFileName(urlStr string) (string, error) {
 	}
 	return fileName, nil
 }
-func CalculateOptimalWorkersAndChunkSize(fileSize int) (int, int) {
+func CalculateOptimalWorkersAndChunkSize(fileSize, maxWorkers int) (int, int) {
 	availableCores := runtime.NumCPU()
 	if fileSize < 10*1024*1024 {
 		return 1, fileSize
@@ -31,6 +33,9 @@ func CalculateOptimalWorkersAndChunkSize(fileSize int) (int, int) {
 	if fileSize > 10*1024*1024*1024 {
 		workers = int(math.Min(float64(availableCores*2), float64(fileSize/(10*1024*1024))))
 	}
+	if maxWorkers > 0 && workers > maxWorkers {
+		workers = maxWorkers
+	}
 	chunkSize := fileSize / workers
 	if chunkSize < 1*1024*1024 {
 		chunkSize = 1 * 1024 * 1024
@@ -99,3 +104,41 @@ func GiveDefaultSavePath() string {
 
 	return savePath
 }
+
+// NormalizeURL returns rawURL in a canonical form, so that URLs naming the
+// same resource compare equal: the scheme and host are lower-cased, default
+// ports and the fragment are dropped, dot segments are resolved and query
+// parameters are sorted.
+func NormalizeURL(rawURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	} else {
+		cleaned := path.Clean(u.Path)
+		if strings.HasSuffix(u.Path, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+		u.Path = cleaned
+	}
+	u.RawPath = ""
+	u.RawQuery = u.Query().Encode()
+	u.Fragment, u.RawFragment = "", ""
+	return u.String(), nil
+}